@@ -0,0 +1,472 @@
+// Package albumdb provides access to the album table of the recordings
+// database created by sqlscripts/create-tables.sql.
+package albumdb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// ErrNotFound is returned when no album matches a lookup.
+var ErrNotFound = errors.New("no such album")
+
+// ErrNotDeleted is returned when restoring an album that was never
+// deleted.
+var ErrNotDeleted = errors.New("album is not deleted")
+
+// Album represents a row of the album table. Deleting an album only
+// sets DeletedAt, and the queries below skip albums where it is set.
+type Album struct {
+	ID        int64      `json:"id"`
+	Title     string     `json:"title"`
+	Artist    string     `json:"artist"`
+	Price     float32    `json:"price"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// PriceFloat64 returns a's price as the float64 of its DECIMAL(5,2)
+// value: 56.99, not the 56.9900016784668 that float64(a.Price) is.
+func (a Album) PriceFloat64() float64 {
+	// Round-trip through the decimal text, which the float32 holds to
+	// the cent.
+	f, _ := strconv.ParseFloat(strconv.FormatFloat(float64(a.Price), 'f', 2, 32), 64)
+	return f
+}
+
+// PriceFromFloat64 returns the Price of an Album costing price, the
+// inverse of PriceFloat64.
+func PriceFromFloat64(price float64) float32 {
+	return float32(price)
+}
+
+// Actions recorded in the album_history table.
+const (
+	ActionCreated  = "created"
+	ActionUpdated  = "updated"
+	ActionDeleted  = "deleted"
+	ActionRestored = "restored"
+)
+
+// Change represents a row of the album_history table: who did what to
+// an album and when, with the album as it was before and after. Before
+// is nil for a created album.
+type Change struct {
+	ID      int64
+	AlbumID int64
+	Action  string
+	Actor   string
+	Time    time.Time
+	Before  *Album
+	After   *Album
+}
+
+// albumColumns are the columns scanned by scanAlbum, in order.
+const albumColumns = "id, title, artist, price, created_at, updated_at, deleted_at"
+
+// ConfigFromEnv returns the connection properties for the recordings
+// database, reading the credentials from DBUSER and DBPASS.
+func ConfigFromEnv() *mysql.Config {
+	cfg := mysql.NewConfig()
+	cfg.User = os.Getenv("DBUSER")
+	cfg.Passwd = os.Getenv("DBPASS")
+	cfg.Net = "tcp"
+	cfg.Addr = "127.0.0.1:3306"
+	cfg.DBName = "recordings"
+	// Scan DATETIME columns into time.Time.
+	cfg.ParseTime = true
+	return cfg
+}
+
+// Open gets a database handle for cfg and pings it to make sure the
+// server is reachable.
+func Open(cfg *mysql.Config) (*sql.DB, error) {
+	db, err := sql.Open("mysql", cfg.FormatDSN())
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// DefaultTenant owns the albums of a Repository returned by New, and
+// every album that was in the table before it had a tenant_id column.
+const DefaultTenant = "default"
+
+// Repository runs the album queries against a database handle.
+// It replaces the package-level db variable the first version of
+// this program used.
+//
+// A Repository only sees the albums of one tenant: every query filters
+// on its tenant_id and every insert sets it, so one tenant's catalog
+// cannot reach another's.
+type Repository struct {
+	db     *sql.DB
+	tenant string
+	now    func() time.Time
+}
+
+// New returns a Repository using db for DefaultTenant.
+func New(db *sql.DB) *Repository {
+	return &Repository{db: db, tenant: DefaultTenant, now: time.Now}
+}
+
+// ForTenant returns a Repository sharing r's database handle but seeing
+// only the albums of tenant.
+func (r *Repository) ForTenant(tenant string) *Repository {
+	return &Repository{db: r.db, tenant: tenant, now: r.now}
+}
+
+// Tenant returns the tenant whose albums r sees.
+func (r *Repository) Tenant() string {
+	return r.tenant
+}
+
+// DB returns the underlying database handle.
+func (r *Repository) DB() *sql.DB {
+	return r.db
+}
+
+// Albums returns every album ordered by ID.
+func (r *Repository) Albums(ctx context.Context) ([]Album, error) {
+	albums, err := r.query(ctx, "SELECT "+albumColumns+" FROM album WHERE tenant_id = ? AND deleted_at IS NULL ORDER BY id", r.tenant)
+	if err != nil {
+		return nil, fmt.Errorf("albums: %w", err)
+	}
+	return albums, nil
+}
+
+// AlbumsByArtist queries for albums that have the specified artist name.
+func (r *Repository) AlbumsByArtist(ctx context.Context, name string) ([]Album, error) {
+	albums, err := r.query(ctx, "SELECT "+albumColumns+" FROM album WHERE tenant_id = ? AND artist = ? AND deleted_at IS NULL", r.tenant, name)
+	if err != nil {
+		return nil, fmt.Errorf("albumsByArtist %q: %w", name, err)
+	}
+	return albums, nil
+}
+
+// AlbumsByArtists queries for the albums of several artists in one
+// round trip, ordered by ID.
+func (r *Repository) AlbumsByArtists(ctx context.Context, names []string) ([]Album, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	placeholders := strings.Repeat("?, ", len(names)-1) + "?"
+	args := []any{r.tenant}
+	for _, name := range names {
+		args = append(args, name)
+	}
+	albums, err := r.query(ctx, "SELECT "+albumColumns+" FROM album WHERE tenant_id = ? AND artist IN ("+placeholders+") AND deleted_at IS NULL ORDER BY id", args...)
+	if err != nil {
+		return nil, fmt.Errorf("albumsByArtists %q: %w", names, err)
+	}
+	return albums, nil
+}
+
+// AlbumsByPriceRange queries for the albums priced from min to max
+// inclusive, ordered by ID.
+func (r *Repository) AlbumsByPriceRange(ctx context.Context, min, max float64) ([]Album, error) {
+	albums, err := r.query(ctx, "SELECT "+albumColumns+" FROM album WHERE tenant_id = ? AND price BETWEEN ? AND ? AND deleted_at IS NULL ORDER BY id", r.tenant, min, max)
+	if err != nil {
+		return nil, fmt.Errorf("albumsByPriceRange %v-%v: %w", min, max, err)
+	}
+	return albums, nil
+}
+
+// AlbumByID queries for the album with the specified ID.
+func (r *Repository) AlbumByID(ctx context.Context, id int64) (Album, error) {
+	row := r.db.QueryRowContext(ctx, "SELECT "+albumColumns+" FROM album WHERE tenant_id = ? AND id = ? AND deleted_at IS NULL", r.tenant, id)
+	alb, err := scanAlbum(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return alb, fmt.Errorf("albumsById %d: %w", id, ErrNotFound)
+		}
+		return alb, fmt.Errorf("albumsById %d: %w", id, err)
+	}
+	return alb, nil
+}
+
+// CountAlbums returns how many albums, not counting deleted ones, the
+// tenant has.
+func (r *Repository) CountAlbums(ctx context.Context) (int, error) {
+	var n int
+	if err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM album WHERE tenant_id = ? AND deleted_at IS NULL", r.tenant).Scan(&n); err != nil {
+		return 0, fmt.Errorf("countAlbums: %w", err)
+	}
+	return n, nil
+}
+
+// AddAlbum adds the specified album to the database on behalf of
+// actor, returning the new entry with its ID and timestamps.
+func (r *Repository) AddAlbum(ctx context.Context, actor string, alb Album) (Album, error) {
+	added, err := r.change(ctx, func(tx *sql.Tx, now time.Time) (Album, error) {
+		return r.insertAlbum(ctx, tx, actor, now, alb)
+	})
+	if err != nil {
+		return Album{}, fmt.Errorf("addAlbum: %w", err)
+	}
+	return added, nil
+}
+
+// AddAlbums adds the specified albums to the database on behalf of
+// actor in one transaction, so either all of them are added or none,
+// returning the new entries with their IDs and timestamps.
+func (r *Repository) AddAlbums(ctx context.Context, actor string, albs []Album) ([]Album, error) {
+	var added []Album
+	_, err := r.change(ctx, func(tx *sql.Tx, now time.Time) (Album, error) {
+		for _, alb := range albs {
+			alb, err := r.insertAlbum(ctx, tx, actor, now, alb)
+			if err != nil {
+				return Album{}, err
+			}
+			added = append(added, alb)
+		}
+		return Album{}, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("addAlbums: %w", err)
+	}
+	return added, nil
+}
+
+// UpdateAlbum replaces the title, artist and price of the album with
+// alb.ID on behalf of actor, returning it as stored.
+func (r *Repository) UpdateAlbum(ctx context.Context, actor string, alb Album) (Album, error) {
+	updated, err := r.change(ctx, func(tx *sql.Tx, now time.Time) (Album, error) {
+		before, err := r.lockAlbum(ctx, tx, alb.ID, false)
+		if err != nil {
+			return Album{}, err
+		}
+		after := alb
+		after.CreatedAt, after.UpdatedAt, after.DeletedAt = before.CreatedAt, now, nil
+		if _, err := tx.ExecContext(ctx, "UPDATE album SET title = ?, artist = ?, price = ?, updated_at = ? WHERE tenant_id = ? AND id = ?",
+			after.Title, after.Artist, after.Price, now, r.tenant, after.ID); err != nil {
+			return Album{}, err
+		}
+		return after, recordChange(ctx, tx, ActionUpdated, actor, now, &before, &after)
+	})
+	if err != nil {
+		return Album{}, fmt.Errorf("updateAlbum %d: %w", alb.ID, err)
+	}
+	return updated, nil
+}
+
+// DeleteAlbum marks the album with the specified ID deleted on behalf
+// of actor, returning it as it was.
+func (r *Repository) DeleteAlbum(ctx context.Context, actor string, id int64) (Album, error) {
+	deleted, err := r.change(ctx, func(tx *sql.Tx, now time.Time) (Album, error) {
+		before, err := r.lockAlbum(ctx, tx, id, false)
+		if err != nil {
+			return Album{}, err
+		}
+		after := before
+		after.DeletedAt = &now
+		if _, err := tx.ExecContext(ctx, "UPDATE album SET deleted_at = ? WHERE tenant_id = ? AND id = ?", now, r.tenant, id); err != nil {
+			return Album{}, err
+		}
+		return before, recordChange(ctx, tx, ActionDeleted, actor, now, &before, &after)
+	})
+	if err != nil {
+		return Album{}, fmt.Errorf("deleteAlbum %d: %w", id, err)
+	}
+	return deleted, nil
+}
+
+// RestoreAlbum undoes the deletion of the album with the specified ID
+// on behalf of actor, returning it as restored.
+func (r *Repository) RestoreAlbum(ctx context.Context, actor string, id int64) (Album, error) {
+	restored, err := r.change(ctx, func(tx *sql.Tx, now time.Time) (Album, error) {
+		before, err := r.lockAlbum(ctx, tx, id, true)
+		if err != nil {
+			return Album{}, err
+		}
+		if before.DeletedAt == nil {
+			return Album{}, ErrNotDeleted
+		}
+		after := before
+		after.UpdatedAt, after.DeletedAt = now, nil
+		if _, err := tx.ExecContext(ctx, "UPDATE album SET deleted_at = NULL, updated_at = ? WHERE tenant_id = ? AND id = ?", now, r.tenant, id); err != nil {
+			return Album{}, err
+		}
+		return after, recordChange(ctx, tx, ActionRestored, actor, now, &before, &after)
+	})
+	if err != nil {
+		return Album{}, fmt.Errorf("restoreAlbum %d: %w", id, err)
+	}
+	return restored, nil
+}
+
+// AlbumHistory returns the changes made to the album with the specified
+// ID, deleted or not, oldest first.
+func (r *Repository) AlbumHistory(ctx context.Context, id int64) ([]Change, error) {
+	var exists bool
+	if err := r.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM album WHERE tenant_id = ? AND id = ?)", r.tenant, id).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("albumHistory %d: %w", id, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("albumHistory %d: %w", id, ErrNotFound)
+	}
+
+	rows, err := r.db.QueryContext(ctx, "SELECT id, album_id, action, actor, changed_at, before_json, after_json FROM album_history WHERE album_id = ? ORDER BY id", id)
+	if err != nil {
+		return nil, fmt.Errorf("albumHistory %d: %w", id, err)
+	}
+	defer rows.Close()
+	var changes []Change
+	for rows.Next() {
+		var c Change
+		var before, after []byte
+		if err := rows.Scan(&c.ID, &c.AlbumID, &c.Action, &c.Actor, &c.Time, &before, &after); err != nil {
+			return nil, fmt.Errorf("albumHistory %d: %w", id, err)
+		}
+		if c.Before, err = decodeAlbum(before); err != nil {
+			return nil, fmt.Errorf("albumHistory %d: %w", id, err)
+		}
+		if c.After, err = decodeAlbum(after); err != nil {
+			return nil, fmt.Errorf("albumHistory %d: %w", id, err)
+		}
+		changes = append(changes, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("albumHistory %d: %w", id, err)
+	}
+	return changes, nil
+}
+
+// change runs fn in a transaction, so an album and its history row are
+// written together or not at all, and returns the album fn returns.
+func (r *Repository) change(ctx context.Context, fn func(tx *sql.Tx, now time.Time) (Album, error)) (Album, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Album{}, err
+	}
+	defer tx.Rollback() // does nothing once committed
+
+	// DATETIME(6) keeps microseconds; rounding here means the album
+	// returned is the album stored.
+	now := r.now().UTC().Truncate(time.Microsecond)
+	alb, err := fn(tx, now)
+	if err != nil {
+		return Album{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Album{}, err
+	}
+	return alb, nil
+}
+
+// insertAlbum inserts alb and the history row recording its creation.
+func (r *Repository) insertAlbum(ctx context.Context, tx *sql.Tx, actor string, now time.Time, alb Album) (Album, error) {
+	alb.CreatedAt, alb.UpdatedAt, alb.DeletedAt = now, now, nil
+	result, err := tx.ExecContext(ctx, "INSERT INTO album (tenant_id, title, artist, price, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)",
+		r.tenant, alb.Title, alb.Artist, alb.Price, now, now)
+	if err != nil {
+		return Album{}, err
+	}
+	if alb.ID, err = result.LastInsertId(); err != nil {
+		return Album{}, err
+	}
+	return alb, recordChange(ctx, tx, ActionCreated, actor, now, nil, &alb)
+}
+
+// lockAlbum reads the album with id for update. Deleted albums are only
+// found if includeDeleted is set.
+func (r *Repository) lockAlbum(ctx context.Context, tx *sql.Tx, id int64, includeDeleted bool) (Album, error) {
+	query := "SELECT " + albumColumns + " FROM album WHERE tenant_id = ? AND id = ?"
+	if !includeDeleted {
+		query += " AND deleted_at IS NULL"
+	}
+	alb, err := scanAlbum(tx.QueryRowContext(ctx, query+" FOR UPDATE", r.tenant, id))
+	if err == sql.ErrNoRows {
+		return alb, ErrNotFound
+	}
+	return alb, err
+}
+
+// recordChange adds a row to album_history.
+func recordChange(ctx context.Context, tx *sql.Tx, action, actor string, at time.Time, before, after *Album) error {
+	id := after.ID
+	beforeJSON, err := encodeAlbum(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := encodeAlbum(after)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO album_history (album_id, action, actor, changed_at, before_json, after_json) VALUES (?, ?, ?, ?, ?, ?)",
+		id, action, actor, at, beforeJSON, afterJSON)
+	return err
+}
+
+// encodeAlbum returns alb as JSON, or nil for a NULL column.
+func encodeAlbum(alb *Album) (any, error) {
+	if alb == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(alb)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func decodeAlbum(b []byte) (*Album, error) {
+	if b == nil {
+		return nil, nil
+	}
+	var alb Album
+	if err := json.Unmarshal(b, &alb); err != nil {
+		return nil, err
+	}
+	return &alb, nil
+}
+
+// rowScanner is a *sql.Row or *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanAlbum scans the albumColumns of a row.
+func scanAlbum(row rowScanner) (Album, error) {
+	var alb Album
+	err := row.Scan(&alb.ID, &alb.Title, &alb.Artist, &alb.Price, &alb.CreatedAt, &alb.UpdatedAt, &alb.DeletedAt)
+	return alb, err
+}
+
+// query runs a SELECT returning album rows and scans them into a slice.
+func (r *Repository) query(ctx context.Context, query string, args ...any) ([]Album, error) {
+	// An albums slice to hold data from returned rows.
+	var albums []Album
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	// Loop through rows, using Scan to assign column data to struct fields.
+	for rows.Next() {
+		alb, err := scanAlbum(rows)
+		if err != nil {
+			return nil, err
+		}
+		albums = append(albums, alb)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return albums, nil
+}
@@ -0,0 +1,247 @@
+package albumdb
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// testTime is the clock reading of every change made in tests.
+var testTime = time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+// newMock returns a repository of tenant backed by a mock database,
+// which checks when the test ends that every expected query was run and
+// no other.
+func newMock(t *testing.T, tenant string) (*Repository, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+	r := New(db).ForTenant(tenant)
+	r.now = func() time.Time { return testTime }
+	return r, mock
+}
+
+// exactly matches query and nothing more.
+func exactly(query string) string {
+	return "^" + regexp.QuoteMeta(query) + "$"
+}
+
+// albumRows returns rows of albumColumns holding albums.
+func albumRows(albums ...Album) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"id", "title", "artist", "price", "created_at", "updated_at", "deleted_at"})
+	for _, a := range albums {
+		var deletedAt driver.Value
+		if a.DeletedAt != nil {
+			deletedAt = *a.DeletedAt
+		}
+		rows.AddRow(a.ID, a.Title, a.Artist, a.Price, a.CreatedAt, a.UpdatedAt, deletedAt)
+	}
+	return rows
+}
+
+var (
+	created  = testTime.Add(-time.Hour)
+	jeru     = Album{ID: 2, Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99, CreatedAt: created, UpdatedAt: created}
+	jeruGone = Album{ID: 2, Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99, CreatedAt: created, UpdatedAt: created, DeletedAt: &created}
+)
+
+func TestReadsSeeOneTenantsLiveAlbums(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		args  []driver.Value
+		read  func(r *Repository) error
+	}{
+		{"Albums", "SELECT " + albumColumns + " FROM album WHERE tenant_id = ? AND deleted_at IS NULL ORDER BY id",
+			[]driver.Value{"blue-note"},
+			func(r *Repository) error { _, err := r.Albums(context.Background()); return err }},
+		{"AlbumsByArtist", "SELECT " + albumColumns + " FROM album WHERE tenant_id = ? AND artist = ? AND deleted_at IS NULL",
+			[]driver.Value{"blue-note", "Gerry Mulligan"},
+			func(r *Repository) error {
+				_, err := r.AlbumsByArtist(context.Background(), "Gerry Mulligan")
+				return err
+			}},
+		{"AlbumsByArtists", "SELECT " + albumColumns + " FROM album WHERE tenant_id = ? AND artist IN (?, ?) AND deleted_at IS NULL ORDER BY id",
+			[]driver.Value{"blue-note", "Gerry Mulligan", "John Coltrane"},
+			func(r *Repository) error {
+				_, err := r.AlbumsByArtists(context.Background(), []string{"Gerry Mulligan", "John Coltrane"})
+				return err
+			}},
+		{"AlbumsByPriceRange", "SELECT " + albumColumns + " FROM album WHERE tenant_id = ? AND price BETWEEN ? AND ? AND deleted_at IS NULL ORDER BY id",
+			[]driver.Value{"blue-note", 10.0, 20.0},
+			func(r *Repository) error { _, err := r.AlbumsByPriceRange(context.Background(), 10, 20); return err }},
+	}
+	for _, tt := range tests {
+		r, mock := newMock(t, "blue-note")
+		mock.ExpectQuery(exactly(tt.query)).WithArgs(tt.args...).WillReturnRows(albumRows(jeru))
+		if err := tt.read(r); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+}
+
+func TestAlbumByIDOfAnotherTenant(t *testing.T) {
+	r, mock := newMock(t, "blue-note")
+	mock.ExpectQuery(exactly("SELECT "+albumColumns+" FROM album WHERE tenant_id = ? AND id = ? AND deleted_at IS NULL")).
+		WithArgs("blue-note", 2).WillReturnRows(albumRows())
+
+	if _, err := r.AlbumByID(context.Background(), 2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("AlbumByID of another tenant's album = %v, want ErrNotFound", err)
+	}
+}
+
+func TestCountAlbums(t *testing.T) {
+	r, mock := newMock(t, "blue-note")
+	mock.ExpectQuery(exactly("SELECT COUNT(*) FROM album WHERE tenant_id = ? AND deleted_at IS NULL")).
+		WithArgs("blue-note").WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(2))
+
+	if n, err := r.CountAlbums(context.Background()); err != nil || n != 2 {
+		t.Errorf("CountAlbums = %d, %v", n, err)
+	}
+}
+
+func TestAddAlbumSetsTenant(t *testing.T) {
+	r, mock := newMock(t, "blue-note")
+	mock.ExpectBegin()
+	mock.ExpectExec(exactly("INSERT INTO album (tenant_id, title, artist, price, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)")).
+		WithArgs("blue-note", "Jeru", "Gerry Mulligan", float32(17.99), testTime, testTime).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectExec(`INSERT INTO album_history`).
+		WithArgs(int64(2), ActionCreated, "alice", testTime, nil, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	a, err := r.AddAlbum(context.Background(), "alice", Album{Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99})
+	if err != nil || a.ID != 2 || !a.CreatedAt.Equal(testTime) || a.DeletedAt != nil {
+		t.Errorf("AddAlbum = %+v, %v", a, err)
+	}
+}
+
+func TestWritesToAnotherTenantsAlbum(t *testing.T) {
+	lock := exactly("SELECT " + albumColumns + " FROM album WHERE tenant_id = ? AND id = ? AND deleted_at IS NULL FOR UPDATE")
+	for name, write := range map[string]func(r *Repository) error{
+		"UpdateAlbum": func(r *Repository) error {
+			_, err := r.UpdateAlbum(context.Background(), "alice", Album{ID: 2, Title: "Jeru (Live)"})
+			return err
+		},
+		"DeleteAlbum": func(r *Repository) error {
+			_, err := r.DeleteAlbum(context.Background(), "alice", 2)
+			return err
+		},
+	} {
+		r, mock := newMock(t, "blue-note")
+		mock.ExpectBegin()
+		// The album is not blue-note's, or is deleted, so nothing is
+		// found to change.
+		mock.ExpectQuery(lock).WithArgs("blue-note", 2).WillReturnRows(albumRows())
+		mock.ExpectRollback()
+
+		if err := write(r); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s of another tenant's album = %v, want ErrNotFound", name, err)
+		}
+	}
+}
+
+func TestDeleteAlbumIsSoft(t *testing.T) {
+	r, mock := newMock(t, "blue-note")
+	mock.ExpectBegin()
+	mock.ExpectQuery(exactly("SELECT "+albumColumns+" FROM album WHERE tenant_id = ? AND id = ? AND deleted_at IS NULL FOR UPDATE")).
+		WithArgs("blue-note", 2).WillReturnRows(albumRows(jeru))
+	mock.ExpectExec(exactly("UPDATE album SET deleted_at = ? WHERE tenant_id = ? AND id = ?")).
+		WithArgs(testTime, "blue-note", 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO album_history`).
+		WithArgs(int64(2), ActionDeleted, "alice", testTime, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	a, err := r.DeleteAlbum(context.Background(), "alice", 2)
+	if err != nil || a.DeletedAt != nil || a.Title != "Jeru" {
+		t.Errorf("DeleteAlbum = %+v, %v; want the album as it was", a, err)
+	}
+}
+
+func TestRestoreAlbum(t *testing.T) {
+	// Restoring finds deleted albums, of its own tenant only.
+	lock := exactly("SELECT " + albumColumns + " FROM album WHERE tenant_id = ? AND id = ? FOR UPDATE")
+
+	r, mock := newMock(t, "blue-note")
+	mock.ExpectBegin()
+	mock.ExpectQuery(lock).WithArgs("blue-note", 2).WillReturnRows(albumRows(jeruGone))
+	mock.ExpectExec(exactly("UPDATE album SET deleted_at = NULL, updated_at = ? WHERE tenant_id = ? AND id = ?")).
+		WithArgs(testTime, "blue-note", 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO album_history`).
+		WithArgs(int64(2), ActionRestored, "alice", testTime, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	a, err := r.RestoreAlbum(context.Background(), "alice", 2)
+	if err != nil || a.DeletedAt != nil || !a.UpdatedAt.Equal(testTime) {
+		t.Errorf("RestoreAlbum = %+v, %v", a, err)
+	}
+
+	r, mock = newMock(t, "blue-note")
+	mock.ExpectBegin()
+	mock.ExpectQuery(lock).WithArgs("blue-note", 2).WillReturnRows(albumRows(jeru))
+	mock.ExpectRollback()
+	if _, err := r.RestoreAlbum(context.Background(), "alice", 2); !errors.Is(err, ErrNotDeleted) {
+		t.Errorf("RestoreAlbum of a live album = %v, want ErrNotDeleted", err)
+	}
+
+	r, mock = newMock(t, "blue-note")
+	mock.ExpectBegin()
+	mock.ExpectQuery(lock).WithArgs("blue-note", 2).WillReturnRows(albumRows())
+	mock.ExpectRollback()
+	if _, err := r.RestoreAlbum(context.Background(), "alice", 2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("RestoreAlbum of another tenant's album = %v, want ErrNotFound", err)
+	}
+}
+
+func TestAlbumHistory(t *testing.T) {
+	exists := exactly("SELECT EXISTS(SELECT 1 FROM album WHERE tenant_id = ? AND id = ?)")
+
+	r, mock := newMock(t, "blue-note")
+	mock.ExpectQuery(exists).WithArgs("blue-note", 2).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(exactly("SELECT id, album_id, action, actor, changed_at, before_json, after_json FROM album_history WHERE album_id = ? ORDER BY id")).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "album_id", "action", "actor", "changed_at", "before_json", "after_json"}).
+			AddRow(1, 2, ActionCreated, "alice", created, nil, []byte(`{"id":2,"title":"Jeru"}`)).
+			AddRow(2, 2, ActionDeleted, "bob", testTime, []byte(`{"id":2,"title":"Jeru"}`), []byte(`{"id":2,"title":"Jeru","deleted_at":"2024-03-01T12:00:00Z"}`)))
+	changes, err := r.AlbumHistory(context.Background(), 2)
+	if err != nil || len(changes) != 2 {
+		t.Fatalf("AlbumHistory = %+v, %v", changes, err)
+	}
+	if c := changes[0]; c.Action != ActionCreated || c.Before != nil || c.After.Title != "Jeru" {
+		t.Errorf("first change = %+v", c)
+	}
+	if c := changes[1]; c.Actor != "bob" || c.After.DeletedAt == nil || !c.After.DeletedAt.Equal(testTime) {
+		t.Errorf("second change = %+v", c)
+	}
+
+	// Another tenant's album has no history to show, and its history
+	// rows are not even read.
+	r, mock = newMock(t, "verve")
+	mock.ExpectQuery(exists).WithArgs("verve", 2).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	if _, err := r.AlbumHistory(context.Background(), 2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("AlbumHistory of another tenant's album = %v, want ErrNotFound", err)
+	}
+}
+
+func TestPriceFloat64(t *testing.T) {
+	for _, price := range []float64{0, 17.99, 56.99, 999.99} {
+		if got := (Album{Price: PriceFromFloat64(price)}).PriceFloat64(); got != price {
+			t.Errorf("price %v comes back as %v", price, got)
+		}
+	}
+}
@@ -2,6 +2,9 @@ module github.com/Niku19/golearn/Database
 
 go 1.24.5
 
-require github.com/go-sql-driver/mysql v1.9.3
+require (
+	github.com/DATA-DOG/go-sqlmock v1.5.2
+	github.com/go-sql-driver/mysql v1.9.3
+)
 
 require filippo.io/edwards25519 v1.1.0 // indirect
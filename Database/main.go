@@ -1,121 +1,195 @@
+// Command Database sets up the recordings database for development and
+// tests.
+//
+// Usage:
+//
+//	go run . migrate                    create or upgrade the tables
+//	go run . seed [-file F] [-tenant T] add the fixture albums to an empty catalog
+//	go run . reset -yes [-file F]       drop everything, migrate and seed
+//	go run . dump [-tenant T]           print a catalog as a fixture file
+//
+// The fixture is fixtures/albums.json unless -file names another, and
+// holds the same three albums the Gin service starts with. A dump is a
+// fixture too, so a database can be copied by dumping one and seeding
+// another. The connection is configured as by albumdb.ConfigFromEnv.
 package main
 
 import (
-	"database/sql"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"strconv"
 
-	"github.com/go-sql-driver/mysql"
+	"github.com/Niku19/golearn/Database/albumdb"
 )
 
-// In production, you’d avoid the global variable, such as by passing the variable to functions that need it or by wrapping it in a struct.
-var db *sql.DB
+//go:embed fixtures/albums.json
+var defaultFixture []byte
 
-type Album struct {
-	ID     int64
-	Title  string
-	Artist string
-	Price  float32
+// seedActor is recorded in album_history as the author of seeded albums.
+const seedActor = "seed"
+
+// fixtureAlbum is an album in a fixture file, in the format the Gin
+// service serves and loads its test fixtures in. The database assigns
+// IDs itself, so the ID is only informational.
+type fixtureAlbum struct {
+	ID     string  `json:"id,omitempty"`
+	Title  string  `json:"title"`
+	Artist string  `json:"artist"`
+	Price  float32 `json:"price"`
 }
 
 func main() {
-	// Capture connection properties.
-	cfg := mysql.NewConfig()
-	cfg.User = os.Getenv("DBUSER")
-	cfg.Passwd = os.Getenv("DBPASS")
-	cfg.Net = "tcp"
-	cfg.Addr = "127.0.0.1:3306"
-	cfg.DBName = "recordings"
-
-	// Get a database handle.
-	var err error
-	db, err = sql.Open("mysql", cfg.FormatDSN())
-	if err != nil {
-		// In production code, you’ll want to handle errors in a more graceful way.
-		log.Fatal(err)
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		log.Fatal("usage: go run . migrate | seed | reset | dump")
 	}
-
-	pingErr := db.Ping()
-	if pingErr != nil {
-		// In production code, you’ll want to handle errors in a more graceful way.
-		log.Fatal(pingErr)
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "migrate", "seed", "reset", "dump":
+	default:
+		log.Fatalf("unknown command %q; usage: go run . migrate | seed | reset | dump", cmd)
 	}
-	fmt.Println("Connected!")
 
-	albums, err := albumsByArtist("John Coltrane")
-	if err != nil {
-		log.Fatal(err)
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	file := fs.String("file", "", "fixture `file` to seed from (default fixtures/albums.json)")
+	tenant := fs.String("tenant", albumdb.DefaultTenant, "tenant whose catalog to seed or dump")
+	yes := fs.Bool("yes", false, "confirm that reset may drop every table")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		log.Fatalf("%s takes no arguments", cmd)
+	}
+	if cmd == "reset" && !*yes {
+		log.Fatal("reset drops every album; run it with -yes to go ahead")
+	}
+	// Everything that can be checked without the database is checked
+	// before connecting, so a mistake is not reported as a connection
+	// error, nor found after reset has dropped the tables.
+	var fixture []fixtureAlbum
+	if cmd == "seed" || cmd == "reset" {
+		var err error
+		if fixture, err = readFixture(*file); err != nil {
+			log.Fatal(err)
+		}
 	}
-	fmt.Printf("Albums found: %v\n", albums)
 
-	// Hard-code ID 2 here to test the query.
-	alb, err := albumByID(2)
+	// Capture connection properties and get a database handle.
+	db, err := albumdb.Open(albumdb.ConfigFromEnv())
 	if err != nil {
 		log.Fatal(err)
 	}
-	fmt.Printf("Album found: %v\n", alb)
+	defer db.Close()
+	ctx := context.Background()
+	repo := albumdb.New(db).ForTenant(*tenant)
 
-	albID, err := addAlbum(Album{
-		Title:  "The Modern Sound of Betty Carter",
-		Artist: "Betty Carter",
-		Price:  49.99,
-	})
+	switch cmd {
+	case "migrate":
+		err = migrate(ctx, db, os.Stdout)
+	case "seed":
+		err = seed(ctx, repo, fixture, os.Stdout)
+	case "reset":
+		if err = reset(ctx, db, os.Stdout); err == nil {
+			err = seed(ctx, repo, fixture, os.Stdout)
+		}
+	case "dump":
+		err = dump(ctx, repo, os.Stdout)
+	}
 	if err != nil {
+		db.Close()
 		log.Fatal(err)
 	}
-	fmt.Printf("ID of added album: %v\n", albID)
 }
 
-// albumsByArtist queries for albums that have the specified artist name.
-func albumsByArtist(name string) ([]Album, error) {
-	// An albums slice to hold data from returned rows.
-	var albums []Album
-
-	rows, err := db.Query("SELECT * FROM album WHERE artist = ?", name)
-	if err != nil {
-		return nil, fmt.Errorf("albumsByArtist %q: %v", name, err)
-	}
-	defer rows.Close()
-	// Loop through rows, using Scan to assign column data to struct fields.
-	for rows.Next() {
-		var alb Album
-		if err := rows.Scan(&alb.ID, &alb.Title, &alb.Artist, &alb.Price); err != nil {
-			return nil, fmt.Errorf("albumsByArtist %q: %v", name, err)
+// readFixture reads the fixture file, or the default fixture if file
+// is empty.
+func readFixture(file string) ([]fixtureAlbum, error) {
+	data := defaultFixture
+	if file != "" {
+		var err error
+		if data, err = os.ReadFile(file); err != nil {
+			return nil, err
 		}
-		albums = append(albums, alb)
 	}
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("albumsByArtist %q: %v", name, err)
+	fixture, err := parseFixture(data)
+	if err != nil {
+		return nil, fmt.Errorf("reading fixture: %w", err)
 	}
-	return albums, nil
+	return fixture, nil
 }
 
-// albumByID queries for the album with the specified ID.
-func albumByID(id int64) (Album, error) {
-	// An album to hold data from the returned row.
-	var alb Album
+// maxPrice is one cent more than the album table's DECIMAL(5,2) price
+// column holds.
+const maxPrice = 1000
 
-	row := db.QueryRow("SELECT * FROM album WHERE id = ?", id)
-	if err := row.Scan(&alb.ID, &alb.Title, &alb.Artist, &alb.Price); err != nil {
-		if err == sql.ErrNoRows {
-			return alb, fmt.Errorf("albumsById %d: no such album", id)
+// parseFixture parses a fixture file, checking that each album would
+// fit in the album table.
+func parseFixture(data []byte) ([]fixtureAlbum, error) {
+	var fixture []fixtureAlbum
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return nil, err
+	}
+	for i, f := range fixture {
+		switch {
+		case f.Title == "" || len(f.Title) > 128:
+			return nil, fmt.Errorf("album %d: title must be 1 to 128 bytes", i+1)
+		case f.Artist == "" || len(f.Artist) > 255:
+			return nil, fmt.Errorf("album %d: artist must be 1 to 255 bytes", i+1)
+		case f.Price < 0 || f.Price >= maxPrice:
+			return nil, fmt.Errorf("album %d: price %v is not from 0 to 999.99", i+1, f.Price)
 		}
-		return alb, fmt.Errorf("albumsById %d: %v", id, err)
 	}
-	return alb, nil
+	return fixture, nil
 }
 
-// addAlbum adds the specified album to the database,
-// returning the album ID of the new entry
-func addAlbum(alb Album) (int64, error) {
-	result, err := db.Exec("INSERT INTO album (title, artist, price) VALUES (?, ?, ?)", alb.Title, alb.Artist, alb.Price)
+// seed adds the albums of fixture to the repository's catalog, all of
+// them or, if one cannot be added, none. It refuses a catalog that
+// already has albums, since seeding it again would add them twice.
+func seed(ctx context.Context, repo *albumdb.Repository, fixture []fixtureAlbum, out io.Writer) error {
+	n, err := repo.CountAlbums(ctx)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return fmt.Errorf("tenant %s already has %d albums; reset the database to seed it again", repo.Tenant(), n)
+	}
+	albums := make([]albumdb.Album, 0, len(fixture))
+	for _, f := range fixture {
+		albums = append(albums, albumdb.Album{Title: f.Title, Artist: f.Artist, Price: f.Price})
+	}
+	added, err := repo.AddAlbums(ctx, seedActor, albums)
 	if err != nil {
-		return 0, fmt.Errorf("addAlbum: %v", err)
+		return err
+	}
+	for _, alb := range added {
+		fmt.Fprintf(out, "added album %d: %s by %s\n", alb.ID, alb.Title, alb.Artist)
+	}
+	return nil
+}
+
+// dump writes the repository's catalog to out as a fixture file.
+func dump(ctx context.Context, repo *albumdb.Repository, out io.Writer) error {
+	albums, err := repo.Albums(ctx)
+	if err != nil {
+		return err
+	}
+	fixture := make([]fixtureAlbum, 0, len(albums))
+	for _, alb := range albums {
+		fixture = append(fixture, fixtureAlbum{
+			ID:     strconv.FormatInt(alb.ID, 10),
+			Title:  alb.Title,
+			Artist: alb.Artist,
+			Price:  alb.Price,
+		})
 	}
-	id, err := result.LastInsertId()
+	data, err := json.MarshalIndent(fixture, "", "  ")
 	if err != nil {
-		return 0, fmt.Errorf("addAlbum: %v", err)
+		return err
 	}
-	return id, nil
+	_, err = fmt.Fprintf(out, "%s\n", data)
+	return err
 }
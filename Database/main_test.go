@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Niku19/golearn/Database/albumdb"
+)
+
+// newMock returns a repository of tenant backed by a mock database,
+// which checks when the test ends that every expected query was run.
+func newMock(t *testing.T, tenant string) (*albumdb.Repository, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+	return albumdb.New(db).ForTenant(tenant), mock
+}
+
+func TestParseFixture(t *testing.T) {
+	fixture, err := parseFixture(defaultFixture)
+	if err != nil || len(fixture) != 3 || fixture[0] != (fixtureAlbum{ID: "1", Title: "Blue Train", Artist: "John Coltrane", Price: 56.99}) {
+		t.Errorf("default fixture = %+v, %v", fixture, err)
+	}
+
+	tests := []struct {
+		data, err string
+	}{
+		{`[]`, ""},
+		{`[{"title":"Jeru","artist":"Gerry Mulligan","price":999.99}]`, ""},
+		{`[{"title":"Jeru","artist":"Gerry Mulligan"}]`, ""},
+		{`{"title":"Jeru"}`, "cannot unmarshal"},
+		{`[{"title":"Jeru","artist":"Gerry Mulligan","price":"cheap"}]`, "cannot unmarshal"},
+		{`[{"artist":"Gerry Mulligan","price":17.99}]`, "album 1: title"},
+		{`[{"title":"Jeru","price":17.99}]`, "album 1: artist"},
+		{`[{"title":"Jeru","artist":"Gerry Mulligan"},{"title":"Jeru","artist":"Gerry Mulligan","price":1000}]`, "album 2: price"},
+		{`[{"title":"Jeru","artist":"Gerry Mulligan","price":-1}]`, "album 1: price"},
+		{`[{"title":"` + strings.Repeat("x", 129) + `","artist":"Gerry Mulligan"}]`, "album 1: title"},
+	}
+	for _, tt := range tests {
+		_, err := parseFixture([]byte(tt.data))
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("parseFixture(%.40s) = %v, want error containing %q", tt.data, err, tt.err)
+		}
+	}
+}
+
+func TestSeed(t *testing.T) {
+	repo, mock := newMock(t, "blue-note")
+	fixture, _ := parseFixture(defaultFixture)
+
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM album WHERE tenant_id = \? AND deleted_at IS NULL`).
+		WithArgs("blue-note").WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(0))
+	mock.ExpectBegin()
+	for i, f := range fixture {
+		id := int64(10 + i)
+		mock.ExpectExec(`INSERT INTO album \(tenant_id, `).
+			WithArgs("blue-note", f.Title, f.Artist, f.Price, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(id, 1))
+		mock.ExpectExec(`INSERT INTO album_history`).
+			WithArgs(id, albumdb.ActionCreated, seedActor, sqlmock.AnyArg(), nil, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+	mock.ExpectCommit()
+
+	var out bytes.Buffer
+	if err := seed(context.Background(), repo, fixture, &out); err != nil {
+		t.Fatal(err)
+	}
+	want := "added album 10: Blue Train by John Coltrane\n" +
+		"added album 11: Jeru by Gerry Mulligan\n" +
+		"added album 12: Sarah Vaughan and Clifford Brown by Sarah Vaughan\n"
+	if out.String() != want {
+		t.Errorf("seed printed %q, want %q", out.String(), want)
+	}
+}
+
+func TestSeedAllOrNothing(t *testing.T) {
+	repo, mock := newMock(t, albumdb.DefaultTenant)
+	fixture, _ := parseFixture(defaultFixture)
+
+	mock.ExpectQuery(`SELECT COUNT\(\*\)`).WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(0))
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO album \(tenant_id, `).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`INSERT INTO album_history`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`INSERT INTO album \(tenant_id, `).WillReturnError(errors.New("connection lost"))
+	mock.ExpectRollback()
+
+	var out bytes.Buffer
+	if err := seed(context.Background(), repo, fixture, &out); err == nil || !strings.Contains(err.Error(), "connection lost") {
+		t.Errorf("seed = %v, want the failed insert", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("seed reported %q though nothing was added", out.String())
+	}
+}
+
+func TestSeedRefusesSeededCatalog(t *testing.T) {
+	repo, mock := newMock(t, albumdb.DefaultTenant)
+	mock.ExpectQuery(`SELECT COUNT\(\*\)`).WithArgs(albumdb.DefaultTenant).
+		WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(3))
+
+	fixture, _ := parseFixture(defaultFixture)
+	if err := seed(context.Background(), repo, fixture, &bytes.Buffer{}); err == nil || !strings.Contains(err.Error(), "already has 3 albums") {
+		t.Errorf("seed = %v, want a refusal", err)
+	}
+}
+
+func TestDump(t *testing.T) {
+	repo, mock := newMock(t, "blue-note")
+	at := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(`SELECT id, title, artist, price, created_at, updated_at, deleted_at FROM album WHERE tenant_id = \? AND deleted_at IS NULL ORDER BY id`).
+		WithArgs("blue-note").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist", "price", "created_at", "updated_at", "deleted_at"}).
+			AddRow(4, "Giant Steps", "John Coltrane", 63.99, at, at, nil).
+			AddRow(7, "Speak No Evil", "Wayne Shorter", 24.99, at, at, nil))
+
+	var out bytes.Buffer
+	if err := dump(context.Background(), repo, &out); err != nil {
+		t.Fatal(err)
+	}
+	want := `[
+  {
+    "id": "4",
+    "title": "Giant Steps",
+    "artist": "John Coltrane",
+    "price": 63.99
+  },
+  {
+    "id": "7",
+    "title": "Speak No Evil",
+    "artist": "Wayne Shorter",
+    "price": 24.99
+  }
+]
+`
+	if out.String() != want {
+		t.Errorf("dump printed\n%s\nwant\n%s", out.String(), want)
+	}
+	// A dump is a fixture.
+	if fixture, err := parseFixture(out.Bytes()); err != nil || len(fixture) != 2 {
+		t.Errorf("parseFixture(dump) = %+v, %v", fixture, err)
+	}
+}
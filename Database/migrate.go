@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+)
+
+// migrations are applied in the order of their file names, each once;
+// schema_migrations records those applied. A change to the schema is a
+// new file, never an edit to one that may already have run.
+//
+//go:embed sqlscripts/migrations/*.sql
+var migrations embed.FS
+
+// migrateLock is the MySQL named lock held while migrating, so two
+// processes starting together do not both apply a migration.
+const migrateLock = "recordings.migrate"
+
+// migrate applies the migrations not yet applied to db, reporting each
+// to out.
+func migrate(ctx context.Context, db *sql.DB, out io.Writer) error {
+	// Named locks belong to a connection, so everything runs on one.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var locked sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, 30)", migrateLock).Scan(&locked); err != nil {
+		return err
+	}
+	if locked.Int64 != 1 {
+		return fmt.Errorf("another migration is running")
+	}
+	defer conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", migrateLock)
+
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+  version    VARCHAR(255) NOT NULL,
+  applied_at DATETIME(6) NOT NULL,
+  PRIMARY KEY (version)
+)`); err != nil {
+		return err
+	}
+	applied := make(map[string]bool)
+	rows, err := conn.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[version] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	names, err := fs.Glob(migrations, "sqlscripts/migrations/*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		version := strings.TrimSuffix(name[strings.LastIndex(name, "/")+1:], ".sql")
+		if applied[version] {
+			continue
+		}
+		if version == "0001_create_album" {
+			if err := upgradeLegacyAlbum(ctx, conn, out); err != nil {
+				return fmt.Errorf("migration %s: %w", version, err)
+			}
+		}
+		script, err := migrations.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		// MySQL commits DDL as it goes, so a migration failing part way
+		// is left part applied; its statements are written to be rerun.
+		for _, stmt := range splitStatements(string(script)) {
+			if _, err := conn.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("migration %s: %w", version, err)
+			}
+		}
+		if _, err := conn.ExecContext(ctx, "INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)",
+			version, time.Now().UTC()); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "applied migration %s\n", version)
+	}
+	return nil
+}
+
+// legacyAlbumColumns are the columns the album table has gained since
+// sqlscripts/create-tables.sql first created it, defined as in
+// 0001_create_album.
+var legacyAlbumColumns = []struct{ name, definition string }{
+	{"tenant_id", "VARCHAR(64) NOT NULL DEFAULT 'default' AFTER id"},
+	{"created_at", "DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)"},
+	{"updated_at", "DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)"},
+	{"deleted_at", "DATETIME(6) NULL"},
+}
+
+// upgradeLegacyAlbum adds the columns it lacks to an album table made by
+// an older sqlscripts/create-tables.sql, which the CREATE TABLE IF NOT
+// EXISTS of 0001_create_album would leave as it is. Its albums go to
+// the default tenant.
+func upgradeLegacyAlbum(ctx context.Context, conn *sql.Conn, out io.Writer) error {
+	rows, err := conn.QueryContext(ctx, "SELECT column_name FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = 'album'")
+	if err != nil {
+		return err
+	}
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			rows.Close()
+			return err
+		}
+		columns[strings.ToLower(column)] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(columns) == 0 {
+		// There is no album table yet.
+		return nil
+	}
+
+	var added, clauses []string
+	for _, c := range legacyAlbumColumns {
+		if !columns[c.name] {
+			added = append(added, c.name)
+			clauses = append(clauses, "ADD COLUMN "+c.name+" "+c.definition)
+		}
+	}
+	if len(clauses) == 0 {
+		return nil
+	}
+	if !columns["tenant_id"] {
+		clauses = append(clauses, "ADD KEY (tenant_id, artist)")
+	}
+	if _, err := conn.ExecContext(ctx, "ALTER TABLE album "+strings.Join(clauses, ", ")); err != nil {
+		return fmt.Errorf("upgrading the album table: %w", err)
+	}
+	fmt.Fprintf(out, "added %s to the existing album table\n", strings.Join(added, ", "))
+	return nil
+}
+
+// reset drops every table, then migrates the empty database.
+func reset(ctx context.Context, db *sql.DB, out io.Writer) error {
+	// album_history refers to album, so it goes first.
+	for _, table := range []string{"album_history", "album", "schema_migrations"} {
+		if _, err := db.ExecContext(ctx, "DROP TABLE IF EXISTS "+table); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintln(out, "dropped all tables")
+	return migrate(ctx, db, out)
+}
+
+// splitStatements splits a script into the statements the driver runs
+// one at a time, dropping comments: -- and # to the end of the line, and
+// /* */. Statements end with a semicolon outside quotes and comments;
+// quotes are ', " and `, and the first two may escape a quote with a
+// backslash, as MySQL reads them.
+func splitStatements(script string) []string {
+	var stmts []string
+	var b strings.Builder
+	end := func() {
+		if stmt := strings.TrimSpace(b.String()); stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+		b.Reset()
+	}
+	for i := 0; i < len(script); i++ {
+		switch c := script[i]; {
+		case c == '\'' || c == '"' || c == '`':
+			j := i + 1
+			for j < len(script) && script[j] != c {
+				if script[j] == '\\' && c != '`' {
+					j++
+				}
+				j++
+			}
+			j = min(j+1, len(script))
+			b.WriteString(script[i:j])
+			i = j - 1
+		case c == '#' || isLineComment(script[i:]):
+			// The newline ending the comment is kept, as it may be all
+			// that separates the words either side.
+			if n := strings.IndexByte(script[i:], '\n'); n >= 0 {
+				i += n - 1
+			} else {
+				i = len(script)
+			}
+		case strings.HasPrefix(script[i:], "/*"):
+			b.WriteByte(' ')
+			if n := strings.Index(script[i+2:], "*/"); n >= 0 {
+				i += n + 3
+			} else {
+				i = len(script)
+			}
+		case c == ';':
+			end()
+		default:
+			b.WriteByte(c)
+		}
+	}
+	end()
+	return stmts
+}
+
+// isLineComment reports whether s starts with a -- comment, which MySQL
+// wants followed by a space or the end of the line.
+func isLineComment(s string) bool {
+	rest, ok := strings.CutPrefix(s, "--")
+	return ok && (rest == "" || rest[0] == ' ' || rest[0] == '\t' || rest[0] == '\n' || rest[0] == '\r')
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{"one per line", "SELECT 1;\nSELECT 2;\n", []string{"SELECT 1", "SELECT 2"}},
+		{"several on a line", "SELECT 1; SELECT 2", []string{"SELECT 1", "SELECT 2"}},
+		{"spanning lines", "CREATE TABLE t (\n  a INT\n);", []string{"CREATE TABLE t (\n  a INT\n)"}},
+		{"empty statements", ";\n ; SELECT 1;;", []string{"SELECT 1"}},
+		{"line comments", "-- about t\nSELECT 1; # and more\n# SELECT 2;\n", []string{"SELECT 1"}},
+		{"comment inside", "SELECT a, -- first;\n  b FROM t;", []string{"SELECT a, \n  b FROM t"}},
+		{"block comment", "SELECT /* ; */ 1;/* SELECT 2; */", []string{"SELECT   1"}},
+		{"-- needs a space", "SELECT 1--1;", []string{"SELECT 1--1"}},
+		{"; in single quotes", "INSERT INTO t VALUES ('a;b');", []string{"INSERT INTO t VALUES ('a;b')"}},
+		{"; in double quotes", `INSERT INTO t VALUES ("a;b");`, []string{`INSERT INTO t VALUES ("a;b")`}},
+		{"; in backquotes", "SELECT `a;b` FROM t;", []string{"SELECT `a;b` FROM t"}},
+		{"comment markers in quotes", "SELECT '-- x', '# y', '/* z */';", []string{"SELECT '-- x', '# y', '/* z */'"}},
+		{"escaped quote", `SELECT 'it\'s;', 'a''b;c';`, []string{`SELECT 'it\'s;', 'a''b;c'`}},
+		{"quote spanning lines", "INSERT INTO t VALUES ('a;\nb');", []string{"INSERT INTO t VALUES ('a;\nb')"}},
+		{"no final semicolon", "SELECT 1;\nSELECT 2\n", []string{"SELECT 1", "SELECT 2"}},
+		{"unterminated quote", "SELECT 'a;", []string{"SELECT 'a;"}},
+	}
+	for _, tt := range tests {
+		if got := splitStatements(tt.script); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: splitStatements(%q) = %q, want %q", tt.name, tt.script, got, tt.want)
+		}
+	}
+}
+
+func TestMigrationsSplit(t *testing.T) {
+	script, err := migrations.ReadFile("sqlscripts/migrations/0001_create_album.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmts := splitStatements(string(script))
+	if len(stmts) != 2 {
+		t.Fatalf("0001_create_album has %d statements, want 2: %q", len(stmts), stmts)
+	}
+	for i, table := range []string{"album", "album_history"} {
+		if !strings.HasPrefix(stmts[i], "CREATE TABLE IF NOT EXISTS "+table+" (") || strings.Contains(stmts[i], "--") {
+			t.Errorf("statement %d = %q, want the CREATE TABLE of %s without comments", i, stmts[i], table)
+		}
+	}
+}
+
+func TestMigrateUpgradesLegacyAlbum(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT GET_LOCK`).WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(1))
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS schema_migrations`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT version FROM schema_migrations`).WillReturnRows(sqlmock.NewRows([]string{"version"}))
+	// The album table as the first create-tables.sql made it.
+	mock.ExpectQuery(`FROM information_schema.columns`).
+		WillReturnRows(sqlmock.NewRows([]string{"column_name"}).AddRow("id").AddRow("title").AddRow("artist").AddRow("price"))
+	mock.ExpectExec(regexp.QuoteMeta("ALTER TABLE album ADD COLUMN tenant_id VARCHAR(64) NOT NULL DEFAULT 'default' AFTER id, " +
+		"ADD COLUMN created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6), " +
+		"ADD COLUMN updated_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6), " +
+		"ADD COLUMN deleted_at DATETIME(6) NULL, ADD KEY (tenant_id, artist)")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS album \(`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS album_history \(`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO schema_migrations`).WithArgs("0001_create_album", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`SELECT RELEASE_LOCK`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	var out bytes.Buffer
+	if err := migrate(context.Background(), db, &out); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	want := "added tenant_id, created_at, updated_at, deleted_at to the existing album table\napplied migration 0001_create_album\n"
+	if out.String() != want {
+		t.Errorf("migrate printed %q, want %q", out.String(), want)
+	}
+}
+
+func TestUpgradeLegacyAlbumLeavesOthers(t *testing.T) {
+	for name, columns := range map[string][]string{
+		"no table": nil,
+		"current":  {"id", "tenant_id", "title", "artist", "price", "created_at", "updated_at", "deleted_at"},
+	} {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows := sqlmock.NewRows([]string{"column_name"})
+		for _, c := range columns {
+			rows.AddRow(strings.ToUpper(c))
+		}
+		mock.ExpectQuery(`FROM information_schema.columns`).WillReturnRows(rows)
+
+		conn, err := db.Conn(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := upgradeLegacyAlbum(context.Background(), conn, io.Discard); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		// An ALTER TABLE would have failed as unexpected.
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		conn.Close()
+		db.Close()
+	}
+}
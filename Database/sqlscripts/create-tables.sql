@@ -1,11 +1,38 @@
 -- source /path/to/create-tables.sql  , Make sure to use / instead of \ like <above path>/golearn/Database/sqlscripts/create-tables.sql
+-- Without the mysql client, go run . reset -yes recreates the tables from
+-- sqlscripts/migrations instead; keep the tables here in step with them.
+DROP TABLE IF EXISTS album_history;
 DROP TABLE IF EXISTS album;
 CREATE TABLE album (
   id         INT AUTO_INCREMENT NOT NULL,
+  -- The record label whose catalog the album is in. Every query filters
+  -- on it; see albumdb.Repository.
+  tenant_id  VARCHAR(64) NOT NULL DEFAULT 'default',
   title      VARCHAR(128) NOT NULL,
   artist     VARCHAR(255) NOT NULL,
   price      DECIMAL(5,2) NOT NULL,
-  PRIMARY KEY (`id`)
+  created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  updated_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  -- Deleted albums stay in the table with deleted_at set, so they can
+  -- be restored.
+  deleted_at DATETIME(6) NULL,
+  PRIMARY KEY (`id`),
+  KEY (tenant_id, artist)
+);
+
+-- album_history records every change to an album: who made it, and the
+-- album as JSON before and after.
+CREATE TABLE album_history (
+  id          INT AUTO_INCREMENT NOT NULL,
+  album_id    INT NOT NULL,
+  action      VARCHAR(16) NOT NULL,
+  actor       VARCHAR(255) NOT NULL,
+  changed_at  DATETIME(6) NOT NULL,
+  before_json JSON NULL,
+  after_json  JSON NULL,
+  PRIMARY KEY (`id`),
+  KEY (album_id),
+  FOREIGN KEY (album_id) REFERENCES album (id)
 );
 
 INSERT INTO album
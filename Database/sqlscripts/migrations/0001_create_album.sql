@@ -0,0 +1,36 @@
+-- The album table and its history, as sqlscripts/create-tables.sql
+-- creates them. IF NOT EXISTS lets a database set up with that script
+-- adopt the migrations without losing its albums; before this runs,
+-- migrate adds the columns an album table from an older version of the
+-- script lacks.
+CREATE TABLE IF NOT EXISTS album (
+  id         INT AUTO_INCREMENT NOT NULL,
+  -- The record label whose catalog the album is in. Every query filters
+  -- on it; see albumdb.Repository.
+  tenant_id  VARCHAR(64) NOT NULL DEFAULT 'default',
+  title      VARCHAR(128) NOT NULL,
+  artist     VARCHAR(255) NOT NULL,
+  price      DECIMAL(5,2) NOT NULL,
+  created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  updated_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  -- Deleted albums stay in the table with deleted_at set, so they can
+  -- be restored.
+  deleted_at DATETIME(6) NULL,
+  PRIMARY KEY (`id`),
+  KEY (tenant_id, artist)
+);
+
+-- album_history records every change to an album: who made it, and the
+-- album as JSON before and after.
+CREATE TABLE IF NOT EXISTS album_history (
+  id          INT AUTO_INCREMENT NOT NULL,
+  album_id    INT NOT NULL,
+  action      VARCHAR(16) NOT NULL,
+  actor       VARCHAR(255) NOT NULL,
+  changed_at  DATETIME(6) NOT NULL,
+  before_json JSON NULL,
+  after_json  JSON NULL,
+  PRIMARY KEY (`id`),
+  KEY (album_id),
+  FOREIGN KEY (album_id) REFERENCES album (id)
+);
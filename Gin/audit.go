@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errAlbumNotDeleted is returned by an albumStore asked to restore an
+// album that is not deleted.
+var errAlbumNotDeleted = errors.New("album is not deleted")
+
+// Actions recorded in an album's history.
+const (
+	changeCreated  = "created"
+	changeUpdated  = "updated"
+	changeDeleted  = "deleted"
+	changeRestored = "restored"
+)
+
+// albumChange is one entry in an album's history: who did what to it
+// and when, with the album before and after. Before is absent for a
+// created album.
+type albumChange struct {
+	ID      int64     `json:"id" xml:"id"`
+	AlbumID string    `json:"album_id" xml:"album_id"`
+	Action  string    `json:"action" xml:"action"`
+	Actor   string    `json:"actor" xml:"actor"`
+	Time    time.Time `json:"time" xml:"time"`
+	Before  *album    `json:"before,omitempty" xml:"before,omitempty"`
+	After   *album    `json:"after,omitempty" xml:"after,omitempty"`
+}
+
+// changeList wraps an album's history for XML, as albumList does a
+// list of albums.
+type changeList struct {
+	XMLName xml.Name      `xml:"history"`
+	Changes []albumChange `xml:"change"`
+}
+
+// headerActor names who a request acts for. Nothing checks it yet; it
+// is what the history records as the actor.
+const headerActor = "X-Actor"
+
+// anonymous is the actor of changes made without an X-Actor header.
+const anonymous = "anonymous"
+
+type actorKey struct{}
+
+// withActor returns a context whose changes are recorded as made by
+// actor.
+func withActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// actorFrom returns the actor set by withActor, or anonymous.
+func actorFrom(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return anonymous
+}
+
+// identifyActor is middleware putting the X-Actor of a request in its
+// context, where the stores find it.
+func identifyActor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if actor := c.GetHeader(headerActor); actor != "" {
+			c.Request = c.Request.WithContext(withActor(c.Request.Context(), actor))
+		}
+		c.Next()
+	}
+}
+
+// getAlbumHistory responds with the changes made to an album, including
+// a deleted one.
+func (s *server) getAlbumHistory(c *gin.Context) {
+	changes, err := s.store.History(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, errAlbumNotFound) {
+		s.respond(c, http.StatusNotFound, errorResponse{Message: "album not found"})
+		return
+	}
+	if err != nil {
+		s.respond(c, http.StatusInternalServerError, errorResponse{Message: err.Error()})
+		return
+	}
+	if changes == nil {
+		changes = []albumChange{}
+	}
+	s.respond(c, http.StatusOK, changes)
+}
+
+// restoreAlbum brings back a deleted album.
+func (s *server) restoreAlbum(c *gin.Context) {
+	a, err := s.store.Restore(c.Request.Context(), c.Param("id"))
+	switch {
+	case errors.Is(err, errAlbumNotFound):
+		s.respond(c, http.StatusNotFound, errorResponse{Message: "album not found"})
+	case errors.Is(err, errAlbumNotDeleted):
+		s.respond(c, http.StatusConflict, errorResponse{Message: "album is not deleted"})
+	case errors.Is(err, errQuotaExceeded):
+		s.respond(c, http.StatusForbidden, errorResponse{Message: err.Error()})
+	case err != nil:
+		s.respond(c, http.StatusInternalServerError, errorResponse{Message: err.Error()})
+	default:
+		s.respond(c, http.StatusOK, a)
+	}
+}
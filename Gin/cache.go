@@ -0,0 +1,287 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Default bounds of the read-through cache in front of the store.
+const (
+	defaultCacheTTL     = 30 * time.Second
+	defaultCacheEntries = 1024
+)
+
+// cachingStore is a read-through cache in front of an albumStore. It
+// keeps the results of Get and List for at most ttl, and at most
+// maxEntries of them, dropping the least recently used first. Writes
+// through the cache invalidate what they change; writes that bypass it,
+// such as another process sharing the database, show up once the
+// entries expire.
+type cachingStore struct {
+	next    albumStore
+	metrics *metrics
+
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // values are *cacheEntry
+	lru     *list.List               // most recently used first
+	loads   map[string]*load
+	// gen counts invalidations. A load started before one finished
+	// may have read the old value, so its result is not cached.
+	gen uint64
+}
+
+type cacheEntry struct {
+	key     string
+	value   any // album or []album
+	expires time.Time
+}
+
+// load is a store read in progress. Concurrent misses on the same key
+// wait for it instead of each going to the store.
+type load struct {
+	done  chan struct{}
+	value any
+	err   error
+}
+
+// Cache keys. Each tenant's catalog is cached under keys of its own.
+func cacheKeyList(ctx context.Context) string { return tenantFrom(ctx) + "/list" }
+
+func cacheKeyGet(ctx context.Context, id string) string { return tenantFrom(ctx) + "/get:" + id }
+
+func newCachingStore(next albumStore, m *metrics) *cachingStore {
+	c := &cachingStore{
+		next:       next,
+		metrics:    m,
+		ttl:        defaultCacheTTL,
+		maxEntries: defaultCacheEntries,
+		now:        time.Now,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		loads:      make(map[string]*load),
+	}
+	m.registry.register(&funcCollector{
+		name: "album_cache_entries",
+		help: "Number of entries in the album read cache.",
+		kind: "gauge",
+		fn:   func() float64 { return float64(c.len()) },
+	})
+	return c
+}
+
+func (c *cachingStore) List(ctx context.Context) ([]album, error) {
+	v, err := c.get(ctx, "list", cacheKeyList(ctx), func() (any, error) { return c.next.List(ctx) })
+	if err != nil {
+		return nil, err
+	}
+	// Callers may modify the slice they are given; the cached one must
+	// not change with it.
+	return append([]album(nil), v.([]album)...), nil
+}
+
+// ListByArtists is not cached: it serves GraphQL batches whose artist
+// sets rarely repeat.
+func (c *cachingStore) ListByArtists(ctx context.Context, artists []string) ([]album, error) {
+	return c.next.ListByArtists(ctx, artists)
+}
+
+// ListByPrice is not cached either: price filters are as varied.
+func (c *cachingStore) ListByPrice(ctx context.Context, min, max float64) ([]album, error) {
+	return c.next.ListByPrice(ctx, min, max)
+}
+
+func (c *cachingStore) Get(ctx context.Context, id string) (album, error) {
+	v, err := c.get(ctx, "get", cacheKeyGet(ctx, id), func() (any, error) { return c.next.Get(ctx, id) })
+	if err != nil {
+		return album{}, err
+	}
+	return v.(album), nil
+}
+
+func (c *cachingStore) Add(ctx context.Context, in album) (album, error) {
+	a, err := c.next.Add(ctx, in)
+	c.invalidate(cacheKeyList(ctx), cacheKeyGet(ctx, in.ID))
+	return a, err
+}
+
+func (c *cachingStore) Update(ctx context.Context, in album) (album, error) {
+	a, err := c.next.Update(ctx, in)
+	c.invalidate(cacheKeyList(ctx), cacheKeyGet(ctx, in.ID))
+	return a, err
+}
+
+func (c *cachingStore) Delete(ctx context.Context, id string) (album, error) {
+	a, err := c.next.Delete(ctx, id)
+	c.invalidate(cacheKeyList(ctx), cacheKeyGet(ctx, id))
+	return a, err
+}
+
+func (c *cachingStore) Restore(ctx context.Context, id string) (album, error) {
+	a, err := c.next.Restore(ctx, id)
+	c.invalidate(cacheKeyList(ctx), cacheKeyGet(ctx, id))
+	return a, err
+}
+
+// History is read rarely enough not to be worth caching.
+func (c *cachingStore) History(ctx context.Context, id string) ([]albumChange, error) {
+	return c.next.History(ctx, id)
+}
+
+// get returns the cached value for key, or calls fetch to read it from
+// the store. Only successful reads are cached, so a missing album or a
+// store outage is retried on the next request.
+func (c *cachingStore) get(ctx context.Context, op, key string, fetch func() (any, error)) (any, error) {
+	for {
+		c.mu.Lock()
+		if e, ok := c.entries[key]; ok {
+			ent := e.Value.(*cacheEntry)
+			if c.now().Before(ent.expires) {
+				c.lru.MoveToFront(e)
+				c.mu.Unlock()
+				c.metrics.cacheRequests.inc(op, "hit")
+				return ent.value, nil
+			}
+			c.removeLocked(e)
+			c.metrics.cacheEvictions.inc("expired")
+		}
+
+		if l, ok := c.loads[key]; ok {
+			c.mu.Unlock()
+			c.metrics.cacheRequests.inc(op, "shared")
+			select {
+			case <-l.done:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			// The load belonged to a request that was cancelled; that
+			// says nothing about this one, so try again.
+			if isContextError(l.err) && ctx.Err() == nil {
+				continue
+			}
+			return l.value, l.err
+		}
+
+		l := &load{done: make(chan struct{})}
+		c.loads[key] = l
+		gen := c.gen
+		c.mu.Unlock()
+		c.metrics.cacheRequests.inc(op, "miss")
+
+		l.value, l.err = fetch()
+
+		c.mu.Lock()
+		delete(c.loads, key)
+		if l.err == nil && gen == c.gen {
+			c.addLocked(key, l.value)
+		}
+		c.mu.Unlock()
+		close(l.done)
+		return l.value, l.err
+	}
+}
+
+func (c *cachingStore) addLocked(key string, value any) {
+	if e, ok := c.entries[key]; ok {
+		c.removeLocked(e)
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, value: value, expires: c.now().Add(c.ttl)})
+	for c.lru.Len() > c.maxEntries {
+		c.removeLocked(c.lru.Back())
+		c.metrics.cacheEvictions.inc("lru")
+	}
+}
+
+func (c *cachingStore) removeLocked(e *list.Element) {
+	c.lru.Remove(e)
+	delete(c.entries, e.Value.(*cacheEntry).key)
+}
+
+// invalidate drops keys from the cache and stops loads already in
+// flight from caching what they read.
+func (c *cachingStore) invalidate(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	for _, key := range keys {
+		if e, ok := c.entries[key]; ok {
+			c.removeLocked(e)
+		}
+	}
+}
+
+func (c *cachingStore) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// cacheable marks a successful album read, of v, as cacheable by the
+// client, and reports whether the client's copy is current, in which
+// case it has answered 304 Not Modified and the handler sends nothing
+// more.
+//
+// The client may keep the response but must revalidate it before each
+// use, with the ETag: a copy kept for a while could outlive a PUT or
+// DELETE by that very client. Every catalog belongs to a tenant, so the
+// response is private: a shared cache could otherwise hand one tenant's
+// albums to another. Responses to requests made with an API key are not
+// stored at all.
+func (s *server) cacheable(c *gin.Context, v any) (notModified bool) {
+	if len(s.apiKeys) > 0 {
+		c.Header("Cache-Control", "no-store")
+	} else {
+		c.Header("Cache-Control", "private, no-cache")
+	}
+	// The representation depends on these headers, so caches must key
+	// on them.
+	c.Writer.Header().Add("Vary", "Accept, X-Tenant, X-API-Key")
+
+	etag := albumsETag(c.Request, v)
+	c.Header("ETag", etag)
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// albumsETag returns a weak ETag for v as the response to r: a hash of
+// v and of the Accept header and query that decide how it is written.
+// It is weak since the bytes also depend on Accept-Encoding.
+func albumsETag(r *http.Request, v any) string {
+	b, _ := json.Marshal(v)
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n", r.Header.Get("Accept"), r.URL.RawQuery)
+	h.Write(b)
+	return fmt.Sprintf(`W/"%x"`, h.Sum(nil)[:16])
+}
+
+// etagMatches reports whether an If-None-Match header names etag, by
+// the weak comparison RFC 9110 has GET use.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// gatedStore counts Get and List calls and, while gate is set, holds
+// them until it is closed.
+type gatedStore struct {
+	albumStore
+	gets, lists atomic.Int32
+	gate        chan struct{}
+}
+
+func (s *gatedStore) Get(ctx context.Context, id string) (album, error) {
+	s.gets.Add(1)
+	if s.gate != nil {
+		<-s.gate
+	}
+	return s.albumStore.Get(ctx, id)
+}
+
+func (s *gatedStore) List(ctx context.Context) ([]album, error) {
+	s.lists.Add(1)
+	return s.albumStore.List(ctx)
+}
+
+// counterValue reads one series of v.
+func counterValue(v *counterVec, labelValues ...string) float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.get(labelValues).value
+}
+
+func newTestCache(t *testing.T) (*cachingStore, *gatedStore, *metrics) {
+	t.Helper()
+	store := &gatedStore{albumStore: newMemoryStore(loadFixture(t, "albums"))}
+	m := newMetrics()
+	return newCachingStore(store, m), store, m
+}
+
+func TestCacheHitsAndInvalidation(t *testing.T) {
+	c, store, m := newTestCache(t)
+	ctx := context.Background()
+
+	for range 3 {
+		if a, err := c.Get(ctx, "1"); err != nil || a.Title != "Blue Train" {
+			t.Fatalf("Get(1) = %+v, %v", a, err)
+		}
+		if _, err := c.List(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if store.gets.Load() != 1 || store.lists.Load() != 1 {
+		t.Errorf("store saw %d gets and %d lists, want 1 each", store.gets.Load(), store.lists.Load())
+	}
+	if hits := counterValue(m.cacheRequests, "get", "hit"); hits != 2 {
+		t.Errorf("get hits = %v, want 2", hits)
+	}
+
+	if _, err := c.Update(ctx, album{ID: "1", Title: "Blue Train (Remastered)"}); err != nil {
+		t.Fatal(err)
+	}
+	if a, _ := c.Get(ctx, "1"); a.Title != "Blue Train (Remastered)" {
+		t.Errorf("Get after Update = %+v", a)
+	}
+	if albums, _ := c.List(ctx); albums[0].Title != "Blue Train (Remastered)" {
+		t.Errorf("List after Update = %+v", albums)
+	}
+
+	c.Delete(ctx, "1")
+	if _, err := c.Get(ctx, "1"); !errors.Is(err, errAlbumNotFound) {
+		t.Errorf("Get after Delete: %v", err)
+	}
+	// Misses are not cached, so the album shows up once it is restored.
+	c.Restore(ctx, "1")
+	if _, err := c.Get(ctx, "1"); err != nil {
+		t.Errorf("Get after Restore: %v", err)
+	}
+}
+
+func TestCacheExpiryAndLRU(t *testing.T) {
+	c, store, m := newTestCache(t)
+	ctx := context.Background()
+	now := testTime
+	c.now = func() time.Time { return now }
+	c.maxEntries = 2
+
+	c.Get(ctx, "1")
+	now = now.Add(c.ttl)
+	c.Get(ctx, "1")
+	if n := store.gets.Load(); n != 2 {
+		t.Errorf("store gets = %d after expiry, want 2", n)
+	}
+
+	c.Get(ctx, "2")
+	c.Get(ctx, "1") // now more recently used than 2
+	c.Get(ctx, "3") // evicts 2
+	store.gets.Store(0)
+	c.Get(ctx, "1")
+	c.Get(ctx, "3")
+	if n := store.gets.Load(); n != 0 {
+		t.Errorf("recently used entries were evicted: %d store gets", n)
+	}
+	c.Get(ctx, "2")
+	if n := store.gets.Load(); n != 1 {
+		t.Errorf("least recently used entry was kept: %d store gets", n)
+	}
+
+	if n := counterValue(m.cacheEvictions, "expired"); n != 1 {
+		t.Errorf("expired evictions = %v, want 1", n)
+	}
+	if n := counterValue(m.cacheEvictions, "lru"); n != 2 {
+		t.Errorf("lru evictions = %v, want 2", n)
+	}
+}
+
+func TestCacheSharesConcurrentMisses(t *testing.T) {
+	c, store, m := newTestCache(t)
+	store.gate = make(chan struct{})
+
+	const n = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.Get(context.Background(), "2")
+			errs <- err
+		}()
+	}
+	eventually(t, "all readers to wait", func() bool {
+		shared := counterValue(m.cacheRequests, "get", "shared")
+		return store.gets.Load() == 1 && shared == n-1
+	})
+	close(store.gate)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if got := store.gets.Load(); got != 1 {
+		t.Errorf("store gets = %d, want 1", got)
+	}
+}
+
+func TestCacheDropsLoadRacingAWrite(t *testing.T) {
+	c, store, _ := newTestCache(t)
+	store.gate = make(chan struct{})
+	done := make(chan album)
+	go func() {
+		a, _ := c.Get(context.Background(), "2")
+		done <- a
+	}()
+	eventually(t, "load to start", func() bool { return store.gets.Load() == 1 })
+
+	// The update lands while the load is in flight. Whether the load
+	// read the old or new album, it must not be cached.
+	c.Update(context.Background(), album{ID: "2", Title: "Jeru (Live)"})
+	close(store.gate)
+	<-done
+	if a, _ := c.Get(context.Background(), "2"); a.Title != "Jeru (Live)" {
+		t.Errorf("Get after racing update = %+v", a)
+	}
+}
+
+func TestCacheControlHeaders(t *testing.T) {
+	h := newHarness(t, "albums")
+	for _, target := range []string{"/albums", "/albums/1"} {
+		w := h.do(http.MethodGet, target, "")
+		if got := w.Header().Get("Cache-Control"); got != "private, no-cache" {
+			t.Errorf("%s: Cache-Control = %q", target, got)
+		}
+		if vary := w.Header().Values("Vary"); len(vary) != 2 || vary[1] != "Accept, X-Tenant, X-API-Key" {
+			t.Errorf("%s: Vary = %q", target, vary)
+		}
+	}
+	// Catalogs behind API keys are kept out of every cache.
+	h.server.apiKeys = map[string]string{"bn-key": "blue-note"}
+	if got := h.do(http.MethodGet, "/albums", "", "X-API-Key: bn-key").Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("GET with an API key: Cache-Control = %q, want no-store", got)
+	}
+	for _, w := range []*httptest.ResponseRecorder{
+		h.do(http.MethodGet, "/albums/9", ""),
+		h.do(http.MethodGet, "/albums", "", "Accept: image/png"),
+	} {
+		if got := w.Header().Get("Cache-Control"); got != "" {
+			t.Errorf("error response has Cache-Control %q", got)
+		}
+		if got := w.Header().Get("ETag"); got != "" {
+			t.Errorf("error response has ETag %q", got)
+		}
+	}
+}
+
+func TestConditionalGet(t *testing.T) {
+	h := newHarness(t, "albums")
+	for _, target := range []string{"/albums", "/albums/2"} {
+		etag := h.do(http.MethodGet, target, "").Header().Get("ETag")
+		if etag == "" {
+			t.Fatalf("%s: no ETag", target)
+		}
+		w := h.do(http.MethodGet, target, "", "If-None-Match: "+etag)
+		if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+			t.Errorf("%s with its ETag: status %d, body %q; want an empty 304", target, w.Code, w.Body)
+		}
+		// Each representation has its own ETag.
+		if xml := h.do(http.MethodGet, target, "", "Accept: application/xml"); xml.Header().Get("ETag") == etag {
+			t.Errorf("%s: XML and JSON share the ETag %s", target, etag)
+		}
+
+		// A client's own write makes its copy stale at once, though the
+		// server's cache entry would have lived on.
+		h.do(http.MethodPut, "/albums/2", `{"title":"Jeru","artist":"Gerry Mulligan","price":`+fmt.Sprint(20+len(target))+`}`)
+		w = h.do(http.MethodGet, target, "", "If-None-Match: "+etag)
+		if w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+			t.Errorf("%s after a PUT: status %d, ETag %s; want a 200 with a new ETag", target, w.Code, w.Header().Get("ETag"))
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+
+	"github.com/Niku19/golearn/Database/albumdb"
+)
+
+// dbBackend works on the albums directly in the recordings database,
+// through the same repository the service's mysql store uses.
+type dbBackend struct {
+	db    *sql.DB
+	repo  *albumdb.Repository
+	actor string
+}
+
+func openDB(tenant, actor string) (*dbBackend, error) {
+	db, err := albumdb.Open(albumdb.ConfigFromEnv())
+	if err != nil {
+		return nil, err
+	}
+	repo := albumdb.New(db)
+	if tenant != "" {
+		repo = repo.ForTenant(tenant)
+	}
+	return &dbBackend{db: db, repo: repo, actor: actor}, nil
+}
+
+func (b *dbBackend) Close() error {
+	return b.db.Close()
+}
+
+func (b *dbBackend) List(ctx context.Context) ([]album, error) {
+	rows, err := b.repo.Albums(ctx)
+	if err != nil {
+		return nil, err
+	}
+	albums := make([]album, 0, len(rows))
+	for _, row := range rows {
+		albums = append(albums, fromRow(row))
+	}
+	return albums, nil
+}
+
+func (b *dbBackend) Get(ctx context.Context, id string) (album, error) {
+	n, err := parseID(id)
+	if err != nil {
+		return album{}, err
+	}
+	row, err := b.repo.AlbumByID(ctx, n)
+	if err != nil {
+		return album{}, dbError(err)
+	}
+	return fromRow(row), nil
+}
+
+// Add inserts a, letting the database assign the ID.
+func (b *dbBackend) Add(ctx context.Context, a album) (album, error) {
+	row, err := b.repo.AddAlbum(ctx, b.actor, toRow(a))
+	if err != nil {
+		return album{}, err
+	}
+	return fromRow(row), nil
+}
+
+func (b *dbBackend) Update(ctx context.Context, a album) (album, error) {
+	n, err := parseID(a.ID)
+	if err != nil {
+		return album{}, err
+	}
+	row := toRow(a)
+	row.ID = n
+	if row, err = b.repo.UpdateAlbum(ctx, b.actor, row); err != nil {
+		return album{}, dbError(err)
+	}
+	return fromRow(row), nil
+}
+
+func (b *dbBackend) Delete(ctx context.Context, id string) error {
+	n, err := parseID(id)
+	if err != nil {
+		return err
+	}
+	_, err = b.repo.DeleteAlbum(ctx, b.actor, n)
+	return dbError(err)
+}
+
+// parseID converts an album ID to the database's integer IDs; anything
+// else cannot name an album there.
+func parseID(id string) (int64, error) {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return 0, errNotFound
+	}
+	return n, nil
+}
+
+func dbError(err error) error {
+	if errors.Is(err, albumdb.ErrNotFound) {
+		return errNotFound
+	}
+	return err
+}
+
+// fromRow converts a database row to an album as the service serves
+// it.
+func fromRow(row albumdb.Album) album {
+	a := album{
+		ID:        strconv.FormatInt(row.ID, 10),
+		Title:     row.Title,
+		Artist:    row.Artist,
+		Price:     row.PriceFloat64(),
+		DeletedAt: row.DeletedAt,
+	}
+	if !row.CreatedAt.IsZero() {
+		a.CreatedAt = &row.CreatedAt
+	}
+	if !row.UpdatedAt.IsZero() {
+		a.UpdatedAt = &row.UpdatedAt
+	}
+	return a
+}
+
+// toRow converts an album to a database row. The ID is left to the
+// database.
+func toRow(a album) albumdb.Album {
+	return albumdb.Album{Title: a.Title, Artist: a.Artist, Price: albumdb.PriceFromFloat64(a.Price)}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// httpBackend works on the albums through the service's HTTP API.
+type httpBackend struct {
+	base   string
+	token  string
+	tenant string
+	actor  string
+	client *http.Client
+}
+
+func newHTTPBackend(base, token, tenant, actor string, timeout time.Duration) *httpBackend {
+	return &httpBackend{
+		base:   strings.TrimSuffix(base, "/"),
+		token:  token,
+		tenant: tenant,
+		actor:  actor,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (b *httpBackend) List(ctx context.Context) ([]album, error) {
+	var albums []album
+	err := b.do(ctx, http.MethodGet, "/albums", nil, &albums)
+	return albums, err
+}
+
+func (b *httpBackend) Get(ctx context.Context, id string) (album, error) {
+	var a album
+	err := b.do(ctx, http.MethodGet, "/albums/"+url.PathEscape(id), nil, &a)
+	return a, err
+}
+
+func (b *httpBackend) Add(ctx context.Context, in album) (album, error) {
+	var a album
+	err := b.do(ctx, http.MethodPost, "/albums", in, &a)
+	return a, err
+}
+
+func (b *httpBackend) Update(ctx context.Context, in album) (album, error) {
+	var a album
+	err := b.do(ctx, http.MethodPut, "/albums/"+url.PathEscape(in.ID), in, &a)
+	return a, err
+}
+
+func (b *httpBackend) Delete(ctx context.Context, id string) error {
+	return b.do(ctx, http.MethodDelete, "/albums/"+url.PathEscape(id), nil, nil)
+}
+
+// do sends body, if any, as JSON and decodes a successful response into
+// out, if any. Not found and conflict answers become errNotFound and
+// errExists; other failures carry the service's error message.
+func (b *httpBackend) do(ctx context.Context, method, path string, body, out any) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, b.base+path, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for header, v := range map[string]string{"X-API-Key": b.token, "X-Tenant": b.tenant, "X-Actor": b.actor} {
+		if v != "" {
+			req.Header.Set(header, v)
+		}
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return errNotFound
+	case resp.StatusCode == http.StatusConflict:
+		return errExists
+	case resp.StatusCode >= 300:
+		var e struct {
+			Message string `json:"message"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&e) != nil || e.Message == "" {
+			e.Message = resp.Status
+		}
+		return fmt.Errorf("%s %s: %s", method, path, e.Message)
+	case out != nil:
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("%s %s: decoding response: %w", method, path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,338 @@
+// Command albumctl reads and changes the album catalog, either through
+// the service's HTTP API or, with -db, directly in the recordings
+// database.
+//
+// Usage:
+//
+//	albumctl [flags] list
+//	albumctl [flags] get ID
+//	albumctl [flags] add [-id ID] -title TITLE -artist ARTIST -price PRICE
+//	albumctl [flags] update ID [-title TITLE] [-artist ARTIST] [-price PRICE]
+//	albumctl [flags] delete ID
+//	albumctl [flags] import FILE
+//	albumctl [flags] export [FILE]
+//
+// FILE holds a JSON array of albums in the format GET /albums returns,
+// so an export can be imported again; "-" is standard input or output.
+//
+// The service is at ALBUMCTL_URL, http://localhost:8080 by default, and
+// requests carry ALBUMCTL_TOKEN as their API key and ALBUMCTL_TENANT as
+// their tenant. With -db the database is reached with DBUSER and DBPASS
+// as the service does; it assigns IDs itself, so -id is ignored and
+// importing a file twice adds its albums twice.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// album is an album as the API serves it.
+type album struct {
+	ID        string     `json:"id"`
+	Title     string     `json:"title"`
+	Artist    string     `json:"artist"`
+	Price     float64    `json:"price"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+var (
+	errNotFound = errors.New("album not found")
+	errExists   = errors.New("album already exists")
+)
+
+// backend is where albumctl reads and writes albums: the HTTP API or
+// the database.
+type backend interface {
+	List(ctx context.Context) ([]album, error)
+	Get(ctx context.Context, id string) (album, error)
+	Add(ctx context.Context, a album) (album, error)
+	Update(ctx context.Context, a album) (album, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// usageError is a mistake in the command line rather than a failure to
+// carry it out.
+type usageError struct{ msg string }
+
+func (e usageError) Error() string { return e.msg }
+
+func main() {
+	err := run(context.Background(), os.Args[1:], os.Stdin, os.Stdout, os.Getenv)
+	var usage usageError
+	switch {
+	case errors.As(err, &usage):
+		fmt.Fprintln(os.Stderr, "albumctl:", err)
+		fmt.Fprintln(os.Stderr, "run albumctl -h for usage")
+		os.Exit(2)
+	case errors.Is(err, flag.ErrHelp):
+		os.Exit(2)
+	case err != nil:
+		fmt.Fprintln(os.Stderr, "albumctl:", err)
+		os.Exit(1)
+	}
+}
+
+// run carries out the command line args, reading imports from stdin and
+// writing output to stdout. The environment is read through getenv.
+func run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer, getenv func(string) string) error {
+	fs := flag.NewFlagSet("albumctl", flag.ContinueOnError)
+	baseURL := fs.String("url", envOr(getenv, "ALBUMCTL_URL", "http://localhost:8080"), "base `URL` of the album service")
+	token := fs.String("token", getenv("ALBUMCTL_TOKEN"), "API key to send as X-API-Key")
+	tenant := fs.String("tenant", getenv("ALBUMCTL_TENANT"), "tenant whose catalog to use")
+	actor := fs.String("actor", envOr(getenv, "ALBUMCTL_ACTOR", getenv("USER")), "who the changes are recorded as made by")
+	output := fs.String("o", "table", "output `format`: table or json")
+	useDB := fs.Bool("db", false, "work on the recordings database instead of the service")
+	timeout := fs.Duration("timeout", 30*time.Second, "give up on the service after this long")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: albumctl [flags] list | get ID | add | update ID | delete ID | import FILE | export [FILE]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *output != "table" && *output != "json" {
+		return usageError{fmt.Sprintf("unknown output format %q", *output)}
+	}
+	if fs.NArg() == 0 {
+		return usageError{"no command given"}
+	}
+
+	var b backend
+	if *useDB {
+		db, err := openDB(*tenant, *actor)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		b = db
+	} else {
+		b = newHTTPBackend(*baseURL, *token, *tenant, *actor, *timeout)
+	}
+	p := printer{w: stdout, json: *output == "json"}
+
+	cmd, args := fs.Arg(0), fs.Args()[1:]
+	switch cmd {
+	case "list":
+		if len(args) != 0 {
+			return usageError{"list takes no arguments"}
+		}
+		albums, err := b.List(ctx)
+		if err != nil {
+			return err
+		}
+		return p.albums(albums)
+	case "get":
+		id, err := oneID(cmd, args)
+		if err != nil {
+			return err
+		}
+		a, err := b.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		return p.album(a)
+	case "add":
+		return add(ctx, b, p, args)
+	case "update":
+		return update(ctx, b, p, args)
+	case "delete":
+		id, err := oneID(cmd, args)
+		if err != nil {
+			return err
+		}
+		return b.Delete(ctx, id)
+	case "import":
+		if len(args) != 1 {
+			return usageError{"import takes one FILE"}
+		}
+		return importAlbums(ctx, b, stdin, stdout, args[0])
+	case "export":
+		if len(args) > 1 {
+			return usageError{"export takes at most one FILE"}
+		}
+		path := "-"
+		if len(args) == 1 {
+			path = args[0]
+		}
+		return exportAlbums(ctx, b, stdout, path)
+	}
+	return usageError{fmt.Sprintf("unknown command %q", cmd)}
+}
+
+func envOr(getenv func(string) string, key, fallback string) string {
+	if v := getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func oneID(cmd string, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", usageError{cmd + " takes one album ID"}
+	}
+	return args[0], nil
+}
+
+// albumFlags registers the flags setting the fields of a.
+func albumFlags(fs *flag.FlagSet, a *album) {
+	fs.StringVar(&a.Title, "title", "", "album title")
+	fs.StringVar(&a.Artist, "artist", "", "album artist")
+	fs.Float64Var(&a.Price, "price", 0, "album price")
+}
+
+func add(ctx context.Context, b backend, p printer, args []string) error {
+	var a album
+	fs := flag.NewFlagSet("add", flag.ContinueOnError)
+	fs.StringVar(&a.ID, "id", "", "album ID")
+	albumFlags(fs, &a)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 || a.Title == "" || a.Artist == "" {
+		return usageError{"add takes -title, -artist and -price"}
+	}
+	added, err := b.Add(ctx, a)
+	if err != nil {
+		return err
+	}
+	return p.album(added)
+}
+
+// update changes only the fields given as flags, keeping the rest of
+// the album as stored.
+func update(ctx context.Context, b backend, p printer, args []string) error {
+	if len(args) == 0 {
+		return usageError{"update takes an album ID before its flags"}
+	}
+	id := args[0]
+	var changes album
+	fs := flag.NewFlagSet("update", flag.ContinueOnError)
+	albumFlags(fs, &changes)
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 || fs.NFlag() == 0 {
+		return usageError{"update takes -title, -artist or -price to change"}
+	}
+
+	a, err := b.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "title":
+			a.Title = changes.Title
+		case "artist":
+			a.Artist = changes.Artist
+		case "price":
+			a.Price = changes.Price
+		}
+	})
+	updated, err := b.Update(ctx, a)
+	if err != nil {
+		return err
+	}
+	return p.album(updated)
+}
+
+// importAlbums adds the albums in the file at path. Albums that already
+// exist are skipped, so an import that failed part way can be run again.
+func importAlbums(ctx context.Context, b backend, stdin io.Reader, stdout io.Writer, path string) error {
+	r := stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	var albums []album
+	if err := json.NewDecoder(r).Decode(&albums); err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	var added, skipped int
+	for _, a := range albums {
+		// The timestamps of an export are the store's to set.
+		a.CreatedAt, a.UpdatedAt = nil, nil
+		_, err := b.Add(ctx, a)
+		switch {
+		case errors.Is(err, errExists):
+			skipped++
+		case err != nil:
+			return fmt.Errorf("importing album %s: %w (%d added before it)", a.ID, err, added)
+		default:
+			added++
+		}
+	}
+	fmt.Fprintf(stdout, "%d added, %d already existed\n", added, skipped)
+	return nil
+}
+
+func exportAlbums(ctx context.Context, b backend, stdout io.Writer, path string) error {
+	albums, err := b.List(ctx)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(albums, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if path == "-" {
+		_, err := stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// printer writes albums as a table or as JSON.
+type printer struct {
+	w    io.Writer
+	json bool
+}
+
+func (p printer) album(a album) error {
+	if p.json {
+		return p.encode(a)
+	}
+	return p.table([]album{a})
+}
+
+func (p printer) albums(albums []album) error {
+	if p.json {
+		if albums == nil {
+			albums = []album{}
+		}
+		return p.encode(albums)
+	}
+	return p.table(albums)
+}
+
+func (p printer) encode(v any) error {
+	enc := json.NewEncoder(p.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func (p printer) table(albums []album) error {
+	tw := tabwriter.NewWriter(p.w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTITLE\tARTIST\tPRICE")
+	for _, a := range albums {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", a.ID, a.Title, a.Artist, strconv.FormatFloat(a.Price, 'f', 2, 64))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeService serves the album routes albumctl uses from a map, and
+// records the headers of the last request.
+type fakeService struct {
+	mu     sync.Mutex
+	albums map[string]album
+	order  []string
+	header http.Header
+}
+
+func newFakeService(t *testing.T, albums ...album) (*fakeService, *httptest.Server) {
+	f := &fakeService{albums: make(map[string]album)}
+	for _, a := range albums {
+		f.add(a)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /albums", func(w http.ResponseWriter, r *http.Request) {
+		list := []album{}
+		for _, id := range f.order {
+			list = append(list, f.albums[id])
+		}
+		f.reply(w, http.StatusOK, list)
+	})
+	mux.HandleFunc("GET /albums/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if a, ok := f.albums[r.PathValue("id")]; ok {
+			f.reply(w, http.StatusOK, a)
+			return
+		}
+		f.reply(w, http.StatusNotFound, map[string]string{"message": "album not found"})
+	})
+	mux.HandleFunc("POST /albums", func(w http.ResponseWriter, r *http.Request) {
+		var a album
+		json.NewDecoder(r.Body).Decode(&a)
+		if _, ok := f.albums[a.ID]; ok {
+			f.reply(w, http.StatusConflict, map[string]string{"message": "album already exists"})
+			return
+		}
+		f.add(a)
+		f.reply(w, http.StatusCreated, a)
+	})
+	mux.HandleFunc("PUT /albums/{id}", func(w http.ResponseWriter, r *http.Request) {
+		var a album
+		json.NewDecoder(r.Body).Decode(&a)
+		a.ID = r.PathValue("id")
+		f.albums[a.ID] = a
+		f.reply(w, http.StatusOK, a)
+	})
+	mux.HandleFunc("DELETE /albums/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := f.albums[r.PathValue("id")]; !ok {
+			f.reply(w, http.StatusNotFound, map[string]string{"message": "album not found"})
+			return
+		}
+		delete(f.albums, r.PathValue("id"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.header = r.Header.Clone()
+		if r.Header.Get("X-Tenant") == "Blue Note" {
+			f.reply(w, http.StatusBadRequest, map[string]string{"message": "tenant must be lower-case letters, digits and dashes"})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func (f *fakeService) add(a album) {
+	f.albums[a.ID] = a
+	f.order = append(f.order, a.ID)
+}
+
+func (f *fakeService) reply(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+var seed = []album{
+	{ID: "1", Title: "Blue Train", Artist: "John Coltrane", Price: 56.99},
+	{ID: "2", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99},
+}
+
+// albumctl runs the command line against srv and returns its output.
+func albumctl(t *testing.T, srv *httptest.Server, stdin string, args ...string) (string, error) {
+	t.Helper()
+	env := map[string]string{"ALBUMCTL_URL": srv.URL, "ALBUMCTL_TOKEN": "s3cret", "USER": "alice"}
+	var out bytes.Buffer
+	err := run(context.Background(), args, strings.NewReader(stdin), &out, func(k string) string { return env[k] })
+	return out.String(), err
+}
+
+func TestListPrintsTable(t *testing.T) {
+	f, srv := newFakeService(t, seed...)
+	out, err := albumctl(t, srv, "", "-tenant", "blue-note", "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "" +
+		"ID  TITLE       ARTIST          PRICE\n" +
+		"1   Blue Train  John Coltrane   56.99\n" +
+		"2   Jeru        Gerry Mulligan  17.99\n"
+	if out != want {
+		t.Errorf("list printed\n%s\nwant\n%s", out, want)
+	}
+	for header, want := range map[string]string{"X-Api-Key": "s3cret", "X-Tenant": "blue-note", "X-Actor": "alice"} {
+		if got := f.header.Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestGetPrintsJSON(t *testing.T) {
+	_, srv := newFakeService(t, seed...)
+	out, err := albumctl(t, srv, "", "-o", "json", "get", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var a album
+	if err := json.Unmarshal([]byte(out), &a); err != nil || a != seed[1] {
+		t.Errorf("get printed %q (%v), want album 2", out, err)
+	}
+
+	if _, err := albumctl(t, srv, "", "get", "99"); !errors.Is(err, errNotFound) {
+		t.Errorf("get 99 = %v, want errNotFound", err)
+	}
+	if _, err := albumctl(t, srv, "", "-tenant", "Blue Note", "get", "2"); err == nil || !strings.Contains(err.Error(), "tenant must be") {
+		t.Errorf("get with a bad tenant = %v, want the service's message", err)
+	}
+}
+
+func TestAddUpdateDelete(t *testing.T) {
+	f, srv := newFakeService(t, seed...)
+	if _, err := albumctl(t, srv, "", "add", "-id", "4", "-title", "Giant Steps", "-artist", "John Coltrane", "-price", "63.99"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := albumctl(t, srv, "", "add", "-id", "4", "-title", "Giant Steps", "-artist", "John Coltrane"); !errors.Is(err, errExists) {
+		t.Errorf("adding album 4 again = %v, want errExists", err)
+	}
+
+	// Only the flags given change.
+	if _, err := albumctl(t, srv, "", "update", "4", "-price", "49.99"); err != nil {
+		t.Fatal(err)
+	}
+	want := album{ID: "4", Title: "Giant Steps", Artist: "John Coltrane", Price: 49.99}
+	if got := f.albums["4"]; got != want {
+		t.Errorf("after update album 4 = %+v, want %+v", got, want)
+	}
+
+	if _, err := albumctl(t, srv, "", "delete", "4"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.albums["4"]; ok {
+		t.Error("album 4 was not deleted")
+	}
+	if _, err := albumctl(t, srv, "", "delete", "4"); !errors.Is(err, errNotFound) {
+		t.Errorf("deleting album 4 again = %v, want errNotFound", err)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	_, from := newFakeService(t, seed...)
+	path := filepath.Join(t.TempDir(), "albums.json")
+	if _, err := albumctl(t, from, "", "export", path); err != nil {
+		t.Fatal(err)
+	}
+
+	to, srv := newFakeService(t, seed[1])
+	out, err := albumctl(t, srv, "", "import", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "1 added, 1 already existed\n" {
+		t.Errorf("import printed %q", out)
+	}
+	if len(to.albums) != 2 || to.albums["1"] != seed[0] {
+		t.Errorf("imported albums = %+v", to.albums)
+	}
+
+	// An export to standard output can be piped into an import.
+	exported, err := albumctl(t, from, "", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if exported != string(data) {
+		t.Errorf("export to stdout = %q, want the file's %q", exported, data)
+	}
+	if out, err := albumctl(t, srv, exported, "import", "-"); err != nil || out != "0 added, 2 already existed\n" {
+		t.Errorf("import from stdin printed %q, %v", out, err)
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	_, srv := newFakeService(t)
+	for _, args := range [][]string{
+		nil,
+		{"play"},
+		{"get"},
+		{"list", "extra"},
+		{"-o", "yaml", "list"},
+		{"add", "-title", "Jeru"},
+		{"update", "2"},
+		{"import"},
+	} {
+		var usage usageError
+		if _, err := albumctl(t, srv, "", args...); !errors.As(err, &usage) {
+			t.Errorf("albumctl %q = %v, want a usage error", args, err)
+		}
+	}
+}
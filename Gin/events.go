@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Event types published when the catalog changes.
+const (
+	eventAlbumCreated  = "album.created"
+	eventAlbumUpdated  = "album.updated"
+	eventAlbumDeleted  = "album.deleted"
+	eventAlbumRestored = "album.restored"
+)
+
+// albumEvent records one change to a tenant's catalog. IDs increase by
+// one per event across all tenants, which is what lets SSE clients
+// resume with Last-Event-ID; a tenant's stream skips the IDs of other
+// tenants' events.
+type albumEvent struct {
+	ID     uint64    `json:"id" xml:"id"`
+	Type   string    `json:"type" xml:"type"`
+	Time   time.Time `json:"time" xml:"time"`
+	Tenant string    `json:"tenant" xml:"tenant"`
+	Album  album     `json:"album" xml:"album"`
+}
+
+// eventBus fans album events out to subscribers and keeps the most
+// recent ones so late subscribers can catch up.
+type eventBus struct {
+	mu      sync.Mutex
+	nextID  uint64
+	history []albumEvent // oldest first, at most historySize long
+	subs    map[*subscription]struct{}
+
+	historySize int
+	// bufferSize is how many events a subscriber may fall behind
+	// before it is dropped and has to resubscribe.
+	bufferSize int
+	now        func() time.Time
+}
+
+// subscription is a subscriber's view of the bus. C is closed when the
+// subscription is cancelled or the subscriber falls too far behind.
+type subscription struct {
+	C      chan albumEvent
+	tenant string // or "" for every tenant's events
+	closed bool
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{
+		nextID:      1,
+		subs:        make(map[*subscription]struct{}),
+		historySize: 1000,
+		bufferSize:  64,
+		now:         time.Now,
+	}
+}
+
+// publish records an event and hands it to every subscriber. It never
+// blocks: a subscriber whose buffer is full is dropped, and is expected
+// to resubscribe from the last event it saw.
+func (b *eventBus) publish(tenant, typ string, a album) albumEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ev := albumEvent{ID: b.nextID, Type: typ, Time: b.now().UTC(), Tenant: tenant, Album: a}
+	b.nextID++
+	b.history = append(b.history, ev)
+	if len(b.history) > b.historySize {
+		b.history = append(b.history[:0], b.history[len(b.history)-b.historySize:]...)
+	}
+
+	for sub := range b.subs {
+		if !sub.wants(ev) {
+			continue
+		}
+		select {
+		case sub.C <- ev:
+		default:
+			b.closeLocked(sub)
+		}
+	}
+	return ev
+}
+
+// subscribe returns the retained events of tenant with an ID greater
+// than after, and a subscription delivering every event of tenant
+// published from now on. Taking both under one lock means no event
+// falls between them. An empty tenant subscribes to every tenant.
+func (b *eventBus) subscribe(tenant string, after uint64) ([]albumEvent, *subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub := &subscription{C: make(chan albumEvent, b.bufferSize), tenant: tenant}
+	var backlog []albumEvent
+	for _, ev := range b.history {
+		if ev.ID > after && sub.wants(ev) {
+			backlog = append(backlog, ev)
+		}
+	}
+	b.subs[sub] = struct{}{}
+	return backlog, sub
+}
+
+func (sub *subscription) wants(ev albumEvent) bool {
+	return sub.tenant == "" || sub.tenant == ev.Tenant
+}
+
+// lastID returns the ID of the most recent event, or 0 if none has
+// been published.
+func (b *eventBus) lastID() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.nextID - 1
+}
+
+// unsubscribe cancels sub. It is safe to call more than once.
+func (b *eventBus) unsubscribe(sub *subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closeLocked(sub)
+}
+
+func (b *eventBus) closeLocked(sub *subscription) {
+	if !sub.closed {
+		sub.closed = true
+		close(sub.C)
+		delete(b.subs, sub)
+	}
+}
+
+// eventingStore wraps an albumStore, publishing an event for every
+// successful write, whichever API the write came through.
+type eventingStore struct {
+	albumStore
+	events *eventBus
+}
+
+func (s *eventingStore) Add(ctx context.Context, in album) (album, error) {
+	a, err := s.albumStore.Add(ctx, in)
+	if err == nil {
+		s.events.publish(tenantFrom(ctx), eventAlbumCreated, a)
+	}
+	return a, err
+}
+
+func (s *eventingStore) Update(ctx context.Context, in album) (album, error) {
+	a, err := s.albumStore.Update(ctx, in)
+	if err == nil {
+		s.events.publish(tenantFrom(ctx), eventAlbumUpdated, a)
+	}
+	return a, err
+}
+
+func (s *eventingStore) Delete(ctx context.Context, id string) (album, error) {
+	a, err := s.albumStore.Delete(ctx, id)
+	if err == nil {
+		s.events.publish(tenantFrom(ctx), eventAlbumDeleted, a)
+	}
+	return a, err
+}
+
+func (s *eventingStore) Restore(ctx context.Context, id string) (album, error) {
+	a, err := s.albumStore.Restore(ctx, id)
+	if err == nil {
+		s.events.publish(tenantFrom(ctx), eventAlbumRestored, a)
+	}
+	return a, err
+}
+
+// sseKeepAlive is how often an idle event stream sends a comment so
+// proxies do not time the connection out.
+var sseKeepAlive = 15 * time.Second
+
+// streamAlbumEvents serves the album events of the request's tenant as
+// Server-Sent Events. A client reconnecting with Last-Event-ID first
+// receives the events it missed.
+func (s *server) streamAlbumEvents(c *gin.Context) {
+	var after uint64
+	if last := c.GetHeader("Last-Event-ID"); last != "" {
+		n, err := strconv.ParseUint(last, 10, 64)
+		if err != nil {
+			s.respond(c, http.StatusBadRequest, errorResponse{Message: "Last-Event-ID must be an event ID"})
+			return
+		}
+		after = n
+	}
+
+	backlog, sub := s.events.subscribe(tenantFrom(c.Request.Context()), after)
+	defer s.events.unsubscribe(sub)
+
+	h := c.Writer.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	c.Status(http.StatusOK)
+	// Tell EventSource how long to wait before reconnecting.
+	fmt.Fprint(c.Writer, "retry: 1000\n\n")
+	for _, ev := range backlog {
+		writeSSE(c.Writer, ev)
+	}
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case ev, ok := <-sub.C:
+			if !ok {
+				// Dropped for falling behind; the client reconnects
+				// with Last-Event-ID and catches up from history.
+				return
+			}
+			writeSSE(c.Writer, ev)
+		case <-keepAlive.C:
+			fmt.Fprint(c.Writer, ": keep-alive\n\n")
+		}
+		c.Writer.Flush()
+	}
+}
+
+// writeSSE writes ev in the text/event-stream format.
+func writeSSE(w gin.ResponseWriter, ev albumEvent) {
+	data, _ := json.Marshal(ev)
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+}
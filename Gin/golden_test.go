@@ -0,0 +1,174 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// goldenCase is one request whose full response is pinned by a golden
+// file named after the case.
+type goldenCase struct {
+	name    string
+	route   string // the registered METHOD and path the request hits
+	method  string
+	target  string
+	body    string
+	headers []string
+	// broken serves the request from a store that always fails.
+	broken bool
+	// stream cancels the request after a moment, for event streams.
+	stream bool
+	// before sends requests first, as method, target and body, e.g. to
+	// populate /metrics.
+	before [][3]string
+	// beforeHeaders are sent with every request in before.
+	beforeHeaders []string
+}
+
+var goldenCases = []goldenCase{
+	{name: "list", route: "GET /albums", method: "GET", target: "/albums"},
+	{name: "list_pretty", route: "GET /albums", method: "GET", target: "/albums?pretty"},
+	{name: "list_xml", route: "GET /albums", method: "GET", target: "/albums", headers: []string{"Accept: application/xml"}},
+	{name: "list_csv", route: "GET /albums", method: "GET", target: "/albums", headers: []string{"Accept: text/csv"}},
+	{name: "list_not_acceptable", route: "GET /albums", method: "GET", target: "/albums", headers: []string{"Accept: image/png"}},
+	{name: "list_store_error", route: "GET /albums", method: "GET", target: "/albums", broken: true},
+	{name: "list_other_tenant", route: "GET /albums", method: "GET", target: "/albums", headers: []string{"X-Tenant: blue-note"},
+		before: [][3]string{{"POST", "/albums", `{"id":"4","title":"Giant Steps","artist":"John Coltrane","price":63.99}`}}},
+	{name: "list_invalid_tenant", route: "GET /albums", method: "GET", target: "/albums", headers: []string{"X-Tenant: Blue Note"}},
+	{name: "get", route: "GET /albums/:id", method: "GET", target: "/albums/2"},
+	{name: "get_not_found", route: "GET /albums/:id", method: "GET", target: "/albums/99"},
+	{name: "get_not_found_xml", route: "GET /albums/:id", method: "GET", target: "/albums/99", headers: []string{"Accept: application/xml"}},
+	{name: "post_created", route: "POST /albums", method: "POST", target: "/albums",
+		body: `{"id":"4","title":"Giant Steps","artist":"John Coltrane","price":63.99}`},
+	{name: "post_conflict", route: "POST /albums", method: "POST", target: "/albums",
+		body: `{"id": "1", "title": "Blue Train", "artist": "John Coltrane", "price": 56.99}`},
+	{name: "post_invalid_json", route: "POST /albums", method: "POST", target: "/albums", body: `{"id": "4", "title": `},
+	{name: "post_wrong_type", route: "POST /albums", method: "POST", target: "/albums", body: `{"id": "4", "price": "cheap"}`},
+	{name: "post_replayed", route: "POST /albums", method: "POST", target: "/albums",
+		body: `{"id":"4","title":"Giant Steps","artist":"John Coltrane","price":63.99}`, headers: []string{"Idempotency-Key: 8e2c1f"},
+		before:        [][3]string{{"POST", "/albums", `{"id":"4","title":"Giant Steps","artist":"John Coltrane","price":63.99}`}},
+		beforeHeaders: []string{"Idempotency-Key: 8e2c1f"}},
+	{name: "post_idempotency_key_reused", route: "POST /albums", method: "POST", target: "/albums",
+		body: `{"id":"5","title":"Jeru","artist":"Gerry Mulligan","price":17.99}`, headers: []string{"Idempotency-Key: 8e2c1f"},
+		before:        [][3]string{{"POST", "/albums", `{"id":"4","title":"Giant Steps","artist":"John Coltrane","price":63.99}`}},
+		beforeHeaders: []string{"Idempotency-Key: 8e2c1f"}},
+	{name: "put", route: "PUT /albums/:id", method: "PUT", target: "/albums/2",
+		body: `{"id":"ignored","title":"Jeru","artist":"Gerry Mulligan","price":19.99}`},
+	{name: "put_not_found", route: "PUT /albums/:id", method: "PUT", target: "/albums/99",
+		body: `{"title":"Jeru","artist":"Gerry Mulligan","price":19.99}`},
+	{name: "put_invalid_json", route: "PUT /albums/:id", method: "PUT", target: "/albums/2", body: `{"title"`},
+	{name: "delete", route: "DELETE /albums/:id", method: "DELETE", target: "/albums/3"},
+	{name: "delete_not_found", route: "DELETE /albums/:id", method: "DELETE", target: "/albums/99"},
+	{name: "history", route: "GET /albums/:id/history", method: "GET", target: "/albums/2/history",
+		before: [][3]string{
+			{"PUT", "/albums/2", `{"title":"Jeru","artist":"Gerry Mulligan","price":19.99}`},
+			{"DELETE", "/albums/2", ""},
+		}},
+	{name: "history_xml", route: "GET /albums/:id/history", method: "GET", target: "/albums/2/history",
+		headers: []string{"Accept: application/xml"},
+		before:  [][3]string{{"PUT", "/albums/2", `{"title":"Jeru","artist":"Gerry Mulligan","price":19.99}`}}},
+	{name: "history_not_found", route: "GET /albums/:id/history", method: "GET", target: "/albums/99/history"},
+	{name: "restore", route: "POST /albums/:id/restore", method: "POST", target: "/albums/3/restore",
+		before: [][3]string{{"DELETE", "/albums/3", ""}}},
+	{name: "restore_not_deleted", route: "POST /albums/:id/restore", method: "POST", target: "/albums/1/restore"},
+	{name: "restore_not_found", route: "POST /albums/:id/restore", method: "POST", target: "/albums/99/restore"},
+	{name: "events", route: "GET /albums/events", method: "GET", target: "/albums/events", stream: true,
+		before: [][3]string{
+			{"POST", "/albums", `{"id":"4","title":"Giant Steps","artist":"John Coltrane","price":63.99}`},
+			{"PUT", "/albums/4", `{"title":"Giant Steps","artist":"John Coltrane","price":49.99}`},
+			{"DELETE", "/albums/1", ""},
+		}},
+	{name: "events_resume", route: "GET /albums/events", method: "GET", target: "/albums/events", stream: true,
+		headers: []string{"Last-Event-ID: 2"},
+		before: [][3]string{
+			{"POST", "/albums", `{"id":"4","title":"Giant Steps","artist":"John Coltrane","price":63.99}`},
+			{"PUT", "/albums/4", `{"title":"Giant Steps","artist":"John Coltrane","price":49.99}`},
+			{"DELETE", "/albums/1", ""},
+		}},
+	{name: "events_bad_last_event_id", route: "GET /albums/events", method: "GET", target: "/albums/events",
+		headers: []string{"Last-Event-ID: yesterday"}},
+	{name: "dead_letters", route: "GET /webhooks/dead-letters", method: "GET", target: "/webhooks/dead-letters"},
+	{name: "graphql_nested", route: "POST /graphql", method: "POST", target: "/graphql",
+		body: `{"query":"{ albums(first: 2) { totalCount pageInfo { hasNextPage endCursor } nodes { id title artist { name albums { title } } } } }"}`},
+	{name: "graphql_depth_limit", route: "POST /graphql", method: "POST", target: "/graphql",
+		body: `{"query":"{ album(id: \"1\") { artist { albums { artist { albums { artist { albums { artist { albums { id } } } } } } } } } }"}`},
+	{name: "metrics", route: "GET /metrics", method: "GET", target: "/metrics",
+		before: [][3]string{{"GET", "/albums", ""}, {"GET", "/albums/1", ""}, {"GET", "/albums/99", ""}}},
+	{name: "openapi", route: "GET /openapi.json", method: "GET", target: "/openapi.json"},
+}
+
+func TestGolden(t *testing.T) {
+	for _, tc := range goldenCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newHarness(t, "albums")
+			if tc.broken {
+				h = newHarnessWithStore(t, failingStore{})
+			}
+			for _, req := range tc.before {
+				h.do(req[0], req[1], req[2], tc.beforeHeaders...)
+			}
+			var w *httptest.ResponseRecorder
+			if tc.stream {
+				w = h.stream(20*time.Millisecond, tc.target, tc.headers...)
+			} else {
+				w = h.do(tc.method, tc.target, tc.body, tc.headers...)
+			}
+			got := formatResponse(w)
+			if tc.name == "metrics" {
+				got = scrubDurations(got)
+			}
+			assertGolden(t, tc.name, got)
+		})
+	}
+}
+
+// TestGoldenCoversEveryRoute fails when a route is registered without a
+// golden case, so new endpoints get pinned too.
+func TestGoldenCoversEveryRoute(t *testing.T) {
+	covered := make(map[string]bool)
+	for _, tc := range goldenCases {
+		covered[tc.route] = true
+	}
+	for _, r := range newHarness(t, "empty").router.Routes() {
+		if !covered[r.Method+" "+r.Path] {
+			t.Errorf("no golden case for %s %s", r.Method, r.Path)
+		}
+	}
+}
+
+func TestPostedAlbumIsListed(t *testing.T) {
+	h := newHarness(t, "empty")
+	w := h.do(http.MethodPost, "/albums", `{"id":"7","title":"Kind of Blue","artist":"Miles Davis","price":29.99}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST: status %d", w.Code)
+	}
+
+	var list []album
+	decode(t, h.do(http.MethodGet, "/albums", ""), &list)
+	want := album{ID: "7", Title: "Kind of Blue", Artist: "Miles Davis", Price: 29.99}
+	if len(list) != 1 || untimed(list...)[0] != want {
+		t.Errorf("GET /albums = %+v, want [%+v]", list, want)
+	}
+
+	var got album
+	decode(t, h.do(http.MethodGet, "/albums/7", ""), &got)
+	if untimed(got)[0] != want {
+		t.Errorf("GET /albums/7 = %+v, want %+v", got, want)
+	}
+}
+
+func TestHarnessIsolatesStores(t *testing.T) {
+	a := newHarness(t, "albums")
+	a.do(http.MethodPost, "/albums", `{"id":"4","title":"Giant Steps","artist":"John Coltrane","price":63.99}`)
+
+	var list []album
+	decode(t, newHarness(t, "albums").do(http.MethodGet, "/albums", ""), &list)
+	if len(list) != 3 {
+		t.Errorf("second harness sees %d albums, want the 3 from the fixture", len(list))
+	}
+	if len(albums) != 3 {
+		t.Errorf("global seed was modified: %d albums", len(albums))
+	}
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+// maxQueryDepth is how deeply selections may nest in a GraphQL query.
+// Album and Artist refer to each other, so without a limit a short
+// query could ask for an arbitrarily large response.
+const maxQueryDepth = 8
+
+// graphqlRequest is the body of a POST /graphql request.
+type graphqlRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+}
+
+// graphqlResponse is the body of every /graphql response.
+type graphqlResponse struct {
+	Data   map[string]any `json:"data,omitempty"`
+	Errors []graphqlError `json:"errors,omitempty"`
+}
+
+type graphqlError struct {
+	Message string `json:"message"`
+}
+
+// artist is the GraphQL view of an album's artist string.
+type artist struct {
+	Name string `json:"name"`
+}
+
+// albumConnection is one page of an albums query.
+type albumConnection struct {
+	TotalCount int      `json:"totalCount"`
+	Nodes      []album  `json:"nodes"`
+	PageInfo   pageInfo `json:"pageInfo"`
+}
+
+type pageInfo struct {
+	HasNextPage bool   `json:"hasNextPage"`
+	EndCursor   string `json:"endCursor"`
+}
+
+// newGraphQLSchema builds the schema over store. The resolvers go
+// through the same store as the REST handlers, so writes made here
+// are timed and published as events like any other.
+//
+// Albums nest their artist, and artists their albums. There is no
+// Track type: neither the album table nor the in-memory stores keep
+// track listings, so a tracks field could only ever be empty. It
+// belongs here once the store has tracks to serve.
+func newGraphQLSchema(store albumStore) (graphql.Schema, error) {
+	var albumType, artistType *graphql.Object
+
+	albumType = graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Album",
+		Description: "A record album. Track listings are not kept, so albums have no tracks.",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"id":    &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+				"title": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"price": &graphql.Field{Type: graphql.NewNonNull(graphql.Float)},
+				"artist": &graphql.Field{
+					Type: graphql.NewNonNull(artistType),
+					Resolve: func(p graphql.ResolveParams) (any, error) {
+						return artist{Name: p.Source.(album).Artist}, nil
+					},
+				},
+			}
+		}),
+	})
+
+	artistType = graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Artist",
+		Description: "The artist credited on one or more albums.",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"name": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"albums": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(albumType))),
+					Description: "Every album by this artist. Loaded in one batch for all artists in the response.",
+					Resolve: func(p graphql.ResolveParams) (any, error) {
+						return loaderFrom(p.Context).load(p.Source.(artist).Name), nil
+					},
+				},
+			}
+		}),
+	})
+
+	pageInfoType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "PageInfo",
+		Fields: graphql.Fields{
+			"hasNextPage": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+			"endCursor":   &graphql.Field{Type: graphql.NewNonNull(graphql.String), Description: "Pass as after to fetch the next page."},
+		},
+	})
+
+	connectionType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "AlbumConnection",
+		Fields: graphql.Fields{
+			"totalCount": &graphql.Field{Type: graphql.NewNonNull(graphql.Int), Description: "Albums matching the filters, across all pages."},
+			"nodes":      &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(albumType)))},
+			"pageInfo":   &graphql.Field{Type: graphql.NewNonNull(pageInfoType)},
+		},
+	})
+
+	albumInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "AlbumInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"title":  &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+			"artist": &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+			"price":  &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.Float)},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"album": &graphql.Field{
+				Type: albumType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					a, err := store.Get(p.Context, p.Args["id"].(string))
+					if errors.Is(err, errAlbumNotFound) {
+						return nil, nil
+					}
+					if err != nil {
+						return nil, err
+					}
+					return a, nil
+				},
+			},
+			"albums": &graphql.Field{
+				Type: graphql.NewNonNull(connectionType),
+				Args: graphql.FieldConfigArgument{
+					"artist":        &graphql.ArgumentConfig{Type: graphql.String},
+					"titleContains": &graphql.ArgumentConfig{Type: graphql.String, Description: "Case-insensitive substring of the title."},
+					"minPrice":      &graphql.ArgumentConfig{Type: graphql.Float},
+					"maxPrice":      &graphql.ArgumentConfig{Type: graphql.Float},
+					"first":         &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 20},
+					"after":         &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return resolveAlbums(p.Context, store, p.Args)
+				},
+			},
+		},
+	})
+
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"addAlbum": &graphql.Field{
+				Type: graphql.NewNonNull(albumType),
+				Args: graphql.FieldConfigArgument{
+					"id":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(albumInput)},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					a := albumFromInput(p.Args["input"].(map[string]any))
+					a.ID = p.Args["id"].(string)
+					return store.Add(p.Context, a)
+				},
+			},
+			"updateAlbum": &graphql.Field{
+				Type: graphql.NewNonNull(albumType),
+				Args: graphql.FieldConfigArgument{
+					"id":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(albumInput)},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					a := albumFromInput(p.Args["input"].(map[string]any))
+					a.ID = p.Args["id"].(string)
+					return store.Update(p.Context, a)
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query, Mutation: mutation})
+}
+
+func albumFromInput(in map[string]any) album {
+	return album{
+		Title:  in["title"].(string),
+		Artist: in["artist"].(string),
+		Price:  in["price"].(float64),
+	}
+}
+
+// resolveAlbums filters the catalog and returns the page after the
+// cursor. Cursors are opaque to clients but encode an offset.
+func resolveAlbums(ctx context.Context, store albumStore, args map[string]any) (albumConnection, error) {
+	var (
+		all []album
+		err error
+	)
+	minPrice, hasMin := args["minPrice"].(float64)
+	maxPrice, hasMax := args["maxPrice"].(float64)
+	switch name, ok := args["artist"].(string); {
+	case ok:
+		all, err = store.ListByArtists(ctx, []string{name})
+	case hasMin || hasMax:
+		// Let the store's price index narrow the catalog down.
+		lo, hi := -math.MaxFloat64, math.MaxFloat64
+		if hasMin {
+			lo = minPrice
+		}
+		if hasMax {
+			hi = maxPrice
+		}
+		all, err = store.ListByPrice(ctx, lo, hi)
+	default:
+		all, err = store.List(ctx)
+	}
+	if err != nil {
+		return albumConnection{}, err
+	}
+
+	title, _ := args["titleContains"].(string)
+	title = strings.ToLower(title)
+	matched := all[:0:0]
+	for _, a := range all {
+		if title != "" && !strings.Contains(strings.ToLower(a.Title), title) ||
+			hasMin && a.Price < minPrice ||
+			hasMax && a.Price > maxPrice {
+			continue
+		}
+		matched = append(matched, a)
+	}
+
+	first := args["first"].(int)
+	if first < 0 {
+		return albumConnection{}, errors.New("first must not be negative")
+	}
+	start := 0
+	if after, ok := args["after"].(string); ok {
+		if start, err = decodeCursor(after); err != nil {
+			return albumConnection{}, err
+		}
+	}
+	start = min(start, len(matched))
+	end := min(start+first, len(matched))
+	return albumConnection{
+		TotalCount: len(matched),
+		Nodes:      matched[start:end],
+		PageInfo:   pageInfo{HasNextPage: end < len(matched), EndCursor: encodeCursor(end)},
+	}, nil
+}
+
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("offset:" + strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		if n, ok := strings.CutPrefix(string(b), "offset:"); ok {
+			if offset, err := strconv.Atoi(n); err == nil && offset >= 0 {
+				return offset, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("invalid cursor %q", cursor)
+}
+
+// artistLoader batches the album lookups of Artist.albums, in the style
+// of DataLoader. Each resolver registers its artist and returns a thunk;
+// graphql-go runs the thunks only after resolving the sibling fields,
+// so the first thunk to run fetches every registered artist at once.
+// A loader lives for a single request.
+type artistLoader struct {
+	ctx   context.Context
+	store albumStore
+
+	mu      sync.Mutex
+	pending []string
+	loaded  map[string][]album
+	err     error
+}
+
+type loaderKey struct{}
+
+func newArtistLoader(ctx context.Context, store albumStore) *artistLoader {
+	return &artistLoader{ctx: ctx, store: store, loaded: make(map[string][]album)}
+}
+
+func loaderFrom(ctx context.Context) *artistLoader {
+	return ctx.Value(loaderKey{}).(*artistLoader)
+}
+
+// load queues name and returns a thunk yielding its albums.
+func (l *artistLoader) load(name string) func() (any, error) {
+	l.mu.Lock()
+	if _, ok := l.loaded[name]; !ok && !slices.Contains(l.pending, name) {
+		l.pending = append(l.pending, name)
+	}
+	l.mu.Unlock()
+
+	return func() (any, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if _, ok := l.loaded[name]; !ok {
+			l.flushLocked()
+		}
+		if l.err != nil {
+			return nil, l.err
+		}
+		return l.loaded[name], nil
+	}
+}
+
+// flushLocked fetches the albums of every pending artist with one store
+// call. The caller must hold l.mu.
+func (l *artistLoader) flushLocked() {
+	names := l.pending
+	l.pending = nil
+	albums, err := l.store.ListByArtists(l.ctx, names)
+	if err != nil {
+		l.err = err
+		return
+	}
+	for _, name := range names {
+		l.loaded[name] = []album{}
+	}
+	for _, a := range albums {
+		l.loaded[a.Artist] = append(l.loaded[a.Artist], a)
+	}
+}
+
+// queryDepth returns how deeply the selections of doc nest, following
+// fragments. Introspection fields are not counted so tools can still
+// fetch the schema.
+func queryDepth(doc *ast.Document) int {
+	fragments := make(map[string]*ast.FragmentDefinition)
+	for _, def := range doc.Definitions {
+		if f, ok := def.(*ast.FragmentDefinition); ok {
+			fragments[f.Name.Value] = f
+		}
+	}
+
+	var depth func(set *ast.SelectionSet, seen map[string]bool) int
+	depth = func(set *ast.SelectionSet, seen map[string]bool) int {
+		if set == nil {
+			return 0
+		}
+		deepest := 0
+		for _, sel := range set.Selections {
+			d := 0
+			switch sel := sel.(type) {
+			case *ast.Field:
+				if strings.HasPrefix(sel.Name.Value, "__") {
+					continue
+				}
+				d = 1 + depth(sel.SelectionSet, seen)
+			case *ast.InlineFragment:
+				d = depth(sel.SelectionSet, seen)
+			case *ast.FragmentSpread:
+				name := sel.Name.Value
+				if f, ok := fragments[name]; ok && !seen[name] {
+					seen[name] = true
+					d = depth(f.SelectionSet, seen)
+					delete(seen, name)
+				}
+			}
+			deepest = max(deepest, d)
+		}
+		return deepest
+	}
+
+	deepest := 0
+	for _, def := range doc.Definitions {
+		if op, ok := def.(*ast.OperationDefinition); ok {
+			deepest = max(deepest, depth(op.SelectionSet, map[string]bool{}))
+		}
+	}
+	return deepest
+}
+
+// respondGraphQLError writes err as a GraphQL response with no data.
+func respondGraphQLError(c *gin.Context, status int, err error) {
+	c.JSON(status, graphqlResponse{Errors: []graphqlError{{Message: err.Error()}}})
+}
+
+// postGraphQL executes a GraphQL query or mutation against the store.
+func (s *server) postGraphQL(c *gin.Context) {
+	var req graphqlRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, graphqlResponse{Errors: []graphqlError{{Message: err.Error()}}})
+		return
+	}
+
+	doc, err := parser.Parse(parser.ParseParams{Source: req.Query})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, graphqlResponse{Errors: []graphqlError{{Message: err.Error()}}})
+		return
+	}
+	if d := queryDepth(doc); d > maxQueryDepth {
+		c.JSON(http.StatusBadRequest, graphqlResponse{Errors: []graphqlError{{
+			Message: fmt.Sprintf("query depth %d exceeds the limit of %d", d, maxQueryDepth),
+		}}})
+		return
+	}
+
+	ctx := context.WithValue(c.Request.Context(), loaderKey{}, newArtistLoader(c.Request.Context(), s.store))
+	result := graphql.Do(graphql.Params{
+		Schema:         s.graphql,
+		RequestString:  req.Query,
+		VariableValues: req.Variables,
+		OperationName:  req.OperationName,
+		Context:        ctx,
+	})
+
+	resp := graphqlResponse{}
+	if data, ok := result.Data.(map[string]any); ok {
+		resp.Data = data
+	}
+	for _, e := range result.Errors {
+		resp.Errors = append(resp.Errors, graphqlError{Message: e.Message})
+	}
+	c.JSON(http.StatusOK, resp)
+}
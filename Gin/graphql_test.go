@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+// countingStore counts ListByArtists calls to check batching.
+type countingStore struct {
+	albumStore
+	byArtists atomic.Int32
+}
+
+func (s *countingStore) ListByArtists(ctx context.Context, artists []string) ([]album, error) {
+	s.byArtists.Add(1)
+	return s.albumStore.ListByArtists(ctx, artists)
+}
+
+// graphqlDo posts query with variables and decodes the response.
+func graphqlDo(t *testing.T, h *harness, query string, variables map[string]any) (int, graphqlResponse) {
+	t.Helper()
+	body, _ := json.Marshal(graphqlRequest{Query: query, Variables: variables})
+	w := h.do(http.MethodPost, "/graphql", string(body))
+	var resp graphqlResponse
+	decode(t, w, &resp)
+	return w.Code, resp
+}
+
+// field walks a decoded response along path, e.g. "albums.nodes".
+func field(v any, path string) any {
+	for _, key := range strings.Split(path, ".") {
+		v = v.(map[string]any)[key]
+	}
+	return v
+}
+
+func TestGraphQLBatchesArtistAlbums(t *testing.T) {
+	store := &countingStore{albumStore: newMemoryStore(loadFixture(t, "albums"))}
+	h := newHarnessWithStore(t, store)
+
+	_, resp := graphqlDo(t, h, `{ albums { nodes { artist { name albums { id artist { albums { id } } } } } } }`, nil)
+	if len(resp.Errors) > 0 {
+		t.Fatal(resp.Errors)
+	}
+	// Three artists resolved at two levels: one batch for the first
+	// level, and the second finds them already loaded.
+	if n := store.byArtists.Load(); n != 1 {
+		t.Errorf("ListByArtists called %d times, want 1", n)
+	}
+	nodes := field(resp.Data, "albums.nodes").([]any)
+	if len(nodes) != 3 {
+		t.Fatalf("got %d nodes", len(nodes))
+	}
+	if got := field(nodes[0], "artist.albums").([]any); len(got) != 1 || field(got[0], "id") != "1" {
+		t.Errorf("Coltrane albums = %v", got)
+	}
+}
+
+func TestGraphQLFiltersAndPagination(t *testing.T) {
+	h := newHarness(t, "albums")
+	const q = `query($after: String) {
+		albums(minPrice: 20, first: 1, after: $after) { totalCount nodes { id } pageInfo { hasNextPage endCursor } }
+	}`
+
+	var ids []any
+	var after any
+	for page := 0; page < 5; page++ {
+		_, resp := graphqlDo(t, h, q, map[string]any{"after": after})
+		if len(resp.Errors) > 0 {
+			t.Fatal(resp.Errors)
+		}
+		if total := field(resp.Data, "albums.totalCount"); total != 2.0 {
+			t.Errorf("totalCount = %v, want 2", total)
+		}
+		for _, n := range field(resp.Data, "albums.nodes").([]any) {
+			ids = append(ids, field(n, "id"))
+		}
+		if field(resp.Data, "albums.pageInfo.hasNextPage") == false {
+			break
+		}
+		after = field(resp.Data, "albums.pageInfo.endCursor")
+	}
+	if len(ids) != 2 || ids[0] != "1" || ids[1] != "3" {
+		t.Errorf("paged through %v, want [1 3]", ids)
+	}
+
+	_, resp := graphqlDo(t, h, `{ albums(artist: "Gerry Mulligan", titleContains: "JER") { nodes { title } } }`, nil)
+	if nodes := field(resp.Data, "albums.nodes").([]any); len(nodes) != 1 || field(nodes[0], "title") != "Jeru" {
+		t.Errorf("filtered nodes = %v", nodes)
+	}
+
+	_, resp = graphqlDo(t, h, `{ albums(after: "nonsense") { totalCount } }`, nil)
+	if len(resp.Errors) != 1 || !strings.Contains(resp.Errors[0].Message, "invalid cursor") {
+		t.Errorf("errors = %v, want invalid cursor", resp.Errors)
+	}
+}
+
+func TestGraphQLMutations(t *testing.T) {
+	h := newHarness(t, "albums")
+	input := map[string]any{"title": "Giant Steps", "artist": "John Coltrane", "price": 63.99}
+
+	_, resp := graphqlDo(t, h, `mutation($in: AlbumInput!) { addAlbum(id: "4", input: $in) { id title } }`,
+		map[string]any{"in": input})
+	if len(resp.Errors) > 0 || field(resp.Data, "addAlbum.title") != "Giant Steps" {
+		t.Fatalf("addAlbum = %+v", resp)
+	}
+
+	input["price"] = 9.99
+	_, resp = graphqlDo(t, h, `mutation($in: AlbumInput!) { updateAlbum(id: "4", input: $in) { price } }`,
+		map[string]any{"in": input})
+	if len(resp.Errors) > 0 || field(resp.Data, "updateAlbum.price") != 9.99 {
+		t.Fatalf("updateAlbum = %+v", resp)
+	}
+
+	var got album
+	decode(t, h.do(http.MethodGet, "/albums/4", ""), &got)
+	if got.Price != 9.99 {
+		t.Errorf("REST sees %+v after the mutations", got)
+	}
+	if backlog, sub := h.server.events.subscribe(defaultTenant, 0); len(backlog) != 2 {
+		t.Errorf("mutations published %d events, want 2", len(backlog))
+	} else {
+		h.server.events.unsubscribe(sub)
+	}
+
+	_, resp = graphqlDo(t, h, `mutation($in: AlbumInput!) { updateAlbum(id: "99", input: $in) { id } }`,
+		map[string]any{"in": input})
+	if len(resp.Errors) != 1 || resp.Errors[0].Message != errAlbumNotFound.Error() {
+		t.Errorf("updating a missing album: errors = %v", resp.Errors)
+	}
+}
+
+func TestGraphQLMissingAlbumIsNull(t *testing.T) {
+	_, resp := graphqlDo(t, newHarness(t, "albums"), `{ album(id: "99") { id } }`, nil)
+	if len(resp.Errors) > 0 || resp.Data["album"] != nil {
+		t.Errorf("response = %+v, want album null", resp)
+	}
+}
+
+func TestQueryDepth(t *testing.T) {
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{`{ album(id: "1") { id } }`, 2},
+		{`{ albums { nodes { artist { name } } } }`, 4},
+		{`{ ...A } fragment A on Query { album(id: "1") { ...B } } fragment B on Album { artist { name } }`, 3},
+		{`{ album(id: "1") { ... on Album { artist { name } } } }`, 3},
+		// Cyclic fragments are rejected by validation; depth must
+		// still terminate.
+		{`{ album(id: "1") { ...A } } fragment A on Album { artist { albums { ...A } } }`, 3},
+		{`{ __schema { types { fields { type { ofType { name } } } } } }`, 0},
+	}
+	for _, tt := range tests {
+		doc, err := parser.Parse(parser.ParseParams{Source: tt.query})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := queryDepth(doc); got != tt.want {
+			t.Errorf("queryDepth(%s) = %d, want %d", tt.query, got, tt.want)
+		}
+	}
+}
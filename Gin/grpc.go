@@ -0,0 +1,241 @@
+package main
+
+//go:generate buf generate
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Niku19/golearn/Gin/albumpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// albumService implements the gRPC AlbumService over the same store as
+// the HTTP handlers, so a write through either API is seen by both and
+// publishes the same events.
+type albumService struct {
+	albumpb.UnimplementedAlbumServiceServer
+	store albumStore
+}
+
+// grpcServer returns a gRPC server offering AlbumService. Every call is
+// logged to logger and, unless token is empty, must carry it as a
+// bearer token in the authorization metadata. Calls pick their tenant
+// with x-tenant or x-api-key metadata and writes are recorded as made
+// by x-actor, like the headers of the same names over HTTP.
+func (s *server) grpcServer(token string, logger *log.Logger) *grpc.Server {
+	unary := []grpc.UnaryServerInterceptor{logUnary(logger), actorUnary}
+	stream := []grpc.StreamServerInterceptor{logStream(logger)}
+	if token != "" {
+		unary = append(unary, authUnary(token))
+		stream = append(stream, authStream(token))
+	}
+	unary = append(unary, s.tenantUnary)
+	stream = append(stream, s.tenantStream)
+	gs := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(stream...),
+	)
+	albumpb.RegisterAlbumServiceServer(gs, &albumService{store: s.store})
+	return gs
+}
+
+func (a *albumService) GetAlbum(ctx context.Context, req *albumpb.GetAlbumRequest) (*albumpb.Album, error) {
+	alb, err := a.store.Get(ctx, req.GetId())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return toProto(alb), nil
+}
+
+func (a *albumService) ListAlbums(req *albumpb.ListAlbumsRequest, stream albumpb.AlbumService_ListAlbumsServer) error {
+	ctx := stream.Context()
+	var albums []album
+	var err error
+	if len(req.GetArtists()) > 0 {
+		albums, err = a.store.ListByArtists(ctx, req.GetArtists())
+	} else {
+		albums, err = a.store.List(ctx)
+	}
+	if err != nil {
+		return grpcError(err)
+	}
+	for _, alb := range albums {
+		if err := stream.Send(toProto(alb)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *albumService) CreateAlbum(ctx context.Context, req *albumpb.CreateAlbumRequest) (*albumpb.Album, error) {
+	if req.GetAlbum() == nil {
+		return nil, status.Error(codes.InvalidArgument, "album is required")
+	}
+	alb, err := a.store.Add(ctx, fromProto(req.GetAlbum()))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return toProto(alb), nil
+}
+
+func (a *albumService) UpdateAlbum(ctx context.Context, req *albumpb.UpdateAlbumRequest) (*albumpb.Album, error) {
+	if req.GetAlbum().GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "album.id is required")
+	}
+	alb, err := a.store.Update(ctx, fromProto(req.GetAlbum()))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return toProto(alb), nil
+}
+
+func (a *albumService) DeleteAlbum(ctx context.Context, req *albumpb.DeleteAlbumRequest) (*albumpb.Album, error) {
+	alb, err := a.store.Delete(ctx, req.GetId())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return toProto(alb), nil
+}
+
+// grpcError maps a store error to the status a gRPC client sees.
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, errAlbumNotFound):
+		return status.Error(codes.NotFound, "album not found")
+	case errors.Is(err, errAlbumExists):
+		return status.Error(codes.AlreadyExists, "album already exists")
+	case errors.Is(err, errQuotaExceeded):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case isContextError(err):
+		return status.FromContextError(err).Err()
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+func toProto(a album) *albumpb.Album {
+	return &albumpb.Album{Id: a.ID, Title: a.Title, Artist: a.Artist, Price: a.Price}
+}
+
+func fromProto(a *albumpb.Album) album {
+	return album{ID: a.GetId(), Title: a.GetTitle(), Artist: a.GetArtist(), Price: a.GetPrice()}
+}
+
+// logUnary logs the method, status code and duration of every unary
+// call, the way gin.Default logs HTTP requests.
+func logUnary(logger *log.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		logger.Printf("grpc %s %s %v", info.FullMethod, status.Code(err), time.Since(start))
+		return resp, err
+	}
+}
+
+// logStream is logUnary for streaming calls; the duration covers the
+// whole stream.
+func logStream(logger *log.Logger) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		logger.Printf("grpc %s %s %v", info.FullMethod, status.Code(err), time.Since(start))
+		return err
+	}
+}
+
+// actorUnary is identifyActor for gRPC: it takes the actor recorded in
+// album history from the x-actor metadata.
+func actorUnary(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(strings.ToLower(headerActor)); len(v) > 0 && v[0] != "" {
+		ctx = withActor(ctx, v[0])
+	}
+	return handler(ctx, req)
+}
+
+// tenantUnary is identifyTenant for gRPC.
+func (s *server) tenantUnary(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := s.grpcTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *server) tenantStream(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.grpcTenant(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+}
+
+// grpcTenant returns ctx carrying the tenant named by its metadata.
+func (s *server) grpcTenant(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(key string) string {
+		if v := md.Get(key); len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	tenant, err := s.resolveTenant(first(strings.ToLower(headerAPIKey)), first(strings.ToLower(headerTenant)))
+	switch {
+	case errors.Is(err, errInvalidAPIKey):
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, errUnknownTenant):
+		return nil, status.Error(codes.NotFound, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return withTenant(ctx, tenant), nil
+}
+
+// contextStream is a grpc.ServerStream whose handler sees ctx.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context { return s.ctx }
+
+// authUnary rejects calls that do not carry "authorization: Bearer
+// <token>" metadata with Unauthenticated.
+func authUnary(token string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := authorize(ctx, token); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func authStream(token string) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := authorize(ss.Context(), token); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func authorize(ctx context.Context, token string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		got, ok := strings.CutPrefix(v, "Bearer ")
+		// Compare in constant time so the token cannot be guessed a
+		// byte at a time from response latency.
+		if ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "missing or invalid bearer token")
+}
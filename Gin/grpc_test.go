@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Niku19/golearn/Gin/albumpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// dialGRPC serves h's AlbumService on an in-memory listener and returns
+// a client connected to it. Calls are logged to logs if it is not nil.
+func dialGRPC(t *testing.T, h *harness, token string, logs io.Writer) albumpb.AlbumServiceClient {
+	t.Helper()
+	if logs == nil {
+		logs = io.Discard
+	}
+	lis := bufconn.Listen(1 << 20)
+	gs := h.server.grpcServer(token, log.New(logs, "", 0))
+	go gs.Serve(lis)
+	t.Cleanup(gs.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return albumpb.NewAlbumServiceClient(conn)
+}
+
+// listAll drains a ListAlbums stream.
+func listAll(ctx context.Context, c albumpb.AlbumServiceClient, artists ...string) ([]album, error) {
+	stream, err := c.ListAlbums(ctx, &albumpb.ListAlbumsRequest{Artists: artists})
+	if err != nil {
+		return nil, err
+	}
+	var albums []album
+	for {
+		a, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return albums, nil
+		}
+		if err != nil {
+			return albums, err
+		}
+		albums = append(albums, fromProto(a))
+	}
+}
+
+func TestGRPCCRUD(t *testing.T) {
+	h := newHarness(t, "albums")
+	c := dialGRPC(t, h, "", nil)
+	ctx := context.Background()
+
+	got, err := c.GetAlbum(ctx, &albumpb.GetAlbumRequest{Id: "2"})
+	if err != nil || fromProto(got) != h.fixture[1] {
+		t.Fatalf("GetAlbum(2) = %v, %v", got, err)
+	}
+
+	giant := &albumpb.Album{Id: "4", Title: "Giant Steps", Artist: "John Coltrane", Price: 63.99}
+	if _, err := c.CreateAlbum(ctx, &albumpb.CreateAlbumRequest{Album: giant}); err != nil {
+		t.Fatal(err)
+	}
+	// The HTTP API shares the store, so it sees the album straight away.
+	if w := h.do(http.MethodGet, "/albums/4", ""); w.Code != http.StatusOK {
+		t.Errorf("GET /albums/4 after CreateAlbum: %d", w.Code)
+	}
+
+	giant.Price = 9.99
+	updated, err := c.UpdateAlbum(ctx, &albumpb.UpdateAlbumRequest{Album: giant})
+	if err != nil || updated.GetPrice() != 9.99 {
+		t.Errorf("UpdateAlbum = %v, %v", updated, err)
+	}
+
+	deleted, err := c.DeleteAlbum(ctx, &albumpb.DeleteAlbumRequest{Id: "4"})
+	if err != nil || deleted.GetTitle() != "Giant Steps" {
+		t.Errorf("DeleteAlbum = %v, %v", deleted, err)
+	}
+	if _, err := c.GetAlbum(ctx, &albumpb.GetAlbumRequest{Id: "4"}); status.Code(err) != codes.NotFound {
+		t.Errorf("GetAlbum after delete: %v, want NotFound", err)
+	}
+
+	// Writes through gRPC publish events like writes through HTTP.
+	if n := h.server.events.lastID(); n != 3 {
+		t.Errorf("%d events published, want 3", n)
+	}
+}
+
+func TestGRPCErrors(t *testing.T) {
+	ctx := context.Background()
+	c := dialGRPC(t, newHarness(t, "albums"), "", nil)
+	tests := []struct {
+		name string
+		err  error
+		want codes.Code
+	}{
+		{"update missing", second(c.UpdateAlbum(ctx, &albumpb.UpdateAlbumRequest{Album: &albumpb.Album{Id: "9"}})), codes.NotFound},
+		{"update without id", second(c.UpdateAlbum(ctx, &albumpb.UpdateAlbumRequest{})), codes.InvalidArgument},
+		{"create without album", second(c.CreateAlbum(ctx, &albumpb.CreateAlbumRequest{})), codes.InvalidArgument},
+		{"delete missing", second(c.DeleteAlbum(ctx, &albumpb.DeleteAlbumRequest{Id: "9"})), codes.NotFound},
+	}
+	for _, tt := range tests {
+		if got := status.Code(tt.err); got != tt.want {
+			t.Errorf("%s: %v, want %s", tt.name, tt.err, tt.want)
+		}
+	}
+
+	failing := dialGRPC(t, newHarnessWithStore(t, failingStore{}), "", nil)
+	if _, err := listAll(ctx, failing); status.Code(err) != codes.Internal {
+		t.Errorf("ListAlbums on a failing store: %v, want Internal", err)
+	}
+}
+
+func second[T any](_ T, err error) error { return err }
+
+func TestGRPCListStreams(t *testing.T) {
+	h := newHarness(t, "albums")
+	c := dialGRPC(t, h, "", nil)
+
+	all, err := listAll(context.Background(), c)
+	if err != nil || len(all) != len(h.fixture) || all[2] != h.fixture[2] {
+		t.Errorf("ListAlbums = %+v, %v", all, err)
+	}
+	some, err := listAll(context.Background(), c, "Gerry Mulligan", "Sarah Vaughan")
+	if err != nil || len(some) != 2 {
+		t.Errorf("ListAlbums(Gerry Mulligan, Sarah Vaughan) = %+v, %v", some, err)
+	}
+}
+
+func TestGRPCAuthAndLogging(t *testing.T) {
+	var logs bytes.Buffer
+	c := dialGRPC(t, newHarness(t, "albums"), "s3cret", &logs)
+	bearer := func(token string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+	}
+
+	for name, ctx := range map[string]context.Context{
+		"no token":    context.Background(),
+		"wrong token": bearer("guess"),
+	} {
+		if _, err := c.GetAlbum(ctx, &albumpb.GetAlbumRequest{Id: "1"}); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("GetAlbum with %s: %v, want Unauthenticated", name, err)
+		}
+		if _, err := listAll(ctx, c); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("ListAlbums with %s: %v, want Unauthenticated", name, err)
+		}
+	}
+
+	if _, err := c.GetAlbum(bearer("s3cret"), &albumpb.GetAlbumRequest{Id: "1"}); err != nil {
+		t.Errorf("GetAlbum with token: %v", err)
+	}
+	if _, err := listAll(bearer("s3cret"), c); err != nil {
+		t.Errorf("ListAlbums with token: %v", err)
+	}
+
+	// Rejected calls are logged too, since logging runs before auth.
+	for _, want := range []string{
+		"grpc /album.v1.AlbumService/GetAlbum Unauthenticated",
+		"grpc /album.v1.AlbumService/GetAlbum OK",
+		"grpc /album.v1.AlbumService/ListAlbums OK",
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("logs do not contain %q:\n%s", want, logs.String())
+		}
+	}
+}
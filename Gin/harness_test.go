@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// update rewrites the golden files under testdata/golden with the
+// responses the handlers produce now: go test -run Golden -update
+var update = flag.Bool("update", false, "rewrite golden files")
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	// gin.Default logs every request; keep test output readable.
+	gin.DefaultWriter = io.Discard
+	os.Exit(m.Run())
+}
+
+// harness is a router backed by its own store, so tests neither see nor
+// change the global albums seed or each other's data.
+type harness struct {
+	t      *testing.T
+	store  albumStore
+	server *server
+	router *gin.Engine
+	// fixture holds the albums the store was loaded with.
+	fixture []album
+}
+
+// newHarness returns a harness whose in-memory store holds the albums
+// in testdata/fixtures/<fixture>.json for the default tenant, and empty
+// catalogs for the tenants blue-note and verve.
+func newHarness(t *testing.T, fixture string) *harness {
+	t.Helper()
+	albums := loadFixture(t, fixture)
+	store := newTenantStore(func(tenant string) (albumStore, error) {
+		var seed []album
+		if tenant == defaultTenant {
+			seed = albums
+		}
+		s := newMemoryStore(seed)
+		// Writes are timestamped too.
+		s.now = func() time.Time { return testTime }
+		return s, nil
+	}, tenantQuotas{})
+	h := newHarnessWithStore(t, store)
+	h.fixture = albums
+	return h
+}
+
+// newHarnessWithStore returns a harness around any store, such as one
+// that fails on purpose.
+func newHarnessWithStore(t *testing.T, store albumStore) *harness {
+	s := newServer(store)
+	s.tenants = map[string]bool{"blue-note": true, "verve": true}
+	// Events carry a timestamp; pin it so responses are reproducible.
+	s.events.now = func() time.Time { return testTime }
+	return &harness{t: t, store: store, server: s, router: s.router()}
+}
+
+// testTime is the clock reading of every event published and every
+// album written in tests.
+var testTime = time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+func loadFixture(t *testing.T, name string) []album {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "fixtures", name+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var albums []album
+	if err := json.Unmarshal(data, &albums); err != nil {
+		t.Fatalf("fixture %s: %v", name, err)
+	}
+	return albums
+}
+
+// do sends a request through the router. body, if not empty, is sent as
+// JSON; headers are "Key: value" pairs.
+func (h *harness) do(method, target, body string, headers ...string) *httptest.ResponseRecorder {
+	h.t.Helper()
+	return h.doContext(context.Background(), method, target, body, headers...)
+}
+
+// stream is do for a streaming endpoint: the request is cancelled after
+// d and the response holds whatever was written until then.
+func (h *harness) stream(d time.Duration, target string, headers ...string) *httptest.ResponseRecorder {
+	h.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	return h.doContext(ctx, http.MethodGet, target, "", headers...)
+}
+
+func (h *harness) doContext(ctx context.Context, method, target, body string, headers ...string) *httptest.ResponseRecorder {
+	h.t.Helper()
+	req := httptest.NewRequestWithContext(ctx, method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for _, header := range headers {
+		k, v, ok := strings.Cut(header, ":")
+		if !ok {
+			h.t.Fatalf("malformed header %q", header)
+		}
+		req.Header.Set(k, strings.TrimSpace(v))
+	}
+	w := httptest.NewRecorder()
+	h.router.ServeHTTP(w, req)
+	return w
+}
+
+// decode unmarshals a JSON response body into v.
+func decode(t *testing.T, w *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %q: %v", w.Body, err)
+	}
+}
+
+// goldenHeaders are the response headers recorded in golden files.
+// Headers such as Date that change between runs are left out.
+var goldenHeaders = []string{"Cache-Control", "Content-Encoding", "Content-Type", "Idempotent-Replayed", "Vary"}
+
+// formatResponse renders w as the text stored in a golden file: the
+// status, the headers in goldenHeaders and the body, with JSON indented
+// so diffs stay readable.
+func formatResponse(w *httptest.ResponseRecorder) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "HTTP %d\n", w.Code)
+	keys := append([]string(nil), goldenHeaders...)
+	sort.Strings(keys)
+	for _, k := range keys {
+		if v := w.Header().Get(k); v != "" {
+			fmt.Fprintf(&b, "%s: %s\n", k, v)
+		}
+	}
+	b.WriteByte('\n')
+
+	body := w.Body.Bytes()
+	var indented bytes.Buffer
+	if strings.HasPrefix(w.Header().Get("Content-Type"), mimeJSON) && json.Indent(&indented, body, "", "  ") == nil {
+		body = indented.Bytes()
+	}
+	b.Write(body)
+	if len(body) > 0 && body[len(body)-1] != '\n' {
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
+
+// assertGolden compares got with testdata/golden/<name>.golden, or
+// rewrites the file when -update is set.
+func assertGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", "golden", name+".golden")
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("response does not match %s\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+// durationSample matches histogram samples whose values depend on how
+// fast the test ran.
+var durationSample = regexp.MustCompile(`(?m)^(\w+_duration_seconds_(?:bucket|sum)\{[^}]*\}) \S+$`)
+
+// scrubDurations replaces timing-dependent metric values with a
+// placeholder so /metrics output can be compared with a golden file.
+func scrubDurations(b []byte) []byte {
+	return durationSample.ReplaceAll(b, []byte("$1 <duration>"))
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Clients retrying a POST on a flaky connection send the same
+// Idempotency-Key with every attempt. The first attempt runs; the
+// others get its response again, marked with Idempotent-Replayed, so a
+// retry never adds the album twice.
+const (
+	headerIdempotencyKey     = "Idempotency-Key"
+	headerIdempotentReplayed = "Idempotent-Replayed"
+
+	// defaultIdempotencyTTL is how long a key is remembered after its
+	// first use; ALBUM_IDEMPOTENCY_TTL changes it.
+	defaultIdempotencyTTL = 24 * time.Hour
+	// defaultMaxIdempotencyKeys is how many keys are remembered at once.
+	// Past it the oldest is forgotten early, so a client sending a new
+	// key with every request cannot grow the cache without bound.
+	defaultMaxIdempotencyKeys = 100_000
+	maxIdempotencyKeyLen      = 255
+	// maxIdempotentBodySize caps the body of a request with a key,
+	// which is read whole to be compared with the first one's.
+	maxIdempotentBodySize = 1 << 20
+)
+
+var errIdempotencyMismatch = errors.New("Idempotency-Key was already used with a different request body")
+
+// idempotencyCache remembers the response to each idempotency key for
+// ttl, and to at most maxEntries keys at once. Keys live in memory, so several service instances behind a load
+// balancer each remember only the keys they served.
+type idempotencyCache struct {
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*idempotentRequest
+	// order holds the entries oldest first, which with a fixed ttl is
+	// also the order they expire in.
+	order []*idempotentRequest
+}
+
+// idempotentRequest is the first request sent with a key. done is
+// closed once it has finished, after which response holds what it
+// answered, or nil if it failed in a way worth retrying.
+type idempotentRequest struct {
+	key         string
+	fingerprint [sha256.Size]byte
+	expires     time.Time
+	done        chan struct{}
+	response    *recordedResponse
+}
+
+// recordedResponse is the response to the first request with a key.
+// One written with respondAs is kept as the value it was asked to send,
+// and rendered again in the format each retry accepts; anything else is
+// replayed as it was written.
+type recordedResponse struct {
+	value       *respondedValue
+	status      int
+	contentType string
+	body        []byte
+}
+
+func newIdempotencyCache() *idempotencyCache {
+	return &idempotencyCache{
+		ttl:        defaultIdempotencyTTL,
+		maxEntries: defaultMaxIdempotencyKeys,
+		now:        time.Now,
+		entries:    make(map[string]*idempotentRequest),
+	}
+}
+
+// begin returns the request first sent with key and whether it is the
+// caller's own, in which case the caller must finish it. A key sent
+// with a different body than the first time is an error.
+func (ic *idempotencyCache) begin(key string, fingerprint [sha256.Size]byte) (*idempotentRequest, bool, error) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	now := ic.now()
+	ic.expireLocked(now)
+	if r, ok := ic.entries[key]; ok {
+		if r.fingerprint != fingerprint {
+			return nil, false, errIdempotencyMismatch
+		}
+		return r, false, nil
+	}
+	if len(ic.order) >= ic.maxEntries {
+		// Retries already waiting on the oldest still get its response;
+		// only later ones run afresh.
+		ic.forgetLocked(ic.order[0])
+		ic.order = ic.order[1:]
+	}
+	r := &idempotentRequest{key: key, fingerprint: fingerprint, expires: now.Add(ic.ttl), done: make(chan struct{})}
+	ic.entries[key] = r
+	ic.order = append(ic.order, r)
+	return r, true, nil
+}
+
+// finish records the response to r and wakes the retries waiting for
+// it. A nil response forgets the key, so the next retry runs afresh.
+func (ic *idempotencyCache) finish(r *idempotentRequest, resp *recordedResponse) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	r.response = resp
+	if resp == nil {
+		ic.forgetLocked(r)
+	}
+	close(r.done)
+}
+
+func (ic *idempotencyCache) expireLocked(now time.Time) {
+	for len(ic.order) > 0 && !now.Before(ic.order[0].expires) {
+		ic.forgetLocked(ic.order[0])
+		ic.order = ic.order[1:]
+	}
+}
+
+func (ic *idempotencyCache) forgetLocked(r *idempotentRequest) {
+	// The key may since have been reused by a newer request.
+	if ic.entries[r.key] == r {
+		delete(ic.entries, r.key)
+	}
+}
+
+// idempotent is middleware making a route safe to retry with an
+// Idempotency-Key. Keys are per tenant. A retry arriving while the
+// first request is still running waits for it. Server errors are not
+// remembered, since retrying is the right response to them.
+func (s *server) idempotent(c *gin.Context) {
+	key := c.GetHeader(headerIdempotencyKey)
+	if key == "" {
+		c.Next()
+		return
+	}
+	if len(key) > maxIdempotencyKeyLen {
+		s.respond(c, http.StatusBadRequest, errorResponse{Message: "Idempotency-Key must be at most 255 characters"})
+		c.Abort()
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxIdempotentBodySize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		s.respond(c, http.StatusRequestEntityTooLarge, errorResponse{Message: "request body must be at most 1 MiB"})
+		c.Abort()
+		return
+	}
+	if err != nil {
+		s.respond(c, http.StatusBadRequest, errorResponse{Message: err.Error()})
+		c.Abort()
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	ctx := c.Request.Context()
+	key = tenantFrom(ctx) + "/" + key
+	for {
+		r, first, err := s.idempotency.begin(key, sha256.Sum256(body))
+		if err != nil {
+			s.respond(c, http.StatusUnprocessableEntity, errorResponse{Message: err.Error()})
+			c.Abort()
+			return
+		}
+		if first {
+			s.record(c, r)
+			return
+		}
+		select {
+		case <-r.done:
+		case <-ctx.Done():
+			c.Abort()
+			return
+		}
+		if resp := r.response; resp != nil {
+			c.Header(headerIdempotentReplayed, "true")
+			if v := resp.value; v != nil {
+				s.respondAs(c, v.status, v.v, v.offers)
+			} else {
+				c.Data(resp.status, resp.contentType, resp.body)
+			}
+			c.Abort()
+			return
+		}
+		// The first request failed; this one takes its place.
+	}
+}
+
+// record runs the rest of the chain for the first request with a key
+// and remembers the response it writes.
+func (s *server) record(c *gin.Context, r *idempotentRequest) {
+	w := &recordingWriter{ResponseWriter: c.Writer}
+	c.Writer = w
+	var resp *recordedResponse
+	// A panicking handler must still release the retries waiting on it.
+	defer func() { s.idempotency.finish(r, resp) }()
+	c.Next()
+	c.Writer = w.ResponseWriter
+
+	if status := w.Status(); status < http.StatusInternalServerError {
+		// Only the Content-Type is kept: headers such as
+		// Content-Encoding depend on the request being answered.
+		resp = &recordedResponse{status: status, contentType: w.Header().Get("Content-Type"), body: w.body.Bytes()}
+		if v, ok := c.Get(respondedKey); ok {
+			resp.value = v.(*respondedValue)
+		}
+	}
+}
+
+// recordingWriter keeps a copy of the body written through it.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const giantSteps = `{"id":"4","title":"Giant Steps","artist":"John Coltrane","price":63.99}`
+
+func TestIdempotentRetriesAddOnce(t *testing.T) {
+	h := newHarness(t, "albums")
+	first := h.do(http.MethodPost, "/albums", giantSteps, "Idempotency-Key: k1")
+	retry := h.do(http.MethodPost, "/albums", giantSteps, "Idempotency-Key: k1", "Accept-Encoding: gzip")
+	if first.Code != http.StatusCreated || retry.Code != http.StatusCreated {
+		t.Fatalf("statuses %d, %d; want 201 twice", first.Code, retry.Code)
+	}
+	if first.Header().Get(headerIdempotentReplayed) != "" || retry.Header().Get(headerIdempotentReplayed) != "true" {
+		t.Errorf("Idempotent-Replayed = %q, %q", first.Header().Get(headerIdempotentReplayed), retry.Header().Get(headerIdempotentReplayed))
+	}
+	// The replay is compressed for the retry's Accept-Encoding, not the
+	// first request's.
+	if retry.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("replay Content-Encoding = %q, want gzip", retry.Header().Get("Content-Encoding"))
+	}
+	r, err := gzip.NewReader(retry.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(r); string(body) != first.Body.String() {
+		t.Errorf("replayed %q, want the first response %q", body, first.Body)
+	}
+
+	// Without a key, or with another, the retry is a new request.
+	if w := h.do(http.MethodPost, "/albums", giantSteps); w.Code != http.StatusConflict {
+		t.Errorf("POST without a key: status %d, want 409", w.Code)
+	}
+	if w := h.do(http.MethodPost, "/albums", giantSteps, "Idempotency-Key: k2"); w.Code != http.StatusConflict {
+		t.Errorf("POST with a new key: status %d, want 409", w.Code)
+	}
+	// Keys are per tenant.
+	if w := h.do(http.MethodPost, "/albums", giantSteps, "Idempotency-Key: k1", "X-Tenant: blue-note"); w.Code != http.StatusCreated ||
+		w.Header().Get(headerIdempotentReplayed) != "" {
+		t.Errorf("POST as blue-note: status %d, replayed %q", w.Code, w.Header().Get(headerIdempotentReplayed))
+	}
+	if n := len(h.server.events.history); n != 2 {
+		t.Errorf("%d albums added, want 2", n)
+	}
+
+	if w := h.do(http.MethodPost, "/albums", giantSteps, "Idempotency-Key: "+strings.Repeat("k", 256)); w.Code != http.StatusBadRequest {
+		t.Errorf("POST with a 256-character key: status %d, want 400", w.Code)
+	}
+}
+
+func TestIdempotentReplayNegotiatesFormat(t *testing.T) {
+	h := newHarness(t, "albums")
+	h.do(http.MethodPost, "/albums", giantSteps, "Idempotency-Key: k1")
+	retry := h.do(http.MethodPost, "/albums", giantSteps, "Idempotency-Key: k1", "Accept: application/xml")
+	if retry.Code != http.StatusCreated || retry.Header().Get(headerIdempotentReplayed) != "true" {
+		t.Fatalf("status %d, replayed %q; want a replayed 201", retry.Code, retry.Header().Get(headerIdempotentReplayed))
+	}
+	var a album
+	if err := xml.Unmarshal(retry.Body.Bytes(), &a); err != nil || a.Title != "Giant Steps" {
+		t.Errorf("replay to an XML client = %s (%v), want the album in XML", retry.Body, err)
+	}
+	if ct := retry.Header().Get("Content-Type"); !strings.HasPrefix(ct, mimeXML) {
+		t.Errorf("Content-Type = %q, want %s", ct, mimeXML)
+	}
+	if n := len(h.server.events.history); n != 1 {
+		t.Errorf("%d albums added, want 1", n)
+	}
+}
+
+func TestIdempotencyKeysCapped(t *testing.T) {
+	h := newHarness(t, "albums")
+	h.server.idempotency.maxEntries = 2
+	for _, key := range []string{"k1", "k2", "k3"} {
+		h.do(http.MethodPost, "/albums", `{"id":"`+key+`","title":"Jeru"}`, "Idempotency-Key: "+key)
+	}
+	if n := len(h.server.idempotency.entries); n != 2 {
+		t.Errorf("%d keys remembered, want 2", n)
+	}
+	// k1 was forgotten to make room for k3, so reusing it is a new
+	// request rather than a mismatch.
+	if w := h.do(http.MethodPost, "/albums", giantSteps, "Idempotency-Key: k1"); w.Code != http.StatusCreated || w.Header().Get(headerIdempotentReplayed) != "" {
+		t.Errorf("POST with the forgotten key: status %d, replayed %q; want a fresh 201", w.Code, w.Header().Get(headerIdempotentReplayed))
+	}
+	if w := h.do(http.MethodPost, "/albums", `{"id":"k3","title":"Jeru"}`, "Idempotency-Key: k3"); w.Header().Get(headerIdempotentReplayed) != "true" {
+		t.Errorf("POST with a remembered key: status %d, not replayed", w.Code)
+	}
+}
+
+func TestIdempotentBodyTooLarge(t *testing.T) {
+	h := newHarness(t, "albums")
+	body := `{"id":"5","title":"` + strings.Repeat("x", maxIdempotentBodySize) + `"}`
+	if w := h.do(http.MethodPost, "/albums", body, "Idempotency-Key: k1"); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("POST with a key and a body over 1 MiB: status %d, want 413", w.Code)
+	}
+	if _, err := h.store.Get(t.Context(), "5"); err == nil {
+		t.Error("album 5 was added")
+	}
+}
+
+func TestIdempotencyKeyReusedWithOtherBody(t *testing.T) {
+	h := newHarness(t, "albums")
+	h.do(http.MethodPost, "/albums", giantSteps, "Idempotency-Key: k1")
+	w := h.do(http.MethodPost, "/albums", `{"id":"5","title":"Jeru"}`, "Idempotency-Key: k1")
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status %d, want 422", w.Code)
+	}
+	if _, err := h.store.Get(t.Context(), "5"); err == nil {
+		t.Error("album 5 was added")
+	}
+}
+
+func TestIdempotencyKeysExpire(t *testing.T) {
+	h := newHarness(t, "albums")
+	now := testTime
+	h.server.idempotency.now = func() time.Time { return now }
+	h.server.idempotency.ttl = time.Hour
+
+	h.do(http.MethodPost, "/albums", giantSteps, "Idempotency-Key: k1")
+	now = now.Add(59 * time.Minute)
+	if w := h.do(http.MethodPost, "/albums", giantSteps, "Idempotency-Key: k1"); w.Code != http.StatusCreated {
+		t.Errorf("retry within the hour: status %d, want the replayed 201", w.Code)
+	}
+	now = now.Add(time.Minute)
+	if w := h.do(http.MethodPost, "/albums", giantSteps, "Idempotency-Key: k1"); w.Code != http.StatusConflict {
+		t.Errorf("retry after the hour: status %d, want 409 from the store", w.Code)
+	}
+	if n := len(h.server.idempotency.entries); n != 1 {
+		t.Errorf("%d keys remembered, want the renewed one", n)
+	}
+}
+
+func TestIdempotencyForgetsServerErrors(t *testing.T) {
+	h := newHarnessWithStore(t, failingStore{})
+	for range 2 {
+		w := h.do(http.MethodPost, "/albums", giantSteps, "Idempotency-Key: k1")
+		if w.Code != http.StatusInternalServerError || w.Header().Get(headerIdempotentReplayed) != "" {
+			t.Errorf("status %d, replayed %q; want a fresh 500", w.Code, w.Header().Get(headerIdempotentReplayed))
+		}
+	}
+}
+
+func TestConcurrentIdempotentRetries(t *testing.T) {
+	h := newHarness(t, "albums")
+	var wg sync.WaitGroup
+	codes := make([]int, 8)
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = h.do(http.MethodPost, "/albums", giantSteps, "Idempotency-Key: k1").Code
+		}()
+	}
+	wg.Wait()
+	for i, code := range codes {
+		if code != http.StatusCreated {
+			t.Errorf("request %d: status %d, want 201", i, code)
+		}
+	}
+}
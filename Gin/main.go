@@ -1,18 +1,43 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"time"
 
+	"github.com/Niku19/golearn/Database/albumdb"
 	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql"
 )
 
 // album represents data about a record album.
 // without json it will represent field names in Capital, not common in json
 type album struct {
-	ID     string  `json:"id"`
-	Title  string  `json:"title"`
-	Artist string  `json:"artist"`
-	Price  float64 `json:"price"`
+	ID     string  `json:"id" xml:"id"`
+	Title  string  `json:"title" xml:"title"`
+	Artist string  `json:"artist" xml:"artist"`
+	Price  float64 `json:"price" xml:"price"`
+
+	// Set by the store, not by clients. DeletedAt is only ever set on an
+	// album in a history entry, since deleted albums are not served.
+	CreatedAt *time.Time `json:"created_at,omitempty" xml:"created_at,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty" xml:"updated_at,omitempty"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty" xml:"deleted_at,omitempty"`
+}
+
+// seedFor returns the albums a new catalog of tenant starts with: the
+// seed data for the default tenant, nothing for the others.
+func seedFor(tenant string) []album {
+	if tenant == defaultTenant {
+		return albums
+	}
+	return nil
 }
 
 // albums slice to seed record album data.
@@ -22,49 +47,288 @@ var albums = []album{
 	{ID: "3", Title: "Sarah Vaughan and Clifford Brown", Artist: "Sarah Vaughan", Price: 39.99},
 }
 
-func main() {
+// server holds what the handlers share: the album store, the metrics
+// recorded about it and the events published when it changes.
+type server struct {
+	store albumStore
+	// apiKeys maps each API key to the tenant it acts for. When it is
+	// empty, requests pick their tenant with X-Tenant instead.
+	apiKeys map[string]string
+	// tenants are the tenants X-Tenant may name besides defaultTenant.
+	tenants map[string]bool
+	cache   *cachingStore
+	// idempotency remembers responses to POST /albums by
+	// Idempotency-Key.
+	idempotency *idempotencyCache
+	metrics     *metrics
+	events      *eventBus
+	webhooks    *webhookDispatcher
+	graphql     graphql.Schema
+}
+
+// newServer returns a server reading and writing albums through store.
+func newServer(store albumStore) *server {
+	m := newMetrics()
+	events := newEventBus()
+	// Reads served from the cache never reach the store, so they are
+	// not timed as store operations; writes invalidate the cache before
+	// their event is published.
+	cache := newCachingStore(&instrumentedStore{next: store, metrics: m}, m)
+	s := &server{
+		store:       &eventingStore{albumStore: cache, events: events},
+		cache:       cache,
+		idempotency: newIdempotencyCache(),
+		metrics:     m,
+		events:      events,
+		webhooks:    newWebhookDispatcher(nil, m),
+	}
+	schema, err := newGraphQLSchema(s.store)
+	if err != nil {
+		panic(err)
+	}
+	s.graphql = schema
+	return s
+}
+
+// router registers the album routes on a new Gin engine.
+func (s *server) router() *gin.Engine {
 	router := gin.Default()
-	router.GET("/albums", getAlbums)
-	router.GET("/albums/:id", getAlbumByID)
-	router.POST("/albums", postAlbums)
+	router.Use(s.metrics.instrument(), compress(), identifyActor())
+	// Routes serving albums and their webhook deliveries work on the
+	// catalog of one tenant; the operator's routes below them see the
+	// whole service.
+	catalog := router.Group("", s.identifyTenant(s.respondError))
+	catalog.GET("/albums", s.getAlbums)
+	catalog.GET("/albums/:id", s.getAlbumByID)
+	catalog.POST("/albums", s.idempotent, s.postAlbums)
+	catalog.PUT("/albums/:id", s.putAlbum)
+	catalog.DELETE("/albums/:id", s.deleteAlbum)
+	catalog.GET("/albums/:id/history", s.getAlbumHistory)
+	catalog.POST("/albums/:id/restore", s.restoreAlbum)
+	catalog.GET("/albums/events", s.streamAlbumEvents)
+	catalog.GET("/webhooks/dead-letters", s.getDeadLetters)
+	// GraphQL clients expect errors in the GraphQL response format.
+	router.POST("/graphql", s.identifyTenant(respondGraphQLError), s.postGraphQL)
+	router.GET("/metrics", s.metrics.registry.handler())
 
-	router.Run("localhost:8080")
+	// The OpenAPI document is built from the routes registered above,
+	// so a route added without documentation stops the service from
+	// starting instead of silently going missing from the spec.
+	var spec *openAPIDocument
+	router.GET("/openapi.json", func(c *gin.Context) { c.JSON(http.StatusOK, spec) })
+	spec, err := buildOpenAPI(router.Routes(), albumAPI)
+	if err != nil {
+		panic(err)
+	}
+	return router
+}
+
+func main() {
+	// ALBUM_TENANT_QUOTAS caps the albums of each tenant, e.g.
+	// "*=1000,blue-note=5000"; tenants are unlimited by default.
+	quotas, err := parseQuotas(os.Getenv("ALBUM_TENANT_QUOTAS"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// ALBUM_STORE=mysql keeps the albums in the recordings database
+	// set up by the Database module instead of in memory, and
+	// ALBUM_STORE=file keeps them in memory but logs every change to
+	// ALBUM_DATA_DIR so they survive a restart. Whichever it is, each
+	// tenant gets a catalog of its own, and only the default tenant
+	// starts out with the seed albums.
+	var open func(tenant string) (albumStore, error)
+	var db *sql.DB
+	switch os.Getenv("ALBUM_STORE") {
+	case "", "memory":
+		open = func(tenant string) (albumStore, error) {
+			return newMemoryStore(seedFor(tenant)), nil
+		}
+	case "file":
+		dir := os.Getenv("ALBUM_DATA_DIR")
+		if dir == "" {
+			dir = "data"
+		}
+		open = func(tenant string) (albumStore, error) {
+			// The default tenant keeps the directory it had before
+			// there were tenants.
+			path := dir
+			if tenant != defaultTenant {
+				path = filepath.Join(dir, "tenants", tenant)
+			}
+			return openPersistentStore(path, seedFor(tenant))
+		}
+	case "mysql":
+		db, err = albumdb.Open(albumdb.ConfigFromEnv())
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer db.Close()
+		repo := albumdb.New(db)
+		open = func(tenant string) (albumStore, error) {
+			return newSQLStore(repo.ForTenant(tenant)), nil
+		}
+	default:
+		log.Fatalf("unknown ALBUM_STORE %q", os.Getenv("ALBUM_STORE"))
+	}
+	store := newTenantStore(open, quotas)
+	defer store.Close()
+	s := newServer(store)
+	if db != nil {
+		s.metrics.registerDBStats(db)
+	}
+
+	// ALBUM_API_KEYS lists the API keys clients present in X-API-Key,
+	// as key=tenant pairs. Once set, a request's tenant is the one its
+	// key is for, and requests without a valid key are refused.
+	if s.apiKeys, err = parseAPIKeys(os.Getenv("ALBUM_API_KEYS")); err != nil {
+		log.Fatal(err)
+	}
+	// ALBUM_TENANTS lists the tenants requests may name with X-Tenant
+	// while there are no API keys; only the default tenant exists
+	// otherwise.
+	if s.tenants, err = parseTenants(os.Getenv("ALBUM_TENANTS")); err != nil {
+		log.Fatal(err)
+	}
+
+	// ALBUM_IDEMPOTENCY_TTL is how long an Idempotency-Key is
+	// remembered, as a Go duration such as "1h".
+	if ttl := os.Getenv("ALBUM_IDEMPOTENCY_TTL"); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil || d <= 0 {
+			log.Fatalf("ALBUM_IDEMPOTENCY_TTL %q: want a positive duration", ttl)
+		}
+		s.idempotency.ttl = d
+	}
+
+	// WEBHOOK_URLS lists receivers for album events, which are signed
+	// with WEBHOOK_SECRET.
+	if urls := os.Getenv("WEBHOOK_URLS"); urls != "" {
+		s.webhooks = newWebhookDispatcher(parseWebhookEndpoints(urls, os.Getenv("WEBHOOK_SECRET")), s.metrics)
+		s.webhooks.start(context.Background(), s.events)
+	}
+
+	// The gRPC AlbumService shares the store with the HTTP API. Callers
+	// must present GRPC_TOKEN as a bearer token when it is set.
+	grpcAddr := os.Getenv("GRPC_ADDR")
+	if grpcAddr == "" {
+		grpcAddr = "localhost:9090"
+	}
+	lis, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	gs := s.grpcServer(os.Getenv("GRPC_TOKEN"), log.Default())
+	go func() {
+		if err := gs.Serve(lis); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	s.router().Run("localhost:8080")
 }
 
 // getAlbums responds with the list of all albums as JSON.
-func getAlbums(c *gin.Context) {
-	// Call Context.IndentedJSON to serialize the struct into JSON and add it to the response.
-	// Note that you can replace Context.IndentedJSON with a call to Context.JSON to send more compact JSON
-	c.IndentedJSON(http.StatusOK, albums)
+func (s *server) getAlbums(c *gin.Context) {
+	albums, err := s.store.List(c.Request.Context())
+	if err != nil {
+		s.respond(c, http.StatusInternalServerError, errorResponse{Message: err.Error()})
+		return
+	}
+	if albums == nil {
+		// A new tenant's empty catalog is an empty list, not null.
+		albums = []album{}
+	}
+	if s.cacheable(c, albums) {
+		return
+	}
+	// respondList serializes the albums in the format named by the Accept
+	// header: compact JSON unless the client asks otherwise.
+	s.respondList(c, http.StatusOK, albums)
 }
 
 // postAlbums adds an album from JSON received in the request body.
-func postAlbums(c *gin.Context) {
+func (s *server) postAlbums(c *gin.Context) {
 	var newAlbum album
 
-	// Call BindJSON to bind the received JSON to
-	// newAlbum.
-	if err := c.BindJSON(&newAlbum); err != nil {
+	// Call ShouldBindJSON to bind the received JSON to
+	// newAlbum. Unlike BindJSON it leaves writing the 400 to us, so the
+	// client gets the same error envelope as every other failure.
+	if err := c.ShouldBindJSON(&newAlbum); err != nil {
+		s.respond(c, http.StatusBadRequest, errorResponse{Message: err.Error()})
 		return
 	}
 
-	// Add the new album to the slice.
-	albums = append(albums, newAlbum)
-	c.IndentedJSON(http.StatusCreated, newAlbum)
+	// Add the new album to the store.
+	newAlbum, err := s.store.Add(c.Request.Context(), newAlbum)
+	if errors.Is(err, errAlbumExists) {
+		s.respond(c, http.StatusConflict, errorResponse{Message: "album already exists"})
+		return
+	}
+	if errors.Is(err, errQuotaExceeded) {
+		s.respond(c, http.StatusForbidden, errorResponse{Message: err.Error()})
+		return
+	}
+	if err != nil {
+		s.respond(c, http.StatusInternalServerError, errorResponse{Message: err.Error()})
+		return
+	}
+	s.respond(c, http.StatusCreated, newAlbum)
 }
 
 // getAlbumByID locates the album whose ID value matches the id
 // parameter sent by the client, then returns that album as a response.
-func getAlbumByID(c *gin.Context) {
+func (s *server) getAlbumByID(c *gin.Context) {
 	id := c.Param("id")
 
-	// Loop over the list of albums, looking for
-	// an album whose ID value matches the parameter.
-	for _, a := range albums {
-		if a.ID == id {
-			c.IndentedJSON(http.StatusOK, a)
-			return
-		}
+	a, err := s.store.Get(c.Request.Context(), id)
+	if errors.Is(err, errAlbumNotFound) {
+		s.respond(c, http.StatusNotFound, errorResponse{Message: "album not found"})
+		return
+	}
+	if err != nil {
+		s.respond(c, http.StatusInternalServerError, errorResponse{Message: err.Error()})
+		return
+	}
+	if s.cacheable(c, a) {
+		return
+	}
+	s.respond(c, http.StatusOK, a)
+}
+
+// putAlbum replaces the album whose ID matches the id parameter with the
+// album in the request body.
+func (s *server) putAlbum(c *gin.Context) {
+	var a album
+	if err := c.ShouldBindJSON(&a); err != nil {
+		s.respond(c, http.StatusBadRequest, errorResponse{Message: err.Error()})
+		return
+	}
+	// The path names the album; an ID in the body cannot move it.
+	a.ID = c.Param("id")
+
+	a, err := s.store.Update(c.Request.Context(), a)
+	if errors.Is(err, errAlbumNotFound) {
+		s.respond(c, http.StatusNotFound, errorResponse{Message: "album not found"})
+		return
+	}
+	if err != nil {
+		s.respond(c, http.StatusInternalServerError, errorResponse{Message: err.Error()})
+		return
+	}
+	s.respond(c, http.StatusOK, a)
+}
+
+// deleteAlbum removes the album whose ID matches the id parameter.
+func (s *server) deleteAlbum(c *gin.Context) {
+	_, err := s.store.Delete(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, errAlbumNotFound) {
+		s.respond(c, http.StatusNotFound, errorResponse{Message: "album not found"})
+		return
+	}
+	if err != nil {
+		s.respond(c, http.StatusInternalServerError, errorResponse{Message: err.Error()})
+		return
 	}
-	c.IndentedJSON(http.StatusNotFound, gin.H{"message": "album not found"})
+	c.Status(http.StatusNoContent)
 }
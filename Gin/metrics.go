@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// This file implements just enough of the Prometheus text exposition
+// format (version 0.0.4) to publish the service's metrics at /metrics.
+// Nothing here talks to a Prometheus server, so the output can be
+// checked in tests by scraping the handler directly.
+
+// defaultBuckets are the latency histogram bounds in seconds, the same
+// defaults the Prometheus client libraries use.
+var defaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// collector is a metric family that can write itself in text format.
+type collector interface {
+	writeTo(w io.Writer)
+}
+
+// registry holds every collector exposed by the /metrics handler.
+type registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+func (r *registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// writeTo writes all families in registration order.
+func (r *registry) writeTo(w io.Writer) {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+	for _, c := range collectors {
+		c.writeTo(w)
+	}
+}
+
+// handler serves the registry in Prometheus text format.
+func (r *registry) handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		c.Status(http.StatusOK)
+		bw := bufio.NewWriter(c.Writer)
+		r.writeTo(bw)
+		bw.Flush()
+	}
+}
+
+// family is the part shared by every labelled metric: its name, help
+// text, label names and the series seen so far keyed by label values.
+type family struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series is a single labelled time series. Counters and gauges only use
+// value; histograms use counts, sum and count.
+type series struct {
+	labelValues []string
+	value       float64
+	counts      []uint64
+	sum         float64
+	count       uint64
+}
+
+func newFamily(name, help, kind string, labels []string) family {
+	return family{name: name, help: help, kind: kind, labels: labels, series: make(map[string]*series)}
+}
+
+// get returns the series for labelValues, creating it on first use.
+// The caller must hold f.mu.
+func (f *family) get(labelValues []string) *series {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s: got %d label values, want %d", f.name, len(labelValues), len(f.labels)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		f.series[key] = s
+	}
+	return s
+}
+
+// sorted returns the series ordered by label values so the output is
+// stable between scrapes. The caller must hold f.mu.
+func (f *family) sorted() []*series {
+	out := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i].labelValues, out[j].labelValues
+		for k := range a {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return false
+	})
+	return out
+}
+
+func (f *family) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+}
+
+// counterVec is a monotonically increasing value per label set.
+type counterVec struct{ family }
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{newFamily(name, help, "counter", labels)}
+}
+
+func (v *counterVec) inc(labelValues ...string) { v.add(1, labelValues...) }
+
+func (v *counterVec) add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("counter %s cannot decrease", v.name))
+	}
+	v.mu.Lock()
+	v.get(labelValues).value += delta
+	v.mu.Unlock()
+}
+
+func (v *counterVec) writeTo(w io.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.writeHeader(w)
+	for _, s := range v.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, formatLabels(v.labels, s.labelValues), formatValue(s.value))
+	}
+}
+
+// gaugeVec is a value per label set that can go up and down.
+type gaugeVec struct{ family }
+
+func newGaugeVec(name, help string, labels ...string) *gaugeVec {
+	return &gaugeVec{newFamily(name, help, "gauge", labels)}
+}
+
+func (v *gaugeVec) inc(labelValues ...string) { v.add(1, labelValues...) }
+func (v *gaugeVec) dec(labelValues ...string) { v.add(-1, labelValues...) }
+
+func (v *gaugeVec) add(delta float64, labelValues ...string) {
+	v.mu.Lock()
+	v.get(labelValues).value += delta
+	v.mu.Unlock()
+}
+
+func (v *gaugeVec) set(value float64, labelValues ...string) {
+	v.mu.Lock()
+	v.get(labelValues).value = value
+	v.mu.Unlock()
+}
+
+func (v *gaugeVec) writeTo(w io.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.writeHeader(w)
+	for _, s := range v.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, formatLabels(v.labels, s.labelValues), formatValue(s.value))
+	}
+}
+
+// histogramVec counts observations into cumulative buckets per label set.
+type histogramVec struct {
+	family
+	buckets []float64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{family: newFamily(name, help, "histogram", labels), buckets: buckets}
+}
+
+func (v *histogramVec) observe(value float64, labelValues ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s := v.get(labelValues)
+	if s.counts == nil {
+		s.counts = make([]uint64, len(v.buckets))
+	}
+	for i, upper := range v.buckets {
+		if value <= upper {
+			s.counts[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+func (v *histogramVec) writeTo(w io.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.writeHeader(w)
+	bucketLabels := append(append([]string(nil), v.labels...), "le")
+	for _, s := range v.sorted() {
+		for i, upper := range v.buckets {
+			lv := append(append([]string(nil), s.labelValues...), formatValue(upper))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, formatLabels(bucketLabels, lv), s.counts[i])
+		}
+		lv := append(append([]string(nil), s.labelValues...), "+Inf")
+		fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, formatLabels(bucketLabels, lv), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", v.name, formatLabels(v.labels, s.labelValues), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", v.name, formatLabels(v.labels, s.labelValues), s.count)
+	}
+}
+
+// funcCollector is an unlabelled gauge or counter whose value is read
+// at scrape time.
+type funcCollector struct {
+	name, help, kind string
+	fn               func() float64
+}
+
+func (g *funcCollector) writeTo(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", g.name, escapeHelp(g.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", g.name, g.kind)
+	fmt.Fprintf(w, "%s %s\n", g.name, formatValue(g.fn()))
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabelValue(values[i]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, +1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabelValue(s string) string { return labelValueEscaper.Replace(s) }
+func escapeHelp(s string) string       { return helpEscaper.Replace(s) }
+
+// metrics is the set of metrics the album service exposes.
+type metrics struct {
+	registry *registry
+
+	requests *counterVec
+	duration *histogramVec
+	inFlight *gaugeVec
+
+	storeDuration *histogramVec
+
+	cacheRequests  *counterVec
+	cacheEvictions *counterVec
+
+	deadLettersDropped *counterVec
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: &registry{},
+		requests: newCounterVec("http_requests_total",
+			"Total number of HTTP requests by method, route and status code.",
+			"method", "route", "status"),
+		duration: newHistogramVec("http_request_duration_seconds",
+			"HTTP request latency in seconds by method and route.",
+			defaultBuckets, "method", "route"),
+		inFlight: newGaugeVec("http_requests_in_flight",
+			"Number of HTTP requests currently being served by method and route.",
+			"method", "route"),
+		storeDuration: newHistogramVec("album_store_operation_duration_seconds",
+			"Album store operation latency in seconds by operation and result.",
+			defaultBuckets, "operation", "result"),
+		cacheRequests: newCounterVec("album_cache_requests_total",
+			"Album cache lookups by operation and result: hit, miss, or shared with a concurrent miss.",
+			"operation", "result"),
+		cacheEvictions: newCounterVec("album_cache_evictions_total",
+			"Album cache entries dropped to make room (lru) or because they expired.",
+			"reason"),
+		deadLettersDropped: newCounterVec("webhook_dead_letters_dropped_total",
+			"Webhook dead letters dropped, oldest first, to keep the list within its limit, by tenant of the event.",
+			"tenant"),
+	}
+	m.registry.register(m.requests)
+	m.registry.register(m.duration)
+	m.registry.register(m.inFlight)
+	m.registry.register(m.storeDuration)
+	m.registry.register(m.cacheRequests)
+	m.registry.register(m.cacheEvictions)
+	m.registry.register(m.deadLettersDropped)
+	return m
+}
+
+// instrument is Gin middleware recording request counts, latency and
+// in-flight requests per route. The route label is the registered path
+// pattern such as /albums/:id, so IDs do not create new series.
+func (m *metrics) instrument() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request.Method
+
+		start := time.Now()
+		m.inFlight.inc(method, route)
+		defer m.inFlight.dec(method, route)
+
+		c.Next()
+
+		m.requests.inc(method, route, strconv.Itoa(c.Writer.Status()))
+		m.duration.observe(time.Since(start).Seconds(), method, route)
+	}
+}
+
+// observeStore records how long a store operation took.
+func (m *metrics) observeStore(op string, start time.Time, err error) {
+	result := "ok"
+	switch {
+	case errors.Is(err, errAlbumNotFound):
+		result = "not_found"
+	case err != nil:
+		result = "error"
+	}
+	m.storeDuration.observe(time.Since(start).Seconds(), op, result)
+}
+
+// registerDBStats exposes the connection pool statistics of db.
+func (m *metrics) registerDBStats(db *sql.DB) {
+	stat := func(name, help, kind string, fn func(sql.DBStats) float64) {
+		m.registry.register(&funcCollector{name: name, help: help, kind: kind, fn: func() float64 {
+			return fn(db.Stats())
+		}})
+	}
+	stat("album_db_max_open_connections", "Maximum number of open connections to the database.", "gauge",
+		func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) })
+	stat("album_db_open_connections", "Number of established connections, both in use and idle.", "gauge",
+		func(s sql.DBStats) float64 { return float64(s.OpenConnections) })
+	stat("album_db_in_use_connections", "Number of connections currently in use.", "gauge",
+		func(s sql.DBStats) float64 { return float64(s.InUse) })
+	stat("album_db_idle_connections", "Number of idle connections.", "gauge",
+		func(s sql.DBStats) float64 { return float64(s.Idle) })
+	stat("album_db_wait_count_total", "Total number of connections waited for.", "counter",
+		func(s sql.DBStats) float64 { return float64(s.WaitCount) })
+	stat("album_db_wait_duration_seconds_total", "Total time blocked waiting for a new connection.", "counter",
+		func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() })
+}
+
+// instrumentedStore wraps an albumStore, timing every operation.
+type instrumentedStore struct {
+	next    albumStore
+	metrics *metrics
+}
+
+func (s *instrumentedStore) List(ctx context.Context) (albums []album, err error) {
+	defer func(start time.Time) { s.metrics.observeStore("list", start, err) }(time.Now())
+	return s.next.List(ctx)
+}
+
+func (s *instrumentedStore) ListByArtists(ctx context.Context, artists []string) (albums []album, err error) {
+	defer func(start time.Time) { s.metrics.observeStore("list_by_artists", start, err) }(time.Now())
+	return s.next.ListByArtists(ctx, artists)
+}
+
+func (s *instrumentedStore) ListByPrice(ctx context.Context, min, max float64) (albums []album, err error) {
+	defer func(start time.Time) { s.metrics.observeStore("list_by_price", start, err) }(time.Now())
+	return s.next.ListByPrice(ctx, min, max)
+}
+
+func (s *instrumentedStore) Get(ctx context.Context, id string) (a album, err error) {
+	defer func(start time.Time) { s.metrics.observeStore("get", start, err) }(time.Now())
+	return s.next.Get(ctx, id)
+}
+
+func (s *instrumentedStore) Add(ctx context.Context, in album) (a album, err error) {
+	defer func(start time.Time) { s.metrics.observeStore("add", start, err) }(time.Now())
+	return s.next.Add(ctx, in)
+}
+
+func (s *instrumentedStore) Update(ctx context.Context, in album) (a album, err error) {
+	defer func(start time.Time) { s.metrics.observeStore("update", start, err) }(time.Now())
+	return s.next.Update(ctx, in)
+}
+
+func (s *instrumentedStore) Delete(ctx context.Context, id string) (a album, err error) {
+	defer func(start time.Time) { s.metrics.observeStore("delete", start, err) }(time.Now())
+	return s.next.Delete(ctx, id)
+}
+
+func (s *instrumentedStore) Restore(ctx context.Context, id string) (a album, err error) {
+	defer func(start time.Time) { s.metrics.observeStore("restore", start, err) }(time.Now())
+	return s.next.Restore(ctx, id)
+}
+
+func (s *instrumentedStore) History(ctx context.Context, id string) (changes []albumChange, err error) {
+	defer func(start time.Time) { s.metrics.observeStore("history", start, err) }(time.Now())
+	return s.next.History(ctx, id)
+}
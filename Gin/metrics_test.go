@@ -0,0 +1,96 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Niku19/golearn/Database/albumdb"
+)
+
+func TestHistogramExposition(t *testing.T) {
+	h := newHistogramVec("op_seconds", "Operation latency.", []float64{0.1, 1}, "op")
+	h.observe(0.05, "get")
+	h.observe(0.5, "get")
+	h.observe(2, "get")
+
+	var b strings.Builder
+	h.writeTo(&b)
+	want := `# HELP op_seconds Operation latency.
+# TYPE op_seconds histogram
+op_seconds_bucket{op="get",le="0.1"} 1
+op_seconds_bucket{op="get",le="1"} 2
+op_seconds_bucket{op="get",le="+Inf"} 3
+op_seconds_sum{op="get"} 2.55
+op_seconds_count{op="get"} 3
+`
+	if got := b.String(); got != want {
+		t.Errorf("exposition mismatch\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestLabelValueEscaping(t *testing.T) {
+	c := newCounterVec("things_total", "Things.", "name")
+	c.inc("a\"b\\c\nd")
+
+	var b strings.Builder
+	c.writeTo(&b)
+	if want := `things_total{name="a\"b\\c\nd"} 1`; !strings.Contains(b.String(), want) {
+		t.Errorf("output %q does not contain %q", b.String(), want)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	h := newHarness(t, "albums")
+	for _, path := range []string{"/albums", "/albums/2", "/albums/404"} {
+		h.do(http.MethodGet, path, "")
+	}
+
+	w := h.do(http.MethodGet, "/metrics", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /metrics: status %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	body := w.Body.String()
+	for _, want := range []string{
+		`http_requests_total{method="GET",route="/albums",status="200"} 1`,
+		`http_requests_total{method="GET",route="/albums/:id",status="200"} 1`,
+		`http_requests_total{method="GET",route="/albums/:id",status="404"} 1`,
+		`http_request_duration_seconds_count{method="GET",route="/albums/:id"} 2`,
+		`http_requests_in_flight{method="GET",route="/metrics"} 1`,
+		`album_store_operation_duration_seconds_count{operation="get",result="not_found"} 1`,
+		`album_store_operation_duration_seconds_count{operation="list",result="ok"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics output missing %q", want)
+		}
+	}
+}
+
+func TestDBStatsGauges(t *testing.T) {
+	// sql.Open does not connect, so no MySQL server is needed here.
+	db, err := sql.Open("mysql", albumdb.ConfigFromEnv().FormatDSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(7)
+
+	m := newMetrics()
+	m.registerDBStats(db)
+	var b strings.Builder
+	m.registry.writeTo(&b)
+	for _, want := range []string{
+		"# TYPE album_db_open_connections gauge\nalbum_db_open_connections 0\n",
+		"album_db_max_open_connections 7\n",
+		"# TYPE album_db_wait_count_total counter\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("metrics output missing %q", want)
+		}
+	}
+}
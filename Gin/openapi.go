@@ -0,0 +1,503 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errorResponse is the JSON envelope every handler uses to report an
+// error to the client.
+type errorResponse struct {
+	XMLName xml.Name `json:"-" xml:"error"`
+	Message string   `json:"message" xml:"message"`
+}
+
+// apiDoc documents one route for the OpenAPI document. The handlers
+// stay plain gin.HandlerFuncs; buildOpenAPI joins these docs with the
+// routes actually registered on the engine and fails if either side has
+// an entry the other lacks.
+type apiDoc struct {
+	Method       string
+	Path         string // Gin path syntax, e.g. /albums/:id
+	Summary      string
+	PathParams   []apiParam
+	QueryParams  []apiParam
+	HeaderParams []apiParam
+	// RequestBody is a value of the Go type the handler binds, or nil.
+	RequestBody any
+	Responses   []apiResponse
+}
+
+// apiParam describes a path, query or header parameter.
+type apiParam struct {
+	Name        string
+	Description string
+	Type        any // a value of the parameter's Go type
+	Required    bool
+}
+
+// apiResponse describes one status code a route can answer with. A
+// status listed twice is documented once, with both descriptions.
+type apiResponse struct {
+	Status      int
+	Description string
+	// Body is a value of the Go type written as the response, or nil
+	// for an empty body.
+	Body any
+	// ContentTypes defaults to bodyFormats.
+	ContentTypes []string
+}
+
+// prettyParam is accepted by every route answering through respond.
+var prettyParam = apiParam{Name: "pretty", Description: "Indent JSON responses.", Type: false}
+
+// ifNoneMatchParam is accepted by every route answering through
+// cacheable.
+var ifNoneMatchParam = apiParam{Name: "If-None-Match", Description: "ETag of the copy the client has; while it is current the response is 304 with no body.", Type: ""}
+
+// tenantParams are accepted by every route serving a tenant's catalog.
+var tenantParams = []apiParam{
+	{Name: headerTenant, Description: "Tenant whose catalog to use, one of those configured. Ignored once API keys are configured; defaults to " + defaultTenant + ".", Type: ""},
+	{Name: headerAPIKey, Description: "API key, required once API keys are configured. It decides the tenant.", Type: ""},
+}
+
+// tenantErrors are the responses identifyTenant rejects a request with,
+// body being the error envelope of the route.
+func tenantErrors(body any, contentTypes ...string) []apiResponse {
+	return []apiResponse{
+		{Status: http.StatusBadRequest, Description: "X-Tenant is not a valid tenant name.", Body: body, ContentTypes: contentTypes},
+		{Status: http.StatusUnauthorized, Description: "X-API-Key is missing or not a configured key.", Body: body, ContentTypes: contentTypes},
+		{Status: http.StatusNotFound, Description: "X-Tenant names no configured tenant.", Body: body, ContentTypes: contentTypes},
+	}
+}
+
+// albumAPI documents every route registered by server.router.
+var albumAPI = []apiDoc{
+	{
+		Method:       http.MethodGet,
+		Path:         "/albums",
+		Summary:      "List all albums",
+		QueryParams:  []apiParam{prettyParam},
+		HeaderParams: append([]apiParam{ifNoneMatchParam}, tenantParams...),
+		Responses: append([]apiResponse{
+			{Status: http.StatusOK, Description: "The albums in the catalog.", Body: []album{}, ContentTypes: listFormats},
+			{Status: http.StatusNotModified, Description: "The client's copy, named by If-None-Match, is current."},
+			{Status: http.StatusNotAcceptable, Description: "No accepted media type can be produced.", Body: errorResponse{}},
+			{Status: http.StatusInternalServerError, Description: "The store failed.", Body: errorResponse{}},
+		}, tenantErrors(errorResponse{})...),
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/albums",
+		Summary:     "Add an album",
+		QueryParams: []apiParam{prettyParam},
+		RequestBody: album{},
+		HeaderParams: append([]apiParam{
+			{Name: headerIdempotencyKey, Description: "Makes the request safe to retry: a later request with the same key and body " +
+				"gets this one's response again, marked Idempotent-Replayed, instead of adding the album twice. " +
+				"Keys are per tenant, at most 255 characters, and expire after a day unless configured otherwise.", Type: ""},
+		}, tenantParams...),
+		Responses: append([]apiResponse{
+			{Status: http.StatusCreated, Description: "The album as stored.", Body: album{}},
+			{Status: http.StatusBadRequest, Description: "The body is not a valid album.", Body: errorResponse{}},
+			{Status: http.StatusBadRequest, Description: "Idempotency-Key is too long.", Body: errorResponse{}},
+			{Status: http.StatusForbidden, Description: "The tenant has as many albums as its quota allows.", Body: errorResponse{}},
+			{Status: http.StatusConflict, Description: "An album with this ID already exists.", Body: errorResponse{}},
+			{Status: http.StatusRequestEntityTooLarge, Description: "The body of a request with an Idempotency-Key is over 1 MiB.", Body: errorResponse{}},
+			{Status: http.StatusUnprocessableEntity, Description: "Idempotency-Key was already used with a different body.", Body: errorResponse{}},
+			{Status: http.StatusInternalServerError, Description: "The store failed.", Body: errorResponse{}},
+		}, tenantErrors(errorResponse{})...),
+	},
+	{
+		Method:       http.MethodGet,
+		Path:         "/albums/:id",
+		Summary:      "Get an album by ID",
+		PathParams:   []apiParam{{Name: "id", Description: "Album ID.", Type: "", Required: true}},
+		QueryParams:  []apiParam{prettyParam},
+		HeaderParams: append([]apiParam{ifNoneMatchParam}, tenantParams...),
+		Responses: append([]apiResponse{
+			{Status: http.StatusOK, Description: "The album.", Body: album{}},
+			{Status: http.StatusNotModified, Description: "The client's copy, named by If-None-Match, is current."},
+			{Status: http.StatusNotAcceptable, Description: "No accepted media type can be produced.", Body: errorResponse{}},
+			{Status: http.StatusNotFound, Description: "No album has this ID.", Body: errorResponse{}},
+			{Status: http.StatusInternalServerError, Description: "The store failed.", Body: errorResponse{}},
+		}, tenantErrors(errorResponse{})...),
+	},
+	{
+		Method:       http.MethodPut,
+		Path:         "/albums/:id",
+		Summary:      "Replace an album",
+		PathParams:   []apiParam{{Name: "id", Description: "Album ID.", Type: "", Required: true}},
+		QueryParams:  []apiParam{prettyParam},
+		RequestBody:  album{},
+		HeaderParams: tenantParams,
+		Responses: append([]apiResponse{
+			{Status: http.StatusOK, Description: "The album as stored.", Body: album{}},
+			{Status: http.StatusBadRequest, Description: "The body is not a valid album.", Body: errorResponse{}},
+			{Status: http.StatusNotFound, Description: "No album has this ID.", Body: errorResponse{}},
+			{Status: http.StatusInternalServerError, Description: "The store failed.", Body: errorResponse{}},
+		}, tenantErrors(errorResponse{})...),
+	},
+	{
+		Method:       http.MethodDelete,
+		Path:         "/albums/:id",
+		Summary:      "Delete an album",
+		PathParams:   []apiParam{{Name: "id", Description: "Album ID.", Type: "", Required: true}},
+		HeaderParams: tenantParams,
+		Responses: append([]apiResponse{
+			{Status: http.StatusNoContent, Description: "The album was deleted."},
+			{Status: http.StatusNotFound, Description: "No album has this ID.", Body: errorResponse{}},
+			{Status: http.StatusInternalServerError, Description: "The store failed.", Body: errorResponse{}},
+		}, tenantErrors(errorResponse{})...),
+	},
+	{
+		Method:       http.MethodGet,
+		Path:         "/albums/:id/history",
+		Summary:      "List the changes made to an album",
+		PathParams:   []apiParam{{Name: "id", Description: "Album ID.", Type: "", Required: true}},
+		QueryParams:  []apiParam{prettyParam},
+		HeaderParams: tenantParams,
+		Responses: append([]apiResponse{
+			{Status: http.StatusOK, Description: "Who changed the album, when and how, oldest first. " +
+				"Deleted albums keep their history.", Body: []albumChange{}},
+			{Status: http.StatusNotFound, Description: "No album, deleted or not, has this ID.", Body: errorResponse{}},
+			{Status: http.StatusInternalServerError, Description: "The store failed.", Body: errorResponse{}},
+		}, tenantErrors(errorResponse{})...),
+	},
+	{
+		Method:       http.MethodPost,
+		Path:         "/albums/:id/restore",
+		Summary:      "Restore a deleted album",
+		PathParams:   []apiParam{{Name: "id", Description: "Album ID.", Type: "", Required: true}},
+		QueryParams:  []apiParam{prettyParam},
+		HeaderParams: tenantParams,
+		Responses: append([]apiResponse{
+			{Status: http.StatusOK, Description: "The album as restored.", Body: album{}},
+			{Status: http.StatusForbidden, Description: "The tenant has as many albums as its quota allows.", Body: errorResponse{}},
+			{Status: http.StatusNotFound, Description: "No deleted album has this ID.", Body: errorResponse{}},
+			{Status: http.StatusConflict, Description: "The album is not deleted.", Body: errorResponse{}},
+			{Status: http.StatusInternalServerError, Description: "The store failed.", Body: errorResponse{}},
+		}, tenantErrors(errorResponse{})...),
+	},
+	{
+		Method:       http.MethodGet,
+		Path:         "/albums/events",
+		Summary:      "Stream album changes as Server-Sent Events",
+		HeaderParams: tenantParams,
+		Responses: append([]apiResponse{
+			{Status: http.StatusOK, Description: "An event stream of album.created, album.updated, album.deleted and album.restored events. " +
+				"Send Last-Event-ID to resume after a given event.", Body: "", ContentTypes: []string{"text/event-stream"}},
+			{Status: http.StatusBadRequest, Description: "Last-Event-ID is not an event ID.", Body: errorResponse{}},
+		}, tenantErrors(errorResponse{})...),
+	},
+	{
+		Method:       http.MethodGet,
+		Path:         "/webhooks/dead-letters",
+		Summary:      "List webhook deliveries of the tenant's events that failed",
+		HeaderParams: tenantParams,
+		Responses: append([]apiResponse{
+			{Status: http.StatusOK, Description: "Undeliverable events, oldest first.", Body: []deadLetter{}},
+		}, tenantErrors(errorResponse{})...),
+	},
+	{
+		Method:       http.MethodPost,
+		Path:         "/graphql",
+		Summary:      "Run a GraphQL query or mutation over the albums",
+		RequestBody:  graphqlRequest{},
+		HeaderParams: tenantParams,
+		Responses: append([]apiResponse{
+			{Status: http.StatusOK, Description: "The result; field errors are reported in errors.", Body: graphqlResponse{}, ContentTypes: []string{mimeJSON}},
+			{Status: http.StatusBadRequest, Description: "The request is malformed, does not parse or nests too deeply.", Body: graphqlResponse{}, ContentTypes: []string{mimeJSON}},
+		}, tenantErrors(graphqlResponse{}, mimeJSON)...),
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/metrics",
+		Summary: "Prometheus metrics",
+		Responses: []apiResponse{
+			{Status: http.StatusOK, Description: "Metrics in Prometheus text format.", Body: "", ContentTypes: []string{"text/plain"}},
+		},
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/openapi.json",
+		Summary: "This OpenAPI document",
+		Responses: []apiResponse{
+			{Status: http.StatusOK, Description: "The OpenAPI 3 document.", Body: map[string]any{}, ContentTypes: []string{mimeJSON}},
+		},
+	},
+}
+
+// The types below are the subset of the OpenAPI 3.0 object model the
+// album service needs.
+
+type openAPIDocument struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       openAPIInfo         `json:"info"`
+	Paths      map[string]pathItem `json:"paths"`
+	Components openAPIComponents   `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPIComponents struct {
+	Schemas map[string]*schema `json:"schemas"`
+}
+
+// pathItem maps a lower-case HTTP method to its operation.
+type pathItem map[string]*operation
+
+type operation struct {
+	OperationID string              `json:"operationId"`
+	Summary     string              `json:"summary,omitempty"`
+	Parameters  []parameter         `json:"parameters,omitempty"`
+	RequestBody *requestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]response `json:"responses"`
+}
+
+type parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *schema `json:"schema"`
+}
+
+type requestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]mediaType `json:"content"`
+}
+
+type response struct {
+	Description string               `json:"description"`
+	Content     map[string]mediaType `json:"content,omitempty"`
+}
+
+type mediaType struct {
+	Schema *schema `json:"schema"`
+}
+
+type schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Items                *schema            `json:"items,omitempty"`
+	Properties           map[string]*schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties any                `json:"additionalProperties,omitempty"`
+}
+
+// ginParam matches a Gin path parameter such as :id.
+var ginParam = regexp.MustCompile(`:([A-Za-z0-9_]+)`)
+
+// buildOpenAPI returns the OpenAPI document for routes, taking the
+// details of each route from docs. Every registered route must be
+// documented and every documented route registered.
+func buildOpenAPI(routes gin.RoutesInfo, docs []apiDoc) (*openAPIDocument, error) {
+	byRoute := make(map[string]apiDoc, len(docs))
+	for _, d := range docs {
+		byRoute[d.Method+" "+d.Path] = d
+	}
+
+	g := schemaGenerator{schemas: make(map[string]*schema)}
+	doc := &openAPIDocument{
+		OpenAPI:    "3.0.3",
+		Info:       openAPIInfo{Title: "Album service", Version: "1.0.0"},
+		Paths:      make(map[string]pathItem),
+		Components: openAPIComponents{Schemas: g.schemas},
+	}
+
+	var undocumented []string
+	for _, r := range routes {
+		key := r.Method + " " + r.Path
+		d, ok := byRoute[key]
+		if !ok {
+			undocumented = append(undocumented, key)
+			continue
+		}
+		delete(byRoute, key)
+
+		path := ginParam.ReplaceAllString(r.Path, "{$1}")
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(pathItem)
+		}
+		doc.Paths[path][strings.ToLower(r.Method)] = g.operation(d)
+	}
+	if len(undocumented) > 0 {
+		sort.Strings(undocumented)
+		return nil, fmt.Errorf("openapi: routes without documentation: %s", strings.Join(undocumented, ", "))
+	}
+	if len(byRoute) > 0 {
+		var unregistered []string
+		for key := range byRoute {
+			unregistered = append(unregistered, key)
+		}
+		sort.Strings(unregistered)
+		return nil, fmt.Errorf("openapi: documented routes not registered: %s", strings.Join(unregistered, ", "))
+	}
+	return doc, nil
+}
+
+// schemaGenerator derives JSON schemas from Go types, collecting named
+// struct types into the components section.
+type schemaGenerator struct {
+	schemas map[string]*schema
+}
+
+func (g schemaGenerator) operation(d apiDoc) *operation {
+	op := &operation{
+		OperationID: operationID(d.Method, d.Path),
+		Summary:     d.Summary,
+		Responses:   make(map[string]response),
+	}
+	for _, p := range d.PathParams {
+		op.Parameters = append(op.Parameters, parameter{
+			Name: p.Name, In: "path", Description: p.Description, Required: true,
+			Schema: g.schemaFor(reflect.TypeOf(p.Type)),
+		})
+	}
+	for _, p := range d.QueryParams {
+		op.Parameters = append(op.Parameters, parameter{
+			Name: p.Name, In: "query", Description: p.Description, Required: p.Required,
+			Schema: g.schemaFor(reflect.TypeOf(p.Type)),
+		})
+	}
+	for _, p := range d.HeaderParams {
+		op.Parameters = append(op.Parameters, parameter{
+			Name: p.Name, In: "header", Description: p.Description, Required: p.Required,
+			Schema: g.schemaFor(reflect.TypeOf(p.Type)),
+		})
+	}
+	if d.RequestBody != nil {
+		op.RequestBody = &requestBody{
+			Required: true,
+			Content: map[string]mediaType{
+				"application/json": {Schema: g.schemaFor(reflect.TypeOf(d.RequestBody))},
+			},
+		}
+	}
+	for _, r := range d.Responses {
+		resp := response{Description: r.Description}
+		if r.Body != nil {
+			contentTypes := r.ContentTypes
+			if contentTypes == nil {
+				contentTypes = bodyFormats
+			}
+			body := g.schemaFor(reflect.TypeOf(r.Body))
+			resp.Content = make(map[string]mediaType, len(contentTypes))
+			for _, ct := range contentTypes {
+				if ct == mimeCSV {
+					// CSV rows are not described by the JSON schema.
+					resp.Content[ct] = mediaType{Schema: &schema{Type: "string"}}
+					continue
+				}
+				resp.Content[ct] = mediaType{Schema: body}
+			}
+		}
+		if prev, ok := op.Responses[fmt.Sprint(r.Status)]; ok {
+			prev.Description += " " + r.Description
+			resp = prev
+		}
+		op.Responses[fmt.Sprint(r.Status)] = resp
+	}
+	return op
+}
+
+// schemaFor returns the schema of t. Named structs are added to the
+// components and referenced.
+func (g schemaGenerator) schemaFor(t reflect.Type) *schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return &schema{Type: "string", Format: "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return &schema{Type: "string"}
+	case reflect.Bool:
+		return &schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &schema{Type: "number", Format: "double"}
+	case reflect.Slice, reflect.Array:
+		return &schema{Type: "array", Items: g.schemaFor(t.Elem())}
+	case reflect.Map:
+		if t.Elem().Kind() == reflect.Interface {
+			return &schema{Type: "object", AdditionalProperties: true}
+		}
+		return &schema{Type: "object", AdditionalProperties: g.schemaFor(t.Elem())}
+	case reflect.Struct:
+		name := schemaName(t)
+		if _, ok := g.schemas[name]; !ok {
+			// Reserve the name first so recursive types terminate.
+			g.schemas[name] = &schema{}
+			*g.schemas[name] = *g.structSchema(t)
+		}
+		return &schema{Ref: "#/components/schemas/" + name}
+	}
+	return &schema{}
+}
+
+// structSchema describes the JSON encoding of struct type t. Fields
+// without omitempty are required.
+func (g schemaGenerator) structSchema(t reflect.Type) *schema {
+	s := &schema{Type: "object", Properties: make(map[string]*schema), AdditionalProperties: false}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s.Properties[name] = g.schemaFor(f.Type)
+		if !strings.Contains(opts, "omitempty") {
+			s.Required = append(s.Required, name)
+		}
+	}
+	return s
+}
+
+// schemaName turns a Go type name such as errorResponse into the
+// component name ErrorResponse.
+func schemaName(t reflect.Type) string {
+	name := t.Name()
+	if name == "" {
+		return "Object"
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// operationID derives an identifier such as getAlbumsId from the method
+// and path.
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool {
+		return r == '/' || r == ':' || r == '.' || r == '{' || r == '}'
+	}) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// failingStore is an albumStore whose every operation fails, used to
+// exercise the 500 responses.
+type failingStore struct{}
+
+var errStoreDown = errors.New("store unavailable")
+
+func (failingStore) List(ctx context.Context) ([]album, error) { return nil, errStoreDown }
+func (failingStore) ListByArtists(ctx context.Context, artists []string) ([]album, error) {
+	return nil, errStoreDown
+}
+func (failingStore) ListByPrice(ctx context.Context, min, max float64) ([]album, error) {
+	return nil, errStoreDown
+}
+func (failingStore) Get(ctx context.Context, id string) (album, error)  { return album{}, errStoreDown }
+func (failingStore) Add(ctx context.Context, a album) (album, error)    { return album{}, errStoreDown }
+func (failingStore) Update(ctx context.Context, a album) (album, error) { return album{}, errStoreDown }
+func (failingStore) Delete(ctx context.Context, id string) (album, error) {
+	return album{}, errStoreDown
+}
+func (failingStore) Restore(ctx context.Context, id string) (album, error) {
+	return album{}, errStoreDown
+}
+func (failingStore) History(ctx context.Context, id string) ([]albumChange, error) {
+	return nil, errStoreDown
+}
+
+func TestOpenAPIServed(t *testing.T) {
+	w := newHarness(t, "albums").do(http.MethodGet, "/openapi.json", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /openapi.json: status %d", w.Code)
+	}
+
+	var doc map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["openapi"] != "3.0.3" {
+		t.Errorf("openapi = %v", doc["openapi"])
+	}
+	paths := doc["paths"].(map[string]any)
+	for _, p := range []string{"/albums", "/albums/{id}", "/metrics", "/openapi.json"} {
+		if _, ok := paths[p]; !ok {
+			t.Errorf("paths missing %s", p)
+		}
+	}
+}
+
+func TestOpenAPIRejectsUndocumentedRoutes(t *testing.T) {
+	router := gin.New()
+	router.GET("/albums", func(*gin.Context) {})
+	router.DELETE("/albums/:id", func(*gin.Context) {})
+
+	_, err := buildOpenAPI(router.Routes(), albumAPI[:1])
+	if err == nil || !strings.Contains(err.Error(), "DELETE /albums/:id") {
+		t.Errorf("err = %v, want undocumented DELETE /albums/:id", err)
+	}
+	_, err = buildOpenAPI(router.Routes()[:1], albumAPI[:2])
+	if err == nil || !strings.Contains(err.Error(), "POST /albums") {
+		t.Errorf("err = %v, want unregistered POST /albums", err)
+	}
+}
+
+// TestHandlersMatchOpenAPI drives every documented response of every
+// route and checks the status, content type and body against the spec,
+// so a handler change that is not reflected in albumAPI fails here.
+func TestHandlersMatchOpenAPI(t *testing.T) {
+	healthy := newHarness(t, "albums").router
+	broken := newHarnessWithStore(t, failingStore{}).router
+
+	doc, err := buildOpenAPI(healthy.Routes(), albumAPI)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// quota allows three albums, as many as the fixture holds.
+	quota := newHarness(t, "albums")
+	quota.store.(*tenantStore).quotas = tenantQuotas{fallback: 3}
+
+	type driftCase struct {
+		router *gin.Engine
+		route  string // METHOD and OpenAPI path of the operation
+		method string
+		target string
+		body   string
+		header string // optional "Key: value" request header
+	}
+	tests := []driftCase{
+		{healthy, "GET /albums", "GET", "/albums", "", ""},
+		{broken, "GET /albums", "GET", "/albums", "", ""},
+		{healthy, "GET /albums", "GET", "/albums", "", "Accept: application/xml"},
+		{healthy, "GET /albums", "GET", "/albums", "", "Accept: text/csv"},
+		{healthy, "GET /albums", "GET", "/albums", "", "Accept: image/png"},
+		{healthy, "GET /albums", "GET", "/albums", "", "If-None-Match: *"},
+		{healthy, "POST /albums", "POST", "/albums", `{"id":"4","title":"Giant Steps","artist":"John Coltrane","price":63.99}`, ""},
+		{healthy, "POST /albums", "POST", "/albums", `{"id":`, ""},
+		{healthy, "POST /albums", "POST", "/albums", `{"id":"1","title":"Blue Train","artist":"John Coltrane","price":56.99}`, ""},
+		{broken, "POST /albums", "POST", "/albums", `{"id":"4","title":"Giant Steps","artist":"John Coltrane","price":63.99}`, ""},
+		{healthy, "POST /albums", "POST", "/albums", `{"id":"6","title":"Jeru","artist":"Gerry Mulligan","price":17.99}`, "Idempotency-Key: retry-1"},
+		{healthy, "POST /albums", "POST", "/albums", `{"id":"6","title":"Jeru","artist":"Gerry Mulligan","price":17.99}`, "Idempotency-Key: retry-1"},
+		{healthy, "POST /albums", "POST", "/albums", `{"id":"7","title":"Jeru","artist":"Gerry Mulligan","price":17.99}`, "Idempotency-Key: retry-1"},
+		{healthy, "POST /albums", "POST", "/albums", `{"title":"` + strings.Repeat("x", maxIdempotentBodySize) + `"}`, "Idempotency-Key: retry-2"},
+		{healthy, "GET /albums/{id}", "GET", "/albums/1", "", ""},
+		{healthy, "GET /albums/{id}", "GET", "/albums/nope", "", ""},
+		{healthy, "GET /albums/{id}", "GET", "/albums/1", "", "Accept: text/csv"},
+		{healthy, "GET /albums/{id}", "GET", "/albums/1", "", "If-None-Match: *"},
+		{broken, "GET /albums/{id}", "GET", "/albums/1", "", ""},
+		{healthy, "PUT /albums/{id}", "PUT", "/albums/2", `{"title":"Jeru","artist":"Gerry Mulligan","price":19.99}`, ""},
+		{healthy, "PUT /albums/{id}", "PUT", "/albums/2", `{"title":`, ""},
+		{healthy, "PUT /albums/{id}", "PUT", "/albums/99", `{"title":"Jeru","artist":"Gerry Mulligan","price":19.99}`, ""},
+		{broken, "PUT /albums/{id}", "PUT", "/albums/2", `{"title":"Jeru","artist":"Gerry Mulligan","price":19.99}`, ""},
+		{healthy, "DELETE /albums/{id}", "DELETE", "/albums/3", "", ""},
+		{healthy, "DELETE /albums/{id}", "DELETE", "/albums/3", "", ""},
+		{broken, "DELETE /albums/{id}", "DELETE", "/albums/3", "", ""},
+		{healthy, "GET /albums/{id}/history", "GET", "/albums/3/history", "", "X-Actor: alice"},
+		{healthy, "GET /albums/{id}/history", "GET", "/albums/99/history", "", ""},
+		{broken, "GET /albums/{id}/history", "GET", "/albums/3/history", "", ""},
+		{healthy, "POST /albums/{id}/restore", "POST", "/albums/3/restore", "", "X-Actor: alice"},
+		{healthy, "POST /albums/{id}/restore", "POST", "/albums/3/restore", "", ""},
+		{healthy, "POST /albums/{id}/restore", "POST", "/albums/99/restore", "", ""},
+		{broken, "POST /albums/{id}/restore", "POST", "/albums/3/restore", "", ""},
+		{quota.router, "DELETE /albums/{id}", "DELETE", "/albums/3", "", ""},
+		{quota.router, "POST /albums", "POST", "/albums", `{"id":"4","title":"Giant Steps","artist":"John Coltrane","price":63.99}`, ""},
+		{quota.router, "POST /albums", "POST", "/albums", `{"id":"5","title":"Jeru","artist":"Gerry Mulligan","price":19.99}`, ""},
+		{quota.router, "POST /albums/{id}/restore", "POST", "/albums/3/restore", "", ""},
+		{healthy, "GET /albums/events", "GET", "/albums/events", "", ""},
+		{healthy, "GET /albums/events", "GET", "/albums/events", "", "Last-Event-ID: latest"},
+		{healthy, "GET /webhooks/dead-letters", "GET", "/webhooks/dead-letters", "", ""},
+		{healthy, "POST /graphql", "POST", "/graphql", `{"query":"{ albums { totalCount nodes { id artist { name } } } }"}`, ""},
+		{healthy, "POST /graphql", "POST", "/graphql", `{"query":"{ albums {"}`, ""},
+		{healthy, "GET /metrics", "GET", "/metrics", "", ""},
+		{healthy, "GET /openapi.json", "GET", "/openapi.json", "", ""},
+	}
+	// Every route serving a tenant's catalog rejects a malformed or
+	// unknown tenant and, once API keys are configured, a request without
+	// a key.
+	keyed := newHarness(t, "albums")
+	keyed.server.apiKeys = map[string]string{"s3cret": "blue-note"}
+	for _, d := range albumAPI {
+		if d.HeaderParams == nil {
+			continue
+		}
+		route := d.Method + " " + ginParam.ReplaceAllString(d.Path, "{$1}")
+		target := strings.ReplaceAll(d.Path, ":id", "1")
+		tests = append(tests,
+			driftCase{healthy, route, d.Method, target, "", "X-Tenant: Blue Note"},
+			driftCase{healthy, route, d.Method, target, "", "X-Tenant: impulse"},
+			driftCase{keyed.router, route, d.Method, target, "", ""},
+		)
+	}
+
+	exercised := make(map[string]bool)
+	for _, tt := range tests {
+		name := fmt.Sprintf("%s %s", tt.method, tt.target)
+		method, path, _ := strings.Cut(tt.route, " ")
+		op := doc.Paths[path][strings.ToLower(method)]
+		if op == nil {
+			t.Fatalf("%s: no operation %s in spec", name, tt.route)
+		}
+
+		// The deadline ends event streams; every other handler returns
+		// long before it.
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		req := httptest.NewRequestWithContext(ctx, tt.method, tt.target, strings.NewReader(tt.body))
+		if tt.body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if k, v, ok := strings.Cut(tt.header, ":"); ok {
+			req.Header.Set(k, strings.TrimSpace(v))
+			name += " " + tt.header
+		}
+		w := httptest.NewRecorder()
+		tt.router.ServeHTTP(w, req)
+		cancel()
+
+		status := fmt.Sprint(w.Code)
+		exercised[tt.route+" "+status] = true
+		resp, ok := op.Responses[status]
+		if !ok {
+			t.Errorf("%s: status %s is not documented", name, status)
+			continue
+		}
+		if len(resp.Content) == 0 {
+			if w.Body.Len() != 0 {
+				t.Errorf("%s: documented empty body, got %q", name, w.Body)
+			}
+			continue
+		}
+		contentType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+		media, ok := resp.Content[contentType]
+		if !ok {
+			t.Errorf("%s: content type %q is not documented", name, contentType)
+			continue
+		}
+		if contentType != "application/json" {
+			continue
+		}
+		var v any
+		if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+			t.Errorf("%s: invalid JSON: %v", name, err)
+			continue
+		}
+		for _, err := range validate(doc, media.Schema, v, "body") {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	for path, item := range doc.Paths {
+		for method, op := range item {
+			for status := range op.Responses {
+				key := strings.ToUpper(method) + " " + path + " " + status
+				if !exercised[key] {
+					t.Errorf("documented response %s is never exercised", key)
+				}
+			}
+		}
+	}
+}
+
+// validate checks v, decoded from JSON, against s and returns every
+// mismatch found.
+func validate(doc *openAPIDocument, s *schema, v any, at string) []error {
+	if s.Ref != "" {
+		name := strings.TrimPrefix(s.Ref, "#/components/schemas/")
+		ref, ok := doc.Components.Schemas[name]
+		if !ok {
+			return []error{fmt.Errorf("%s: unresolved $ref %s", at, s.Ref)}
+		}
+		return validate(doc, ref, v, at)
+	}
+
+	var errs []error
+	mismatch := func() []error { return []error{fmt.Errorf("%s: %v is not of type %s", at, v, s.Type)} }
+	switch s.Type {
+	case "":
+		return nil
+	case "string":
+		if _, ok := v.(string); !ok {
+			return mismatch()
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return mismatch()
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			return mismatch()
+		}
+	case "integer":
+		if f, ok := v.(float64); !ok || f != float64(int64(f)) {
+			return mismatch()
+		}
+	case "array":
+		items, ok := v.([]any)
+		if !ok {
+			return mismatch()
+		}
+		for i, item := range items {
+			errs = append(errs, validate(doc, s.Items, item, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return mismatch()
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				errs = append(errs, fmt.Errorf("%s: missing required property %q", at, name))
+			}
+		}
+		for name, value := range obj {
+			if prop, ok := s.Properties[name]; ok {
+				errs = append(errs, validate(doc, prop, value, at+"."+name)...)
+			} else if s.AdditionalProperties == false {
+				errs = append(errs, fmt.Errorf("%s: undocumented property %q", at, name))
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/csv"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+)
+
+// Media types the handlers can answer with.
+const (
+	mimeJSON    = "application/json"
+	mimeXML     = "application/xml"
+	mimeXML2    = "text/xml"
+	mimeMsgPack = "application/msgpack"
+	// mimeMsgPack2 is the unregistered name some clients still send.
+	mimeMsgPack2 = "application/x-msgpack"
+	mimeCSV      = "text/csv"
+)
+
+// bodyFormats are the representations of any response body, in order
+// of preference when the client accepts several equally.
+var bodyFormats = []string{mimeJSON, mimeXML, mimeXML2, mimeMsgPack, mimeMsgPack2}
+
+// listFormats adds CSV for endpoints returning a list of albums.
+var listFormats = append(append([]string(nil), bodyFormats...), mimeCSV)
+
+// albumList wraps a list of albums for XML, which needs a single root
+// element.
+type albumList struct {
+	XMLName xml.Name `xml:"albums"`
+	Albums  []album  `xml:"album"`
+}
+
+// respondedKey is the gin.Context key under which respondAs leaves what
+// it was asked to send, so that the idempotency middleware can send it
+// again in whatever format a retry accepts.
+const respondedKey = "respondedValue"
+
+// respondedValue is what a handler passed to respondAs.
+type respondedValue struct {
+	status int
+	v      any
+	offers []string
+}
+
+// respond writes v with the given status in the representation the
+// client asked for in its Accept header, compact JSON by default.
+// Errors use respond too, so they come back in the format the client
+// understands.
+func (s *server) respond(c *gin.Context, status int, v any) {
+	s.respondAs(c, status, v, bodyFormats)
+}
+
+// respondList is respond for a list of albums, which can also be sent
+// as CSV.
+func (s *server) respondList(c *gin.Context, status int, albums []album) {
+	s.respondAs(c, status, albums, listFormats)
+}
+
+func (s *server) respondAs(c *gin.Context, status int, v any, offers []string) {
+	c.Set(respondedKey, &respondedValue{status: status, v: v, offers: offers})
+	format := negotiate(c.GetHeader("Accept"), offers)
+	if format == "" {
+		// Nothing acceptable. An error is still worth showing the
+		// client, so it falls back to JSON; anything else becomes a 406.
+		format = mimeJSON
+		if status < http.StatusBadRequest {
+			status = http.StatusNotAcceptable
+			// Whatever the handler said about caching the album does
+			// not apply to this error.
+			c.Writer.Header().Del("Cache-Control")
+			c.Writer.Header().Del("ETag")
+			v = errorResponse{Message: "cannot produce any accepted media type; available: " + strings.Join(offers, ", ")}
+		}
+	}
+
+	switch format {
+	case mimeXML, mimeXML2:
+		// Lists need a root element of their own.
+		switch list := v.(type) {
+		case []album:
+			v = albumList{Albums: list}
+		case []albumChange:
+			v = changeList{Changes: list}
+		case []deadLetter:
+			v = deadLetterList{DeadLetters: list}
+		}
+		c.Render(status, render.XML{Data: v})
+	case mimeMsgPack, mimeMsgPack2:
+		c.Render(status, render.MsgPack{Data: v})
+	case mimeCSV:
+		c.Status(status)
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		writeAlbumsCSV(c.Writer, v.([]album))
+	default:
+		if _, ok := c.GetQuery("pretty"); ok && c.Query("pretty") != "false" {
+			c.IndentedJSON(status, v)
+			return
+		}
+		c.JSON(status, v)
+	}
+}
+
+// writeAlbumsCSV writes albums as CSV with a header row.
+func writeAlbumsCSV(w io.Writer, albums []album) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "title", "artist", "price"})
+	for _, a := range albums {
+		cw.Write([]string{a.ID, a.Title, a.Artist, strconv.FormatFloat(a.Price, 'f', -1, 64)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// qualityValue is one entry of an Accept or Accept-Encoding header.
+type qualityValue struct {
+	value string
+	q     float64
+}
+
+// parseQualityList parses a header such as
+// "application/xml;q=0.9, application/json" into its values, sorted by
+// descending q. Entries with equal q keep the order they were sent in.
+func parseQualityList(header string) []qualityValue {
+	var out []qualityValue
+	for _, part := range strings.Split(header, ",") {
+		value, params, _ := strings.Cut(part, ";")
+		value = strings.ToLower(strings.TrimSpace(value))
+		if value == "" {
+			continue
+		}
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			k, v, ok := strings.Cut(strings.TrimSpace(p), "=")
+			if ok && strings.EqualFold(k, "q") {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+		out = append(out, qualityValue{value: value, q: q})
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].q > out[j].q })
+	return out
+}
+
+// negotiate returns the offer the Accept header prefers, or "" if the
+// client accepts none of them. An absent header accepts anything.
+func negotiate(accept string, offers []string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+	for _, a := range parseQualityList(accept) {
+		if a.q <= 0 {
+			continue
+		}
+		for _, offer := range offers {
+			if mediaTypeMatches(a.value, offer) && !excluded(accept, offer) {
+				return offer
+			}
+		}
+	}
+	return ""
+}
+
+// mediaTypeMatches reports whether the accepted range, which may be
+// */* or type/*, covers offer.
+func mediaTypeMatches(accepted, offer string) bool {
+	if accepted == "*/*" || accepted == offer {
+		return true
+	}
+	if typ, ok := strings.CutSuffix(accepted, "/*"); ok {
+		return strings.HasPrefix(offer, typ+"/")
+	}
+	return false
+}
+
+// excluded reports whether the Accept header explicitly refuses offer
+// with q=0, which wins over a wildcard that would otherwise match.
+func excluded(accept, offer string) bool {
+	for _, a := range parseQualityList(accept) {
+		if a.value == offer && a.q <= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// compress is middleware that gzip- or deflate-encodes response bodies
+// when the client's Accept-Encoding allows it.
+func compress() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(c.GetHeader("Accept-Encoding"))
+		if encoding == "" || c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+
+		w := &compressWriter{ResponseWriter: c.Writer, encoding: encoding}
+		c.Writer = w
+		defer w.close()
+		c.Next()
+	}
+}
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding
+// header, or "" to send the body as is.
+func negotiateEncoding(header string) string {
+	for _, e := range parseQualityList(header) {
+		if e.q <= 0 {
+			continue
+		}
+		switch e.value {
+		case "gzip", "deflate":
+			return e.value
+		case "*":
+			return "gzip"
+		}
+	}
+	return ""
+}
+
+// compressWriter compresses everything written through it. The encoder
+// is created on the first write so that empty responses such as 204
+// stay empty and carry no Content-Encoding.
+type compressWriter struct {
+	gin.ResponseWriter
+	encoding string
+	encoder  interface {
+		io.WriteCloser
+		Flush() error
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.encoder == nil {
+		h := w.Header()
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		if w.encoding == "gzip" {
+			w.encoder = gzip.NewWriter(w.ResponseWriter)
+		} else {
+			// HTTP's "deflate" is the zlib format, not a raw deflate stream.
+			w.encoder = zlib.NewWriter(w.ResponseWriter)
+		}
+	}
+	return w.encoder.Write(b)
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush sends what has been compressed so far, so streaming responses
+// keep working through the middleware.
+func (w *compressWriter) Flush() {
+	if w.encoder != nil {
+		w.encoder.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *compressWriter) close() {
+	if w.encoder != nil {
+		w.encoder.Close()
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ugorji/go/codec"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		offers []string
+		want   string
+	}{
+		{"", bodyFormats, mimeJSON},
+		{"*/*", bodyFormats, mimeJSON},
+		{"application/xml", bodyFormats, mimeXML},
+		{"text/*", listFormats, mimeXML2},
+		{"application/json;q=0.5, application/msgpack", bodyFormats, mimeMsgPack},
+		{"application/json;q=0, */*", bodyFormats, mimeXML},
+		{"text/csv", bodyFormats, ""},
+		{"text/csv", listFormats, mimeCSV},
+		{"TEXT/CSV;q=0.8", listFormats, mimeCSV},
+	}
+	for _, tt := range tests {
+		if got := negotiate(tt.accept, tt.offers); got != tt.want {
+			t.Errorf("negotiate(%q) = %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":                      "",
+		"identity":              "",
+		"gzip":                  "gzip",
+		"deflate, gzip;q=0.5":   "deflate",
+		"gzip;q=0, deflate":     "deflate",
+		"br, *":                 "gzip",
+		"gzip;q=0, deflate;q=0": "",
+	}
+	for header, want := range tests {
+		if got := negotiateEncoding(header); got != want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+// get requests target from a harness loaded with the albums fixture.
+func get(t *testing.T, target string, headers ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	return newHarness(t, "albums").do(http.MethodGet, target, "", headers...)
+}
+
+func TestCompactAndPrettyJSON(t *testing.T) {
+	compact := get(t, "/albums/1").Body.String()
+	if want := `{"id":"1","title":"Blue Train","artist":"John Coltrane","price":56.99}`; compact != want {
+		t.Errorf("compact body = %s, want %s", compact, want)
+	}
+	pretty := get(t, "/albums/1?pretty").Body.String()
+	if !strings.Contains(pretty, "\n    \"title\": \"Blue Train\"") {
+		t.Errorf("?pretty body is not indented: %s", pretty)
+	}
+	if got := get(t, "/albums/1?pretty=false").Body.String(); got != compact {
+		t.Errorf("?pretty=false body = %s, want compact", got)
+	}
+}
+
+func TestXMLList(t *testing.T) {
+	w := get(t, "/albums", "Accept: application/xml")
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/xml") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	var list albumList
+	if err := xml.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if want := loadFixture(t, "albums"); len(list.Albums) != len(want) || list.Albums[1] != want[1] {
+		t.Errorf("decoded %+v", list.Albums)
+	}
+}
+
+func TestXMLDeadLetters(t *testing.T) {
+	h := newHarness(t, "albums")
+	h.server.webhooks = newWebhookDispatcher([]webhookEndpoint{{URL: "http://receiver"}}, h.server.metrics)
+	h.server.webhooks.bury(0, albumEvent{ID: 1, Type: eventAlbumDeleted, Tenant: defaultTenant}, 3, errors.New("502 Bad Gateway"))
+	w := h.do(http.MethodGet, "/webhooks/dead-letters", "", "Accept: application/xml")
+	var list deadLetterList
+	if err := xml.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("%v in %s", err, w.Body)
+	}
+	if len(list.DeadLetters) != 1 || list.DeadLetters[0].Event.Type != eventAlbumDeleted || list.DeadLetters[0].Attempts != 3 {
+		t.Errorf("decoded %+v", list.DeadLetters)
+	}
+}
+
+func TestXMLError(t *testing.T) {
+	w := get(t, "/albums/nope", "Accept: text/xml")
+	if want := "<error><message>album not found</message></error>"; w.Body.String() != want {
+		t.Errorf("body = %s, want %s", w.Body, want)
+	}
+}
+
+func TestMsgPack(t *testing.T) {
+	w := get(t, "/albums/2", "Accept: application/x-msgpack")
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, mimeMsgPack) {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	var got album
+	var mh codec.MsgpackHandle
+	if err := codec.NewDecoder(w.Body, &mh).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if want := loadFixture(t, "albums")[1]; got != want {
+		t.Errorf("decoded %+v, want %+v", got, want)
+	}
+}
+
+func TestCSVList(t *testing.T) {
+	w := get(t, "/albums", "Accept: text/csv")
+	want := "id,title,artist,price\n" +
+		"1,Blue Train,John Coltrane,56.99\n" +
+		"2,Jeru,Gerry Mulligan,17.99\n" +
+		"3,Sarah Vaughan and Clifford Brown,Sarah Vaughan,39.99\n"
+	if w.Body.String() != want {
+		t.Errorf("body =\n%s\nwant\n%s", w.Body, want)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	plain := get(t, "/albums").Body.String()
+	for _, encoding := range []string{"gzip", "deflate"} {
+		w := get(t, "/albums", "Accept-Encoding: "+encoding)
+		if got := w.Header().Get("Content-Encoding"); got != encoding {
+			t.Errorf("%s: Content-Encoding = %q", encoding, got)
+		}
+		if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("%s: Vary = %q", encoding, got)
+		}
+		var r io.Reader
+		var err error
+		if encoding == "gzip" {
+			r, err = gzip.NewReader(w.Body)
+		} else {
+			r, err = zlib.NewReader(w.Body)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", encoding, err)
+		}
+		body, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: %v", encoding, err)
+		}
+		if string(body) != plain {
+			t.Errorf("%s: decoded body = %s, want %s", encoding, body, plain)
+		}
+	}
+
+	if got := get(t, "/albums").Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("uncompressed response has Content-Encoding %q", got)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/Niku19/golearn/Database/albumdb"
+)
+
+// sqlStore is an albumStore backed by the recordings database through
+// the Database module's repository.
+type sqlStore struct {
+	repo *albumdb.Repository
+}
+
+func newSQLStore(repo *albumdb.Repository) *sqlStore {
+	return &sqlStore{repo: repo}
+}
+
+func (s *sqlStore) List(ctx context.Context) ([]album, error) {
+	rows, err := s.repo.Albums(ctx)
+	if err != nil {
+		return nil, err
+	}
+	albums := make([]album, 0, len(rows))
+	for _, row := range rows {
+		albums = append(albums, fromRow(row))
+	}
+	return albums, nil
+}
+
+func (s *sqlStore) ListByArtists(ctx context.Context, artists []string) ([]album, error) {
+	rows, err := s.repo.AlbumsByArtists(ctx, artists)
+	if err != nil {
+		return nil, err
+	}
+	albums := make([]album, 0, len(rows))
+	for _, row := range rows {
+		albums = append(albums, fromRow(row))
+	}
+	return albums, nil
+}
+
+func (s *sqlStore) ListByPrice(ctx context.Context, min, max float64) ([]album, error) {
+	rows, err := s.repo.AlbumsByPriceRange(ctx, min, max)
+	if err != nil {
+		return nil, err
+	}
+	albums := make([]album, 0, len(rows))
+	for _, row := range rows {
+		albums = append(albums, fromRow(row))
+	}
+	return albums, nil
+}
+
+func (s *sqlStore) Count(ctx context.Context) (int, error) {
+	return s.repo.CountAlbums(ctx)
+}
+
+func (s *sqlStore) Get(ctx context.Context, id string) (album, error) {
+	// IDs in the database are integers, so anything else cannot match.
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return album{}, errAlbumNotFound
+	}
+	row, err := s.repo.AlbumByID(ctx, n)
+	if errors.Is(err, albumdb.ErrNotFound) {
+		return album{}, errAlbumNotFound
+	}
+	if err != nil {
+		return album{}, err
+	}
+	return fromRow(row), nil
+}
+
+// Add inserts a, letting the database assign the ID.
+func (s *sqlStore) Add(ctx context.Context, a album) (album, error) {
+	row, err := s.repo.AddAlbum(ctx, actorFrom(ctx), toRow(a))
+	if err != nil {
+		return album{}, err
+	}
+	return fromRow(row), nil
+}
+
+func (s *sqlStore) Update(ctx context.Context, a album) (album, error) {
+	n, err := strconv.ParseInt(a.ID, 10, 64)
+	if err != nil {
+		return album{}, errAlbumNotFound
+	}
+	row := toRow(a)
+	row.ID = n
+	row, err = s.repo.UpdateAlbum(ctx, actorFrom(ctx), row)
+	if err != nil {
+		return album{}, storeError(err)
+	}
+	return fromRow(row), nil
+}
+
+func (s *sqlStore) Delete(ctx context.Context, id string) (album, error) {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return album{}, errAlbumNotFound
+	}
+	row, err := s.repo.DeleteAlbum(ctx, actorFrom(ctx), n)
+	if err != nil {
+		return album{}, storeError(err)
+	}
+	return fromRow(row), nil
+}
+
+func (s *sqlStore) Restore(ctx context.Context, id string) (album, error) {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return album{}, errAlbumNotFound
+	}
+	row, err := s.repo.RestoreAlbum(ctx, actorFrom(ctx), n)
+	if err != nil {
+		return album{}, storeError(err)
+	}
+	return fromRow(row), nil
+}
+
+func (s *sqlStore) History(ctx context.Context, id string) ([]albumChange, error) {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, errAlbumNotFound
+	}
+	rows, err := s.repo.AlbumHistory(ctx, n)
+	if err != nil {
+		return nil, storeError(err)
+	}
+	changes := make([]albumChange, 0, len(rows))
+	for _, row := range rows {
+		c := albumChange{ID: row.ID, AlbumID: id, Action: row.Action, Actor: row.Actor, Time: row.Time}
+		if row.Before != nil {
+			before := fromRow(*row.Before)
+			c.Before = &before
+		}
+		if row.After != nil {
+			after := fromRow(*row.After)
+			c.After = &after
+		}
+		changes = append(changes, c)
+	}
+	return changes, nil
+}
+
+// storeError maps the repository's errors to the albumStore ones.
+func storeError(err error) error {
+	switch {
+	case errors.Is(err, albumdb.ErrNotFound):
+		return errAlbumNotFound
+	case errors.Is(err, albumdb.ErrNotDeleted):
+		return errAlbumNotDeleted
+	}
+	return err
+}
+
+// fromRow converts a database row to the JSON representation.
+func fromRow(row albumdb.Album) album {
+	a := album{
+		ID:        strconv.FormatInt(row.ID, 10),
+		Title:     row.Title,
+		Artist:    row.Artist,
+		Price:     row.PriceFloat64(),
+		DeletedAt: row.DeletedAt,
+	}
+	if !row.CreatedAt.IsZero() {
+		a.CreatedAt = &row.CreatedAt
+	}
+	if !row.UpdatedAt.IsZero() {
+		a.UpdatedAt = &row.UpdatedAt
+	}
+	return a
+}
+
+// toRow converts an album to a database row. The ID is left to the
+// database.
+func toRow(a album) albumdb.Album {
+	return albumdb.Album{Title: a.Title, Artist: a.Artist, Price: albumdb.PriceFromFloat64(a.Price)}
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errAlbumNotFound is returned by an albumStore when no album has the
+// requested ID.
+var errAlbumNotFound = errors.New("album not found")
+
+// errAlbumExists is returned by an albumStore asked to add an album
+// whose ID is already taken.
+var errAlbumExists = errors.New("album already exists")
+
+// albumStore is the storage the handlers read and write albums through.
+// memoryStore keeps them in memory like the original seed data, and
+// sqlStore keeps them in the recordings MySQL database.
+type albumStore interface {
+	List(ctx context.Context) ([]album, error)
+	// ListByArtists returns the albums of any of the named artists, so
+	// callers resolving many artists can fetch them in one go.
+	ListByArtists(ctx context.Context, artists []string) ([]album, error)
+	// ListByPrice returns the albums priced from min to max inclusive,
+	// in the same order as List.
+	ListByPrice(ctx context.Context, min, max float64) ([]album, error)
+	Get(ctx context.Context, id string) (album, error)
+	Add(ctx context.Context, a album) (album, error)
+	// Update replaces the album with a.ID.
+	Update(ctx context.Context, a album) (album, error)
+	// Delete marks the album with id deleted and returns it as it was.
+	// A deleted album is left out of every read but History until it
+	// is restored.
+	Delete(ctx context.Context, id string) (album, error)
+	// Restore undoes the deletion of the album with id.
+	Restore(ctx context.Context, id string) (album, error)
+	// History returns the changes made to the album with id, deleted or
+	// not, oldest first.
+	History(ctx context.Context, id string) ([]albumChange, error)
+}
+
+// memoryStore is an albumStore kept in memory and guarded by a mutex,
+// since Gin serves every request on its own goroutine. Besides the
+// albums themselves it keeps indexes by ID, artist and price so that
+// lookups do not scan the whole catalog. Every write updates all of
+// them under the same lock, so readers never see them disagree.
+type memoryStore struct {
+	mu sync.RWMutex
+	// albums is the catalog in the order albums were added, which is
+	// the order List returns them in.
+	albums []album
+	// byID maps an album ID to its position in albums.
+	byID map[string]int
+	// byArtist maps an artist to the IDs of their albums.
+	byArtist map[string][]string
+	// byPrice holds every ID ordered by price, then by ID.
+	byPrice []string
+
+	// deleted holds the albums that were deleted, by ID. They are kept
+	// out of albums and its indexes so reads need not skip them.
+	deleted map[string]album
+	// history holds the changes made to each album, oldest first.
+	history    map[string][]albumChange
+	lastChange int64
+
+	// now stamps changes; tests replace it with a fixed time.
+	now func() time.Time
+}
+
+// newMemoryStore returns a memoryStore holding a copy of seed. Of
+// albums sharing an ID, only the first is kept. The seed is taken as
+// is, without recording it in any album's history.
+func newMemoryStore(seed []album) *memoryStore {
+	s := &memoryStore{
+		byID:     make(map[string]int, len(seed)),
+		byArtist: make(map[string][]string),
+		deleted:  make(map[string]album),
+		history:  make(map[string][]albumChange),
+		now:      time.Now,
+	}
+	for _, a := range seed {
+		if _, ok := s.byID[a.ID]; !ok {
+			s.insertLocked(a)
+		}
+	}
+	return s
+}
+
+func (s *memoryStore) List(ctx context.Context) ([]album, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]album(nil), s.albums...), nil
+}
+
+// Count returns the number of albums, not counting deleted ones.
+func (s *memoryStore) Count(ctx context.Context) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.albums), nil
+}
+
+func (s *memoryStore) ListByArtists(ctx context.Context, artists []string) ([]album, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var ids []string
+	seen := make(map[string]bool, len(artists))
+	for _, name := range artists {
+		if !seen[name] {
+			seen[name] = true
+			ids = append(ids, s.byArtist[name]...)
+		}
+	}
+	return s.inOrderLocked(ids), nil
+}
+
+func (s *memoryStore) ListByPrice(ctx context.Context, min, max float64) ([]album, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	first := sort.Search(len(s.byPrice), func(i int) bool { return s.priceAt(i) >= min })
+	last := sort.Search(len(s.byPrice), func(i int) bool { return s.priceAt(i) > max })
+	if first >= last {
+		return nil, nil
+	}
+	return s.inOrderLocked(s.byPrice[first:last]), nil
+}
+
+func (s *memoryStore) Get(ctx context.Context, id string) (album, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if i, ok := s.byID[id]; ok {
+		return s.albums[i], nil
+	}
+	return album{}, errAlbumNotFound
+}
+
+// Store operations, as recorded in the write-ahead log.
+const (
+	opAdd     = "add"
+	opUpdate  = "update"
+	opDelete  = "delete"
+	opRestore = "restore"
+)
+
+func (s *memoryStore) Add(ctx context.Context, a album) (album, error) {
+	return s.write(opAdd, a, actorFrom(ctx), s.now())
+}
+
+func (s *memoryStore) Update(ctx context.Context, a album) (album, error) {
+	return s.write(opUpdate, a, actorFrom(ctx), s.now())
+}
+
+func (s *memoryStore) Delete(ctx context.Context, id string) (album, error) {
+	return s.write(opDelete, album{ID: id}, actorFrom(ctx), s.now())
+}
+
+func (s *memoryStore) Restore(ctx context.Context, id string) (album, error) {
+	return s.write(opRestore, album{ID: id}, actorFrom(ctx), s.now())
+}
+
+func (s *memoryStore) History(ctx context.Context, id string) ([]albumChange, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.byID[id]; !ok {
+		if _, ok := s.deleted[id]; !ok {
+			return nil, errAlbumNotFound
+		}
+	}
+	return slices.Clone(s.history[id]), nil
+}
+
+// check reports the error op on the album with id would fail with,
+// without making the change.
+func (s *memoryStore) check(op, id string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.checkLocked(op, id)
+}
+
+func (s *memoryStore) checkLocked(op, id string) error {
+	_, live := s.byID[id]
+	_, deleted := s.deleted[id]
+	switch {
+	case op == opAdd && (live || deleted):
+		return errAlbumExists
+	case (op == opUpdate || op == opDelete) && !live:
+		return errAlbumNotFound
+	case op == opRestore && live:
+		return errAlbumNotDeleted
+	case op == opRestore && !deleted:
+		return errAlbumNotFound
+	}
+	return nil
+}
+
+// write makes the change op describes on behalf of actor at time at,
+// recording it in the album's history. It returns the album as stored,
+// or for a delete as it was.
+func (s *memoryStore) write(op string, a album, actor string, at time.Time) (album, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkLocked(op, a.ID); err != nil {
+		return album{}, err
+	}
+
+	switch op {
+	case opAdd:
+		a.CreatedAt, a.UpdatedAt, a.DeletedAt = &at, &at, nil
+		s.insertLocked(a)
+		s.recordLocked(changeCreated, actor, at, nil, &a)
+		return a, nil
+
+	case opUpdate:
+		i := s.byID[a.ID]
+		before := s.albums[i]
+		a.CreatedAt, a.UpdatedAt, a.DeletedAt = before.CreatedAt, &at, nil
+		s.unindexLocked(before)
+		s.albums[i] = a
+		s.indexLocked(a)
+		s.recordLocked(changeUpdated, actor, at, &before, &a)
+		return a, nil
+
+	case opDelete:
+		before := s.removeLocked(a.ID)
+		after := before
+		after.DeletedAt = &at
+		s.deleted[a.ID] = after
+		s.recordLocked(changeDeleted, actor, at, &before, &after)
+		return before, nil
+
+	case opRestore:
+		before := s.deleted[a.ID]
+		delete(s.deleted, a.ID)
+		after := before
+		after.UpdatedAt, after.DeletedAt = &at, nil
+		// A restored album goes to the end of the catalog, as if it had
+		// just been added.
+		s.insertLocked(after)
+		s.recordLocked(changeRestored, actor, at, &before, &after)
+		return after, nil
+	}
+	return album{}, fmt.Errorf("unknown store operation %q", op)
+}
+
+func (s *memoryStore) recordLocked(action, actor string, at time.Time, before, after *album) {
+	s.lastChange++
+	id := after.ID
+	s.history[id] = append(s.history[id], albumChange{
+		ID: s.lastChange, AlbumID: id, Action: action, Actor: actor, Time: at, Before: before, After: after,
+	})
+}
+
+// removeLocked takes the live album with id out of the catalog and its
+// indexes and returns it.
+func (s *memoryStore) removeLocked(id string) album {
+	i := s.byID[id]
+	a := s.albums[i]
+	s.unindexLocked(a)
+	delete(s.byID, id)
+	s.albums = slices.Delete(s.albums, i, i+1)
+	// Every album after the removed one moved up a place.
+	for j := i; j < len(s.albums); j++ {
+		s.byID[s.albums[j].ID] = j
+	}
+	return a
+}
+
+// memoryState is everything a memoryStore holds, in a form that can be
+// saved and loaded again.
+type memoryState struct {
+	Albums     []album       `json:"albums"`
+	Deleted    []album       `json:"deleted,omitempty"`
+	History    []albumChange `json:"history,omitempty"`
+	LastChange int64         `json:"last_change,omitempty"`
+}
+
+func (s *memoryStore) state() memoryState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	st := memoryState{Albums: slices.Clone(s.albums), LastChange: s.lastChange}
+	for _, a := range s.deleted {
+		st.Deleted = append(st.Deleted, a)
+	}
+	slices.SortFunc(st.Deleted, func(a, b album) int { return strings.Compare(a.ID, b.ID) })
+	for _, changes := range s.history {
+		st.History = append(st.History, changes...)
+	}
+	slices.SortFunc(st.History, func(a, b albumChange) int { return cmp.Compare(a.ID, b.ID) })
+	return st
+}
+
+// newMemoryStoreFromState returns a memoryStore holding st.
+func newMemoryStoreFromState(st memoryState) *memoryStore {
+	s := newMemoryStore(st.Albums)
+	for _, a := range st.Deleted {
+		s.deleted[a.ID] = a
+	}
+	for _, c := range st.History {
+		s.history[c.AlbumID] = append(s.history[c.AlbumID], c)
+	}
+	s.lastChange = st.LastChange
+	return s
+}
+
+// insertLocked appends a, whose ID must not be in use, to the catalog.
+func (s *memoryStore) insertLocked(a album) {
+	s.byID[a.ID] = len(s.albums)
+	s.albums = append(s.albums, a)
+	s.indexLocked(a)
+}
+
+// indexLocked adds a to the artist and price indexes.
+func (s *memoryStore) indexLocked(a album) {
+	s.byArtist[a.Artist] = append(s.byArtist[a.Artist], a.ID)
+	i := s.pricePosLocked(a)
+	s.byPrice = slices.Insert(s.byPrice, i, a.ID)
+}
+
+// unindexLocked removes a, as it is currently stored, from the artist
+// and price indexes.
+func (s *memoryStore) unindexLocked(a album) {
+	ids := s.byArtist[a.Artist]
+	if i := slices.Index(ids, a.ID); i >= 0 {
+		ids = slices.Delete(ids, i, i+1)
+	}
+	if len(ids) == 0 {
+		delete(s.byArtist, a.Artist)
+	} else {
+		s.byArtist[a.Artist] = ids
+	}
+	if i := s.pricePosLocked(a); i < len(s.byPrice) && s.byPrice[i] == a.ID {
+		s.byPrice = slices.Delete(s.byPrice, i, i+1)
+	}
+}
+
+// pricePosLocked returns where a belongs in byPrice.
+func (s *memoryStore) pricePosLocked(a album) int {
+	return sort.Search(len(s.byPrice), func(i int) bool {
+		p := s.priceAt(i)
+		return p > a.Price || p == a.Price && s.byPrice[i] >= a.ID
+	})
+}
+
+func (s *memoryStore) priceAt(i int) float64 {
+	return s.albums[s.byID[s.byPrice[i]]].Price
+}
+
+// inOrderLocked returns the albums with ids in catalog order.
+func (s *memoryStore) inOrderLocked(ids []string) []album {
+	if len(ids) == 0 {
+		return nil
+	}
+	pos := make([]int, len(ids))
+	for i, id := range ids {
+		pos[i] = s.byID[id]
+	}
+	slices.Sort(pos)
+	out := make([]album, len(pos))
+	for i, p := range pos {
+		out[i] = s.albums[p]
+	}
+	return out
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+// sliceStore is the memoryStore as it was before it had indexes: one
+// slice, scanned for every lookup. It stays as the reference the
+// indexed store is checked and benchmarked against. It keeps the IDs of
+// deleted albums, which cannot be reused, but neither timestamps nor
+// history.
+type sliceStore struct {
+	albums  []album
+	deleted []string
+}
+
+func (s *sliceStore) List(ctx context.Context) ([]album, error) {
+	return append([]album(nil), s.albums...), nil
+}
+
+func (s *sliceStore) ListByArtists(ctx context.Context, artists []string) ([]album, error) {
+	var out []album
+	for _, a := range s.albums {
+		if slices.Contains(artists, a.Artist) {
+			out = append(out, a)
+		}
+	}
+	return out, nil
+}
+
+func (s *sliceStore) ListByPrice(ctx context.Context, min, max float64) ([]album, error) {
+	var out []album
+	for _, a := range s.albums {
+		if a.Price >= min && a.Price <= max {
+			out = append(out, a)
+		}
+	}
+	return out, nil
+}
+
+func (s *sliceStore) Get(ctx context.Context, id string) (album, error) {
+	for _, a := range s.albums {
+		if a.ID == id {
+			return a, nil
+		}
+	}
+	return album{}, errAlbumNotFound
+}
+
+func (s *sliceStore) Add(ctx context.Context, a album) (album, error) {
+	if _, err := s.Get(ctx, a.ID); err == nil || slices.Contains(s.deleted, a.ID) {
+		return album{}, errAlbumExists
+	}
+	s.albums = append(s.albums, a)
+	return a, nil
+}
+
+func (s *sliceStore) Update(ctx context.Context, a album) (album, error) {
+	for i := range s.albums {
+		if s.albums[i].ID == a.ID {
+			s.albums[i] = a
+			return a, nil
+		}
+	}
+	return album{}, errAlbumNotFound
+}
+
+func (s *sliceStore) Delete(ctx context.Context, id string) (album, error) {
+	for i, a := range s.albums {
+		if a.ID == id {
+			s.albums = slices.Delete(s.albums, i, i+1)
+			s.deleted = append(s.deleted, id)
+			return a, nil
+		}
+	}
+	return album{}, errAlbumNotFound
+}
+
+// untimed returns albums without the timestamps the indexed store sets,
+// for comparing with sliceStore.
+func untimed(albums ...album) []album {
+	out := make([]album, len(albums))
+	for i, a := range albums {
+		a.CreatedAt, a.UpdatedAt, a.DeletedAt = nil, nil, nil
+		out[i] = a
+	}
+	return out
+}
+
+// randomAlbum returns an album with one of n IDs, one of a few artists
+// and a price in whole dollars, so that writes collide often.
+func randomAlbum(r *rand.Rand, n int) album {
+	return album{
+		ID:     fmt.Sprint(r.IntN(n)),
+		Title:  fmt.Sprint("Title ", r.IntN(1000)),
+		Artist: fmt.Sprint("Artist ", r.IntN(8)),
+		Price:  float64(r.IntN(50)),
+	}
+}
+
+func TestMemoryStoreIndexesMatchScan(t *testing.T) {
+	ctx := context.Background()
+	r := rand.New(rand.NewPCG(1, 2))
+	indexed, scan := newMemoryStore(nil), &sliceStore{}
+
+	for step := range 5000 {
+		a := randomAlbum(r, 60)
+		var got, want album
+		var gotErr, wantErr error
+		switch op := r.IntN(3); op {
+		case 0:
+			got, gotErr = indexed.Add(ctx, a)
+			want, wantErr = scan.Add(ctx, a)
+		case 1:
+			got, gotErr = indexed.Update(ctx, a)
+			want, wantErr = scan.Update(ctx, a)
+		case 2:
+			got, gotErr = indexed.Delete(ctx, a.ID)
+			want, wantErr = scan.Delete(ctx, a.ID)
+		}
+		if untimed(got)[0] != want || !errors.Is(gotErr, wantErr) {
+			t.Fatalf("step %d: got %+v, %v; want %+v, %v", step, got, gotErr, want, wantErr)
+		}
+
+		check := func(what string, got, want []album) {
+			t.Helper()
+			if !slices.Equal(untimed(got...), want) {
+				t.Fatalf("step %d: %s = %+v, want %+v", step, what, got, want)
+			}
+		}
+		gotList, _ := indexed.List(ctx)
+		wantList, _ := scan.List(ctx)
+		check("List", gotList, wantList)
+
+		artists := []string{a.Artist, randomAlbum(r, 1).Artist}
+		gotList, _ = indexed.ListByArtists(ctx, artists)
+		wantList, _ = scan.ListByArtists(ctx, artists)
+		check(fmt.Sprint("ListByArtists", artists), gotList, wantList)
+
+		lo := float64(r.IntN(50))
+		hi := lo + float64(r.IntN(10))
+		gotList, _ = indexed.ListByPrice(ctx, lo, hi)
+		wantList, _ = scan.ListByPrice(ctx, lo, hi)
+		check(fmt.Sprintf("ListByPrice(%v, %v)", lo, hi), gotList, wantList)
+
+		gotAlbum, gotErr := indexed.Get(ctx, a.ID)
+		wantAlbum, wantErr := scan.Get(ctx, a.ID)
+		if untimed(gotAlbum)[0] != wantAlbum || !errors.Is(gotErr, wantErr) {
+			t.Fatalf("step %d: Get(%s) = %+v, %v; want %+v, %v", step, a.ID, gotAlbum, gotErr, wantAlbum, wantErr)
+		}
+	}
+}
+
+func TestMemoryStoreRejectsDuplicateIDs(t *testing.T) {
+	s := newMemoryStore(loadFixture(t, "albums"))
+	if _, err := s.Add(context.Background(), album{ID: "1"}); !errors.Is(err, errAlbumExists) {
+		t.Errorf("Add with a taken ID: %v, want errAlbumExists", err)
+	}
+	if w := newHarness(t, "albums").do(http.MethodPost, "/albums", `{"id":"1"}`); w.Code != http.StatusConflict {
+		t.Errorf("POST with a taken ID: status %d, want 409", w.Code)
+	}
+}
+
+func TestMemoryStoreSoftDelete(t *testing.T) {
+	s := newMemoryStore(loadFixture(t, "albums"))
+	now := testTime
+	s.now = func() time.Time { return now }
+	ctx := withActor(context.Background(), "alice")
+
+	a, err := s.Add(ctx, album{ID: "4", Title: "Giant Steps", Artist: "John Coltrane", Price: 63.99})
+	if err != nil || !a.CreatedAt.Equal(testTime) || !a.UpdatedAt.Equal(testTime) {
+		t.Fatalf("Add = %+v, %v; want both timestamps set", a, err)
+	}
+	now = now.Add(time.Hour)
+	if a, _ = s.Update(ctx, album{ID: "4", Title: "Giant Steps", Artist: "John Coltrane", Price: 49.99}); !a.CreatedAt.Equal(testTime) || !a.UpdatedAt.Equal(now) {
+		t.Errorf("Update = %+v; want created_at kept and updated_at moved", a)
+	}
+
+	now = now.Add(time.Hour)
+	if _, err := s.Delete(withActor(context.Background(), "bob"), "4"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := listed(t, s), []string{"1", "2", "3"}; !slices.Equal(got, want) {
+		t.Errorf("after Delete: %v, want %v", got, want)
+	}
+	if _, err := s.Get(ctx, "4"); !errors.Is(err, errAlbumNotFound) {
+		t.Errorf("Get deleted = %v", err)
+	}
+	if albums, _ := s.ListByArtists(ctx, []string{"John Coltrane"}); len(albums) != 1 {
+		t.Errorf("ListByArtists lists deleted albums: %+v", albums)
+	}
+	for name, err := range map[string]error{
+		"Add":    second(s.Add(ctx, album{ID: "4"})),
+		"Update": second(s.Update(ctx, album{ID: "4"})),
+		"Delete": second(s.Delete(ctx, "4")),
+	} {
+		if want := map[bool]error{true: errAlbumExists, false: errAlbumNotFound}[name == "Add"]; !errors.Is(err, want) {
+			t.Errorf("%s of a deleted album = %v, want %v", name, err, want)
+		}
+	}
+
+	now = now.Add(time.Hour)
+	if a, err = s.Restore(ctx, "4"); err != nil || a.Price != 49.99 || a.DeletedAt != nil || !a.UpdatedAt.Equal(now) {
+		t.Errorf("Restore = %+v, %v", a, err)
+	}
+	if _, err := s.Restore(ctx, "4"); !errors.Is(err, errAlbumNotDeleted) {
+		t.Errorf("Restore of a live album = %v", err)
+	}
+	if _, err := s.Restore(ctx, "99"); !errors.Is(err, errAlbumNotFound) {
+		t.Errorf("Restore of a missing album = %v", err)
+	}
+
+	changes, err := s.History(ctx, "4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range changes {
+		got = append(got, fmt.Sprintf("%d %s %s", c.ID, c.Action, c.Actor))
+	}
+	want := []string{"1 created alice", "2 updated alice", "3 deleted bob", "4 restored alice"}
+	if !slices.Equal(got, want) {
+		t.Errorf("History = %q, want %q", got, want)
+	}
+	if del := changes[2]; del.Before.Price != 49.99 || del.Before.DeletedAt != nil || !del.After.DeletedAt.Equal(del.Time) {
+		t.Errorf("delete recorded before %+v, after %+v", del.Before, del.After)
+	}
+	if changes[0].Before != nil {
+		t.Errorf("created change has a before: %+v", changes[0].Before)
+	}
+	if changes, err := s.History(ctx, "1"); err != nil || len(changes) != 0 {
+		t.Errorf("History of a seeded album = %+v, %v; want none", changes, err)
+	}
+	if _, err := s.History(ctx, "99"); !errors.Is(err, errAlbumNotFound) {
+		t.Errorf("History of a missing album = %v", err)
+	}
+}
+
+// benchStore is the part of albumStore both benchmarked stores have.
+type benchStore interface {
+	Get(ctx context.Context, id string) (album, error)
+	ListByArtists(ctx context.Context, artists []string) ([]album, error)
+	ListByPrice(ctx context.Context, min, max float64) ([]album, error)
+	Update(ctx context.Context, a album) (album, error)
+}
+
+// benchmarkStores runs bench against the indexed and the scanning store,
+// each holding n albums.
+func benchmarkStores(b *testing.B, bench func(b *testing.B, s benchStore, n int)) {
+	for _, n := range []int{100, 10_000} {
+		r := rand.New(rand.NewPCG(1, 2))
+		seed := make([]album, n)
+		for i := range seed {
+			seed[i] = randomAlbum(r, 1)
+			seed[i].ID = fmt.Sprint(i)
+			seed[i].Artist = fmt.Sprint("Artist ", i%(n/10))
+			seed[i].Price = float64(r.IntN(10_000)) / 100
+		}
+		b.Run(fmt.Sprintf("indexed/n=%d", n), func(b *testing.B) { bench(b, newMemoryStore(seed), n) })
+		b.Run(fmt.Sprintf("scan/n=%d", n), func(b *testing.B) { bench(b, &sliceStore{albums: slices.Clone(seed)}, n) })
+	}
+}
+
+func BenchmarkStoreGet(b *testing.B) {
+	benchmarkStores(b, func(b *testing.B, s benchStore, n int) {
+		ctx := context.Background()
+		for i := 0; b.Loop(); i++ {
+			if _, err := s.Get(ctx, fmt.Sprint(i%n)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkStoreListByArtists(b *testing.B) {
+	benchmarkStores(b, func(b *testing.B, s benchStore, n int) {
+		ctx := context.Background()
+		for i := 0; b.Loop(); i++ {
+			s.ListByArtists(ctx, []string{fmt.Sprint("Artist ", i%(n/10))})
+		}
+	})
+}
+
+func BenchmarkStoreListByPrice(b *testing.B) {
+	benchmarkStores(b, func(b *testing.B, s benchStore, n int) {
+		ctx := context.Background()
+		for i := 0; b.Loop(); i++ {
+			lo := float64(i % 100)
+			s.ListByPrice(ctx, lo, lo+0.5)
+		}
+	})
+}
+
+func BenchmarkStoreUpdate(b *testing.B) {
+	benchmarkStores(b, func(b *testing.B, s benchStore, n int) {
+		ctx := context.Background()
+		for i := 0; b.Loop(); i++ {
+			a := album{ID: fmt.Sprint(i % n), Artist: fmt.Sprint("Artist ", i%7), Price: float64(i % 100)}
+			if _, err := s.Update(ctx, a); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Niku19/golearn/Database/albumdb"
+	"github.com/gin-gonic/gin"
+)
+
+// Every record label hosted by the service is a tenant with a catalog
+// of its own. Requests name their tenant with the X-Tenant header or,
+// once API keys are configured, by the key they present: the key is
+// then the only claim trusted and X-Tenant is ignored. Requests naming
+// no tenant get defaultTenant, which holds the original seed albums.
+// Either way only the tenants the operator configured exist, so
+// clients cannot open catalogs, and files, at will.
+const (
+	headerTenant = "X-Tenant"
+	headerAPIKey = "X-API-Key"
+
+	defaultTenant = albumdb.DefaultTenant
+)
+
+// tenantName is what a tenant may be called. Names end up in file
+// paths and SQL, so they are kept to lower-case letters, digits and
+// dashes, and fit the tenant_id column.
+var tenantName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+var (
+	errInvalidTenant = errors.New("tenant must be lower-case letters, digits and dashes")
+	errInvalidAPIKey = errors.New("missing or invalid API key")
+	errUnknownTenant = errors.New("no such tenant")
+	// errQuotaExceeded is returned by a tenantStore asked to add or
+	// restore an album when the tenant already has as many as its quota
+	// allows.
+	errQuotaExceeded = errors.New("album quota exceeded")
+)
+
+type tenantKey struct{}
+
+// withTenant returns a context whose reads and writes go to the catalog
+// of tenant.
+func withTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// tenantFrom returns the tenant set by withTenant, or defaultTenant.
+func tenantFrom(ctx context.Context) string {
+	if tenant, ok := ctx.Value(tenantKey{}).(string); ok && tenant != "" {
+		return tenant
+	}
+	return defaultTenant
+}
+
+// resolveTenant returns the tenant a request acts for, given the API key
+// and tenant name it sent.
+func (s *server) resolveTenant(apiKey, tenant string) (string, error) {
+	if len(s.apiKeys) > 0 {
+		// Compare every key in constant time so a valid one cannot be
+		// guessed a byte at a time from response latency.
+		var match string
+		for key, t := range s.apiKeys {
+			if subtle.ConstantTimeCompare([]byte(apiKey), []byte(key)) == 1 {
+				match = t
+			}
+		}
+		if match == "" {
+			return "", errInvalidAPIKey
+		}
+		return match, nil
+	}
+	if tenant == "" {
+		return defaultTenant, nil
+	}
+	if !tenantName.MatchString(tenant) {
+		return "", errInvalidTenant
+	}
+	if tenant != defaultTenant && !s.tenants[tenant] {
+		return "", errUnknownTenant
+	}
+	return tenant, nil
+}
+
+// identifyTenant is middleware putting the tenant of a request in its
+// context, where the stores find it. fail writes the response to a
+// request naming no valid tenant, in the error format of the routes
+// the middleware guards.
+func (s *server) identifyTenant(fail func(c *gin.Context, status int, err error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, err := s.resolveTenant(c.GetHeader(headerAPIKey), c.GetHeader(headerTenant))
+		switch {
+		case errors.Is(err, errInvalidAPIKey):
+			fail(c, http.StatusUnauthorized, err)
+			c.Abort()
+			return
+		case errors.Is(err, errUnknownTenant):
+			fail(c, http.StatusNotFound, err)
+			c.Abort()
+			return
+		case err != nil:
+			fail(c, http.StatusBadRequest, err)
+			c.Abort()
+			return
+		}
+		c.Request = c.Request.WithContext(withTenant(c.Request.Context(), tenant))
+		c.Next()
+	}
+}
+
+// respondError writes err in the errorResponse envelope.
+func (s *server) respondError(c *gin.Context, status int, err error) {
+	s.respond(c, status, errorResponse{Message: err.Error()})
+}
+
+// parseAPIKeys parses ALBUM_API_KEYS, a comma-separated list of
+// key=tenant pairs.
+func parseAPIKeys(s string) (map[string]string, error) {
+	keys := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		key, tenant, ok := strings.Cut(pair, "=")
+		if !ok || key == "" || !tenantName.MatchString(tenant) {
+			return nil, fmt.Errorf("API key %q: want key=tenant", pair)
+		}
+		keys[key] = tenant
+	}
+	return keys, nil
+}
+
+// parseTenants parses ALBUM_TENANTS, a comma-separated list of the
+// tenants X-Tenant may name.
+func parseTenants(s string) (map[string]bool, error) {
+	tenants := make(map[string]bool)
+	for _, tenant := range strings.Split(s, ",") {
+		if tenant = strings.TrimSpace(tenant); tenant == "" {
+			continue
+		}
+		if !tenantName.MatchString(tenant) {
+			return nil, fmt.Errorf("tenant %q: %w", tenant, errInvalidTenant)
+		}
+		tenants[tenant] = true
+	}
+	return tenants, nil
+}
+
+// tenantQuotas caps how many albums each tenant may hold. A limit of 0
+// is no limit.
+type tenantQuotas struct {
+	fallback int            // for tenants not in limits
+	limits   map[string]int // by tenant
+}
+
+// parseQuotas parses ALBUM_TENANT_QUOTAS, a comma-separated list of
+// tenant=limit pairs where the tenant * stands for every tenant not
+// listed, e.g. "*=1000,blue-note=5000".
+func parseQuotas(s string) (tenantQuotas, error) {
+	q := tenantQuotas{limits: make(map[string]int)}
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		tenant, limit, _ := strings.Cut(pair, "=")
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 || tenant != "*" && !tenantName.MatchString(tenant) {
+			return tenantQuotas{}, fmt.Errorf("quota %q: want tenant=limit", pair)
+		}
+		if tenant == "*" {
+			q.fallback = n
+		} else {
+			q.limits[tenant] = n
+		}
+	}
+	return q, nil
+}
+
+func (q tenantQuotas) limit(tenant string) int {
+	if n, ok := q.limits[tenant]; ok {
+		return n
+	}
+	return q.fallback
+}
+
+// albumCounter is implemented by stores that can count their albums
+// without listing them.
+type albumCounter interface {
+	Count(ctx context.Context) (int, error)
+}
+
+// tenantStore is an albumStore holding one catalog per tenant, each in
+// a store of its own opened the first time the tenant is seen. Every
+// call goes to the catalog of the tenant in its context, so one
+// tenant's albums are never read or written on behalf of another.
+type tenantStore struct {
+	open   func(tenant string) (albumStore, error)
+	quotas tenantQuotas
+
+	mu       sync.Mutex
+	catalogs map[string]*catalog
+}
+
+// catalog is the store of one tenant.
+type catalog struct {
+	albumStore
+	// growing serializes the writes that add to the catalog, so two of
+	// them cannot both pass the quota check and overshoot it. With
+	// several service instances sharing a database the quota can still
+	// be overshot by one write per instance.
+	growing sync.Mutex
+}
+
+func newTenantStore(open func(tenant string) (albumStore, error), quotas tenantQuotas) *tenantStore {
+	return &tenantStore{open: open, quotas: quotas, catalogs: make(map[string]*catalog)}
+}
+
+// catalog returns the store of the tenant in ctx, opening it if needed.
+func (s *tenantStore) catalog(ctx context.Context) (*catalog, error) {
+	tenant := tenantFrom(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.catalogs[tenant]; ok {
+		return c, nil
+	}
+	store, err := s.open(tenant)
+	if err != nil {
+		return nil, fmt.Errorf("opening catalog of tenant %s: %w", tenant, err)
+	}
+	c := &catalog{albumStore: store}
+	s.catalogs[tenant] = c
+	return c, nil
+}
+
+func (s *tenantStore) List(ctx context.Context) ([]album, error) {
+	c, err := s.catalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.List(ctx)
+}
+
+func (s *tenantStore) ListByArtists(ctx context.Context, artists []string) ([]album, error) {
+	c, err := s.catalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.ListByArtists(ctx, artists)
+}
+
+func (s *tenantStore) ListByPrice(ctx context.Context, min, max float64) ([]album, error) {
+	c, err := s.catalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.ListByPrice(ctx, min, max)
+}
+
+func (s *tenantStore) Get(ctx context.Context, id string) (album, error) {
+	c, err := s.catalog(ctx)
+	if err != nil {
+		return album{}, err
+	}
+	return c.Get(ctx, id)
+}
+
+func (s *tenantStore) Add(ctx context.Context, a album) (album, error) {
+	return s.grow(ctx, func(c *catalog) (album, error) { return c.Add(ctx, a) })
+}
+
+func (s *tenantStore) Update(ctx context.Context, a album) (album, error) {
+	c, err := s.catalog(ctx)
+	if err != nil {
+		return album{}, err
+	}
+	return c.Update(ctx, a)
+}
+
+func (s *tenantStore) Delete(ctx context.Context, id string) (album, error) {
+	c, err := s.catalog(ctx)
+	if err != nil {
+		return album{}, err
+	}
+	return c.Delete(ctx, id)
+}
+
+func (s *tenantStore) Restore(ctx context.Context, id string) (album, error) {
+	return s.grow(ctx, func(c *catalog) (album, error) { return c.Restore(ctx, id) })
+}
+
+func (s *tenantStore) History(ctx context.Context, id string) ([]albumChange, error) {
+	c, err := s.catalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.History(ctx, id)
+}
+
+// grow makes a write that adds an album to the tenant's catalog, unless
+// the catalog is already at the tenant's quota.
+func (s *tenantStore) grow(ctx context.Context, write func(c *catalog) (album, error)) (album, error) {
+	c, err := s.catalog(ctx)
+	if err != nil {
+		return album{}, err
+	}
+	limit := s.quotas.limit(tenantFrom(ctx))
+	if limit == 0 {
+		return write(c)
+	}
+	c.growing.Lock()
+	defer c.growing.Unlock()
+	n, err := countAlbums(ctx, c.albumStore)
+	if err != nil {
+		return album{}, err
+	}
+	if n >= limit {
+		return album{}, errQuotaExceeded
+	}
+	return write(c)
+}
+
+func countAlbums(ctx context.Context, store albumStore) (int, error) {
+	if c, ok := store.(albumCounter); ok {
+		return c.Count(ctx)
+	}
+	albums, err := store.List(ctx)
+	return len(albums), err
+}
+
+// Close closes the catalogs that need closing, such as persistent ones.
+func (s *tenantStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for _, c := range s.catalogs {
+		if closer, ok := c.albumStore.(io.Closer); ok {
+			errs = append(errs, closer.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
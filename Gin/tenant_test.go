@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Niku19/golearn/Gin/albumpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestTenantsAreIsolated(t *testing.T) {
+	h := newHarness(t, "albums")
+	// Fill the default tenant's cache before the other tenant writes.
+	h.do(http.MethodGet, "/albums/1", "")
+	if w := h.do(http.MethodPost, "/albums", giantSteps, "X-Tenant: blue-note"); w.Code != http.StatusCreated {
+		t.Fatalf("POST as blue-note: status %d", w.Code)
+	}
+	// The same ID is free in every catalog.
+	if w := h.do(http.MethodPost, "/albums", `{"id":"1","title":"Speak No Evil","artist":"Wayne Shorter","price":24.99}`, "X-Tenant: blue-note"); w.Code != http.StatusCreated {
+		t.Fatalf("POST id 1 as blue-note: status %d", w.Code)
+	}
+
+	for tenant, want := range map[string][]string{"default": {"1", "2", "3"}, "blue-note": {"4", "1"}, "verve": nil} {
+		var list []album
+		decode(t, h.do(http.MethodGet, "/albums", "", "X-Tenant: "+tenant), &list)
+		var got []string
+		for _, a := range list {
+			got = append(got, a.ID)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("albums of %s = %v, want %v", tenant, got, want)
+		}
+	}
+	var a album
+	decode(t, h.do(http.MethodGet, "/albums/1", ""), &a)
+	if a.Title != "Blue Train" {
+		t.Errorf("default tenant's album 1 = %+v", a)
+	}
+	decode(t, h.do(http.MethodGet, "/albums/1", "", "X-Tenant: blue-note"), &a)
+	if a.Title != "Speak No Evil" {
+		t.Errorf("blue-note's album 1 = %+v; served from the other tenant's cache?", a)
+	}
+	if w := h.do(http.MethodDelete, "/albums/2", "", "X-Tenant: blue-note"); w.Code != http.StatusNotFound {
+		t.Errorf("DELETE another tenant's album: status %d, want 404", w.Code)
+	}
+
+	// Each tenant's event stream carries its own events only.
+	backlog, sub := h.server.events.subscribe("blue-note", 0)
+	h.server.events.unsubscribe(sub)
+	if len(backlog) != 2 || backlog[0].Tenant != "blue-note" {
+		t.Errorf("blue-note events = %+v, want its two adds", backlog)
+	}
+	if backlog, sub := h.server.events.subscribe(defaultTenant, 0); len(backlog) != 0 {
+		h.server.events.unsubscribe(sub)
+		t.Errorf("default tenant events = %+v, want none", backlog)
+	}
+}
+
+func TestUnknownTenants(t *testing.T) {
+	h := newHarness(t, "albums")
+	for _, req := range [][2]string{{http.MethodGet, "/albums"}, {http.MethodPost, "/albums"}, {http.MethodGet, "/webhooks/dead-letters"}} {
+		if w := h.do(req[0], req[1], giantSteps, "X-Tenant: impulse"); w.Code != http.StatusNotFound {
+			t.Errorf("%s %s as an unknown tenant: status %d, want 404", req[0], req[1], w.Code)
+		}
+	}
+	store := h.store.(*tenantStore)
+	if _, ok := store.catalogs["impulse"]; ok {
+		t.Error("a catalog was opened for an unknown tenant")
+	}
+}
+
+func TestAPIKeysDecideTenant(t *testing.T) {
+	h := newHarness(t, "albums")
+	h.server.apiKeys = map[string]string{"bn-key": "blue-note", "default-key": defaultTenant}
+
+	for _, headers := range [][]string{nil, {"X-API-Key: wrong"}, {"X-Tenant: blue-note"}} {
+		if w := h.do(http.MethodGet, "/albums", "", headers...); w.Code != http.StatusUnauthorized {
+			t.Errorf("GET with %q: status %d, want 401", headers, w.Code)
+		}
+	}
+	// The key alone decides: X-Tenant cannot reach another catalog.
+	var list []album
+	decode(t, h.do(http.MethodGet, "/albums", "", "X-API-Key: bn-key", "X-Tenant: default"), &list)
+	if len(list) != 0 {
+		t.Errorf("blue-note key listed %+v", list)
+	}
+	decode(t, h.do(http.MethodGet, "/albums", "", "X-API-Key: default-key"), &list)
+	if len(list) != 3 {
+		t.Errorf("default key listed %d albums, want 3", len(list))
+	}
+	// Routes outside the catalogs need no key.
+	if w := h.do(http.MethodGet, "/openapi.json", ""); w.Code != http.StatusOK {
+		t.Errorf("GET /openapi.json: status %d", w.Code)
+	}
+	if w := h.do(http.MethodPost, "/graphql", `{"query":"{ albums { totalCount } }"}`); w.Code != http.StatusUnauthorized ||
+		!strings.Contains(w.Body.String(), `"errors"`) {
+		t.Errorf("POST /graphql without key: %d %s, want 401 with GraphQL errors", w.Code, w.Body)
+	}
+}
+
+func TestTenantQuotas(t *testing.T) {
+	h := newHarness(t, "albums")
+	store := h.store.(*tenantStore)
+	store.quotas = tenantQuotas{fallback: 1, limits: map[string]int{defaultTenant: 0}}
+	blueNote := withTenant(context.Background(), "blue-note")
+
+	if _, err := store.Add(blueNote, album{ID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Add(blueNote, album{ID: "2"}); !errors.Is(err, errQuotaExceeded) {
+		t.Errorf("Add past the quota = %v", err)
+	}
+	// Deleted albums do not count, but restoring one must fit again.
+	store.Delete(blueNote, "1")
+	if _, err := store.Add(blueNote, album{ID: "2"}); err != nil {
+		t.Errorf("Add after Delete = %v", err)
+	}
+	if _, err := store.Restore(blueNote, "1"); !errors.Is(err, errQuotaExceeded) {
+		t.Errorf("Restore past the quota = %v", err)
+	}
+	// A limit of 0 is no limit.
+	for i := range 3 {
+		if _, err := store.Add(context.Background(), album{ID: fmt.Sprint(4 + i)}); err != nil {
+			t.Errorf("Add to the unlimited default tenant: %v", err)
+		}
+	}
+
+	if w := h.do(http.MethodPost, "/albums", `{"id":"3"}`, "X-Tenant: blue-note"); w.Code != http.StatusForbidden {
+		t.Errorf("POST past the quota: status %d, want 403", w.Code)
+	}
+}
+
+func TestParseTenantConfig(t *testing.T) {
+	keys, err := parseAPIKeys(" k1=blue-note, k2=verve,")
+	if err != nil || len(keys) != 2 || keys["k1"] != "blue-note" || keys["k2"] != "verve" {
+		t.Errorf("parseAPIKeys = %v, %v", keys, err)
+	}
+	tenants, err := parseTenants(" blue-note, verve,")
+	if err != nil || len(tenants) != 2 || !tenants["blue-note"] || !tenants["verve"] {
+		t.Errorf("parseTenants = %v, %v", tenants, err)
+	}
+	if _, err := parseTenants("Blue Note"); err == nil {
+		t.Error("parseTenants(\"Blue Note\") succeeded")
+	}
+	q, err := parseQuotas("*=1000,blue-note=5000")
+	if err != nil || q.limit("blue-note") != 5000 || q.limit("verve") != 1000 {
+		t.Errorf("parseQuotas = %+v, %v", q, err)
+	}
+	for _, bad := range []string{"k1", "=blue-note", "k1=Blue Note"} {
+		if _, err := parseAPIKeys(bad); err == nil {
+			t.Errorf("parseAPIKeys(%q) succeeded", bad)
+		}
+	}
+	for _, bad := range []string{"blue-note", "blue-note=-1", "blue-note=lots", "../x=1"} {
+		if _, err := parseQuotas(bad); err == nil {
+			t.Errorf("parseQuotas(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestGRPCTenants(t *testing.T) {
+	h := newHarness(t, "albums")
+	c := dialGRPC(t, h, "", nil)
+	blueNote := metadata.AppendToOutgoingContext(context.Background(), "x-tenant", "blue-note")
+
+	if _, err := c.CreateAlbum(blueNote, &albumpb.CreateAlbumRequest{Album: &albumpb.Album{Id: "4", Title: "Giant Steps"}}); err != nil {
+		t.Fatal(err)
+	}
+	if albums, err := listAll(blueNote, c); err != nil || len(albums) != 1 {
+		t.Errorf("blue-note albums = %+v, %v", albums, err)
+	}
+	if albums, err := listAll(context.Background(), c); err != nil || len(albums) != 3 {
+		t.Errorf("default albums = %d, %v; want 3", len(albums), err)
+	}
+
+	bad := metadata.AppendToOutgoingContext(context.Background(), "x-tenant", "Blue Note")
+	if _, err := c.GetAlbum(bad, &albumpb.GetAlbumRequest{Id: "1"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("GetAlbum with a bad tenant = %v", err)
+	}
+	unknown := metadata.AppendToOutgoingContext(context.Background(), "x-tenant", "impulse")
+	if _, err := c.GetAlbum(unknown, &albumpb.GetAlbumRequest{Id: "1"}); status.Code(err) != codes.NotFound {
+		t.Errorf("GetAlbum as an unknown tenant = %v", err)
+	}
+	h.server.apiKeys = map[string]string{"bn-key": "blue-note"}
+	if _, err := listAll(context.Background(), c); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("ListAlbums without a key = %v", err)
+	}
+	keyed := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "bn-key")
+	if a, err := c.GetAlbum(keyed, &albumpb.GetAlbumRequest{Id: "4"}); err != nil || a.GetTitle() != "Giant Steps" {
+		t.Errorf("GetAlbum with blue-note's key = %v, %v", a, err)
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// persistentStore keeps albums in a memoryStore and makes every write
+// durable before it is applied: the write is appended to a write-ahead
+// log and fsynced first. Every snapshotEvery writes the whole catalog
+// is written to a snapshot and the log starts over, so the log never
+// grows without bound and startup replays only the writes since the
+// last snapshot.
+//
+// The data directory holds two files, both made of checksummed records:
+//
+//	snapshot  one record: the catalog, deleted albums and history, and
+//	          the sequence number of the last write it includes
+//	wal       one record per write since
+//
+// Records are a 4-byte length and a 4-byte CRC-32C of the payload, both
+// big-endian, followed by the JSON payload.
+type persistentStore struct {
+	*memoryStore // serves reads; writes go through the methods below
+
+	dir           string
+	snapshotEvery int
+
+	mu  sync.Mutex // serializes writes so the log order is the apply order
+	wal *os.File
+	// walSize is the length of the log up to its last complete record.
+	walSize int64
+	seq     uint64 // sequence number of the last write logged
+	// logged is the number of records in the log since the snapshot.
+	logged int
+	// snapshotBackoff is how many more writes than snapshotEvery to wait
+	// for before the next snapshot, after one has failed. It grows by
+	// snapshotEvery with each failure, so a bad disk costs a snapshot
+	// attempt every snapshotEvery writes rather than on every write.
+	snapshotBackoff int
+	// logf reports snapshots that fail.
+	logf func(format string, args ...any)
+	// broken is set once a failed append could not be cut off the log.
+	// Every later write is refused with it, rather than being appended
+	// after the part of the record that made it.
+	broken error
+}
+
+// errCorrupt is returned when the snapshot or a record in the middle of
+// the log fails its checksum. A torn record at the end of the log is
+// not corruption: it is a write that crashed before it was acknowledged
+// and is dropped.
+var errCorrupt = errors.New("album data is corrupt")
+
+const (
+	snapshotFile = "snapshot"
+	walFile      = "wal"
+	// recordHeaderSize is the length and checksum before each payload.
+	recordHeaderSize = 8
+	// maxRecordSize bounds the length a record header may claim, so a
+	// corrupt one is not taken as a reason to allocate gigabytes.
+	maxRecordSize = 64 << 20
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// walRecord is one logged write. Actor and Time are logged rather than
+// taken again on replay, so the history reads the same after a restart.
+type walRecord struct {
+	Seq   uint64    `json:"seq"`
+	Op    string    `json:"op"` // opAdd, opUpdate, opDelete or opRestore
+	Album album     `json:"album"`
+	Actor string    `json:"actor,omitempty"`
+	Time  time.Time `json:"time,omitzero"`
+}
+
+type snapshot struct {
+	// Seq is the last write the snapshot includes; log records up to
+	// and including it are already applied.
+	Seq uint64 `json:"seq"`
+	memoryState
+}
+
+// openPersistentStore loads the albums kept in dir, creating it holding
+// seed if it does not exist yet.
+func openPersistentStore(dir string, seed []album) (*persistentStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &persistentStore{dir: dir, snapshotEvery: 1000, logf: log.Printf}
+
+	snap, err := readSnapshot(filepath.Join(dir, snapshotFile))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		// A new data directory. Write the seed out straight away so the
+		// log always has a snapshot to apply to.
+		s.memoryStore = newMemoryStore(seed)
+		if err := s.writeSnapshot(); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	default:
+		s.memoryStore = newMemoryStoreFromState(snap.memoryState)
+		s.seq = snap.Seq
+	}
+
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// replay applies the log records written after the snapshot and opens
+// the log for appending.
+func (s *persistentStore) replay() error {
+	f, err := os.OpenFile(filepath.Join(s.dir, walFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		payload, n, err := readRecord(r)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, errCorrupt) && offset+n == info.Size() {
+			// A write that crashed half way. It was never acknowledged,
+			// so drop it; the next write goes where it started.
+			if err := f.Truncate(offset); err != nil {
+				f.Close()
+				return err
+			}
+			break
+		}
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("%s at offset %d: %w", walFile, offset, err)
+		}
+		var rec walRecord
+		if err := json.Unmarshal(payload, &rec); err != nil {
+			f.Close()
+			return fmt.Errorf("%s at offset %d: %w: %v", walFile, offset, errCorrupt, err)
+		}
+		offset += n
+		// Records the snapshot already includes are left over from a
+		// crash between writing the snapshot and truncating the log.
+		if rec.Seq <= s.seq {
+			continue
+		}
+		s.apply(rec)
+		s.seq = rec.Seq
+		s.logged++
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	s.wal = f
+	s.walSize = offset
+	return nil
+}
+
+// apply makes a logged write to the in-memory catalog. Writes are only
+// logged once they are known to succeed, so the error is always nil.
+func (s *persistentStore) apply(rec walRecord) album {
+	a, _ := s.memoryStore.write(rec.Op, rec.Album, rec.Actor, rec.Time)
+	return a
+}
+
+func (s *persistentStore) Add(ctx context.Context, a album) (album, error) {
+	return s.log(ctx, opAdd, a)
+}
+
+func (s *persistentStore) Update(ctx context.Context, a album) (album, error) {
+	return s.log(ctx, opUpdate, a)
+}
+
+func (s *persistentStore) Delete(ctx context.Context, id string) (album, error) {
+	return s.log(ctx, opDelete, album{ID: id})
+}
+
+func (s *persistentStore) Restore(ctx context.Context, id string) (album, error) {
+	return s.log(ctx, opRestore, album{ID: id})
+}
+
+// log checks that a write will succeed, appends it to the log, syncs
+// it to disk and only then applies it, so an acknowledged write
+// survives a crash.
+func (s *persistentStore) log(ctx context.Context, op string, a album) (album, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.broken != nil {
+		return album{}, s.broken
+	}
+	if err := s.memoryStore.check(op, a.ID); err != nil {
+		return album{}, err
+	}
+	rec := walRecord{Seq: s.seq + 1, Op: op, Album: a, Actor: actorFrom(ctx), Time: s.memoryStore.now()}
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return album{}, err
+	}
+	record := frame(payload)
+	_, err = s.wal.Write(record)
+	if err == nil {
+		err = s.wal.Sync()
+	}
+	if err != nil {
+		err = fmt.Errorf("appending to %s: %w", walFile, err)
+		// Cut off whatever part of the record made it, or the next
+		// record would follow garbage and the log would read as
+		// corrupt.
+		terr := s.wal.Truncate(s.walSize)
+		if terr == nil {
+			_, terr = s.wal.Seek(s.walSize, io.SeekStart)
+		}
+		if terr != nil {
+			s.broken = fmt.Errorf("%s may end in a partial record, refusing writes until restart: %w", walFile, terr)
+			return album{}, errors.Join(err, s.broken)
+		}
+		return album{}, err
+	}
+	s.walSize += int64(len(record))
+	a = s.apply(rec)
+	s.seq = rec.Seq
+	s.logged++
+
+	if s.logged >= s.snapshotEvery+s.snapshotBackoff {
+		// The write is already durable in the log, so if the snapshot
+		// fails the log just grows until the next one succeeds.
+		if err := s.compact(); err != nil {
+			s.snapshotBackoff += s.snapshotEvery
+			s.logf("snapshot of %s failed with %d writes in the log, trying again in %d writes: %v", s.dir, s.logged, s.snapshotEvery, err)
+		}
+	}
+	return a, nil
+}
+
+// compact writes a snapshot and empties the log. The caller must hold
+// s.mu.
+func (s *persistentStore) compact() error {
+	if err := s.writeSnapshot(); err != nil {
+		return err
+	}
+	// A crash here leaves records in the log that the snapshot already
+	// includes; replay skips them by sequence number.
+	if err := s.wal.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := s.wal.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := s.wal.Sync(); err != nil {
+		return err
+	}
+	s.walSize = 0
+	s.logged = 0
+	s.snapshotBackoff = 0
+	return nil
+}
+
+// writeSnapshot writes the catalog to a temporary file, syncs it and
+// renames it over the old snapshot, so a crash leaves either the old
+// snapshot or the new one, never half of one.
+func (s *persistentStore) writeSnapshot() error {
+	payload, err := json.Marshal(snapshot{Seq: s.seq, memoryState: s.memoryStore.state()})
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(s.dir, snapshotFile+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+	if _, err := tmp.Write(frame(payload)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, snapshotFile)); err != nil {
+		return err
+	}
+	return syncDir(s.dir)
+}
+
+// Close writes a final snapshot and closes the log.
+func (s *persistentStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.compact()
+	if cerr := s.wal.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func readSnapshot(path string) (snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return snapshot{}, err
+	}
+	defer f.Close()
+	payload, _, err := readRecord(bufio.NewReader(f))
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		err = errCorrupt
+	}
+	if err != nil {
+		return snapshot{}, fmt.Errorf("%s: %w", snapshotFile, err)
+	}
+	var snap snapshot
+	if err := json.Unmarshal(payload, &snap); err != nil {
+		return snapshot{}, fmt.Errorf("%s: %w: %v", snapshotFile, errCorrupt, err)
+	}
+	return snap, nil
+}
+
+// frame prefixes payload with its length and checksum.
+func frame(payload []byte) []byte {
+	b := make([]byte, recordHeaderSize, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(b[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(b[4:8], crc32.Checksum(payload, crcTable))
+	return append(b, payload...)
+}
+
+// readRecord reads one framed record and returns its payload and how
+// many bytes it took up. It returns io.EOF at a clean end of input,
+// io.ErrUnexpectedEOF for a record cut short, and errCorrupt when the
+// checksum does not match.
+func readRecord(r io.Reader) ([]byte, int64, error) {
+	var header [recordHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, 0, err
+	}
+	size := binary.BigEndian.Uint32(header[0:4])
+	if size > maxRecordSize {
+		return nil, recordHeaderSize + int64(size), errCorrupt
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+	n := int64(recordHeaderSize) + int64(size)
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, n, errCorrupt
+	}
+	return payload, n, nil
+}
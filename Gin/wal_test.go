@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+// openTestStore opens a persistent store in dir, seeded with the albums
+// fixture if dir is new.
+func openTestStore(t *testing.T, dir string) *persistentStore {
+	t.Helper()
+	s, err := openPersistentStore(dir, loadFixture(t, "albums"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.wal.Close() })
+	return s
+}
+
+// listed returns the IDs of the albums in s, in order.
+func listed(t *testing.T, s albumStore) []string {
+	t.Helper()
+	albums, err := s.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, a := range albums {
+		ids = append(ids, a.ID)
+	}
+	return ids
+}
+
+// write makes the same three writes in every test: add 4, update 2,
+// delete 1.
+func write(t *testing.T, s albumStore) {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := s.Add(ctx, album{ID: "4", Title: "Giant Steps", Artist: "John Coltrane", Price: 63.99}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Update(ctx, album{ID: "2", Title: "Jeru", Artist: "Gerry Mulligan", Price: 19.99}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Delete(ctx, "1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPersistentStoreSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	write(t, openTestStore(t, dir)) // never closed, as in a crash
+
+	s := openTestStore(t, dir)
+	if got, want := listed(t, s), []string{"2", "3", "4"}; !slices.Equal(got, want) {
+		t.Errorf("after restart: %v, want %v", got, want)
+	}
+	if a, _ := s.Get(context.Background(), "2"); a.Price != 19.99 {
+		t.Errorf("update was lost: %+v", a)
+	}
+	if s.seq != 3 || s.logged != 3 {
+		t.Errorf("seq %d, logged %d; want 3 replayed writes", s.seq, s.logged)
+	}
+
+	// Failed writes are not logged.
+	if _, err := s.Add(context.Background(), album{ID: "3"}); !errors.Is(err, errAlbumExists) {
+		t.Errorf("Add(3) = %v", err)
+	}
+	if _, err := s.Delete(context.Background(), "1"); !errors.Is(err, errAlbumNotFound) {
+		t.Errorf("Delete(1) = %v", err)
+	}
+	if s.seq != 3 {
+		t.Errorf("failed writes were logged: seq %d", s.seq)
+	}
+}
+
+func TestPersistentStoreKeepsHistory(t *testing.T) {
+	for _, snapshotEvery := range []int{1000, 2} {
+		t.Run(fmt.Sprint("snapshotEvery=", snapshotEvery), func(t *testing.T) {
+			dir := t.TempDir()
+			s := openTestStore(t, dir)
+			s.snapshotEvery = snapshotEvery
+			s.now = func() time.Time { return testTime }
+			write(t, s)
+			if _, err := s.Restore(withActor(context.Background(), "alice"), "1"); err != nil {
+				t.Fatal(err)
+			}
+			want, _ := s.History(context.Background(), "1")
+
+			s = openTestStore(t, dir)
+			got, err := s.History(context.Background(), "1")
+			if err != nil || !reflect.DeepEqual(got, want) {
+				t.Errorf("History after restart = %+v, %v; want %+v", got, err, want)
+			}
+			if len(got) != 2 || got[1].Actor != "alice" {
+				t.Errorf("History = %+v, want a delete and alice's restore", got)
+			}
+			// Change IDs carry on where they left off.
+			s.Update(context.Background(), album{ID: "1", Title: "Blue Train", Artist: "John Coltrane", Price: 49.99})
+			if got, _ := s.History(context.Background(), "1"); got[2].ID != 5 {
+				t.Errorf("next change ID = %d, want 5", got[2].ID)
+			}
+		})
+	}
+}
+
+func TestPersistentStoreCompacts(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestStore(t, dir)
+	s.snapshotEvery = 2
+	write(t, s)
+
+	// The second write triggered a snapshot, leaving only the third in
+	// the log.
+	if s.logged != 1 {
+		t.Errorf("%d records in the log, want 1", s.logged)
+	}
+	snap, err := readSnapshot(filepath.Join(dir, snapshotFile))
+	if err != nil || snap.Seq != 2 || len(snap.Albums) != 4 {
+		t.Errorf("snapshot = %+v, %v", snap, err)
+	}
+	if got, want := listed(t, openTestStore(t, dir)), []string{"2", "3", "4"}; !slices.Equal(got, want) {
+		t.Errorf("after restart: %v, want %v", got, want)
+	}
+}
+
+func TestPersistentStoreSkipsRecordsInSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestStore(t, dir)
+	write(t, s)
+	// Simulate a crash after the snapshot was renamed into place but
+	// before the log was truncated.
+	if err := s.writeSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(filepath.Join(dir, walFile)); info.Size() == 0 {
+		t.Fatal("log is empty; nothing to skip")
+	}
+
+	if got, want := listed(t, openTestStore(t, dir)), []string{"2", "3", "4"}; !slices.Equal(got, want) {
+		t.Errorf("after restart: %v, want %v", got, want)
+	}
+}
+
+func TestPersistentStoreDropsTornWrite(t *testing.T) {
+	for name, tear := range map[string]func(record []byte) []byte{
+		"header cut short":  func(record []byte) []byte { return record[:5] },
+		"payload cut short": func(record []byte) []byte { return record[:len(record)-3] },
+		"payload garbled":   func(record []byte) []byte { record[len(record)-2] ^= 0xff; return record },
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			write(t, openTestStore(t, dir))
+
+			f, _ := os.OpenFile(filepath.Join(dir, walFile), os.O_APPEND|os.O_WRONLY, 0)
+			f.Write(tear(frame([]byte(`{"seq":4,"op":"delete","album":{"id":"2"}}`))))
+			f.Close()
+
+			s := openTestStore(t, dir)
+			if got, want := listed(t, s), []string{"2", "3", "4"}; !slices.Equal(got, want) {
+				t.Errorf("after restart: %v, want %v", got, want)
+			}
+			// The torn record is gone, so new writes land after the last
+			// good one and the log reads back cleanly.
+			s.Delete(context.Background(), "3")
+			if got, want := listed(t, openTestStore(t, dir)), []string{"2", "4"}; !slices.Equal(got, want) {
+				t.Errorf("after write and restart: %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestPersistentStoreDetectsCorruption(t *testing.T) {
+	corrupt := func(t *testing.T, path string, offset int) {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data[offset] ^= 0xff
+		os.WriteFile(path, data, 0o644)
+	}
+
+	t.Run("log", func(t *testing.T) {
+		dir := t.TempDir()
+		write(t, openTestStore(t, dir))
+		// A damaged record followed by good ones is not a torn write.
+		corrupt(t, filepath.Join(dir, walFile), recordHeaderSize+1)
+		if _, err := openPersistentStore(dir, nil); !errors.Is(err, errCorrupt) {
+			t.Errorf("open = %v, want errCorrupt", err)
+		}
+	})
+	t.Run("snapshot", func(t *testing.T) {
+		dir := t.TempDir()
+		openTestStore(t, dir)
+		corrupt(t, filepath.Join(dir, snapshotFile), recordHeaderSize+1)
+		if _, err := openPersistentStore(dir, nil); !errors.Is(err, errCorrupt) {
+			t.Errorf("open = %v, want errCorrupt", err)
+		}
+	})
+}
+
+func TestPersistentStoreRefusesWritesAfterFailedCleanup(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestStore(t, dir)
+	// With the log closed, the append fails and so does cutting it off.
+	s.wal.Close()
+	ctx := context.Background()
+	giantSteps := album{ID: "4", Title: "Giant Steps", Artist: "John Coltrane", Price: 63.99}
+	if _, err := s.Add(ctx, giantSteps); err == nil || s.broken == nil {
+		t.Fatalf("Add = %v with the log closed, want the store broken", err)
+	}
+
+	// Even once the log could be written again, nothing is appended
+	// after what the failed write may have left.
+	wal, err := os.OpenFile(filepath.Join(dir, walFile), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.wal = wal
+	if _, err := s.Add(ctx, giantSteps); !errors.Is(err, s.broken) {
+		t.Errorf("Add = %v after the failed cleanup, want %v", err, s.broken)
+	}
+	if info, _ := wal.Stat(); info.Size() != 0 {
+		t.Errorf("log holds %d bytes, want none", info.Size())
+	}
+	if got, want := listed(t, s), []string{"1", "2", "3"}; !slices.Equal(got, want) {
+		t.Errorf("albums %v, want %v", got, want)
+	}
+}
+
+func TestPersistentStoreBacksOffFailedSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestStore(t, dir)
+	s.snapshotEvery = 2
+	var logs []string
+	s.logf = func(format string, args ...any) { logs = append(logs, fmt.Sprintf(format, args...)) }
+	// Snapshots are written next to the old one, so with the directory
+	// gone they fail; the log still works.
+	s.dir = filepath.Join(dir, "gone")
+
+	ctx := context.Background()
+	for i := range 5 {
+		if _, err := s.Update(ctx, album{ID: "2", Title: "Jeru", Artist: "Gerry Mulligan", Price: float64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Snapshots were tried after the second and the fourth write only.
+	if len(logs) != 2 || s.logged != 5 {
+		t.Fatalf("%d records in the log, logged %q; want 5 and two failures", s.logged, logs)
+	}
+
+	// Once the disk recovers, the next try empties the log.
+	s.dir = dir
+	s.Update(ctx, album{ID: "2", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99})
+	if s.logged != 0 || s.snapshotBackoff != 0 {
+		t.Errorf("after a good snapshot: %d records in the log, backoff %d", s.logged, s.snapshotBackoff)
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Headers sent with every webhook delivery.
+const (
+	headerWebhookEvent     = "X-Album-Event"
+	headerWebhookDelivery  = "X-Album-Delivery"
+	headerWebhookTimestamp = "X-Album-Timestamp"
+	headerWebhookSignature = "X-Album-Signature"
+)
+
+// webhookEndpoint is a receiver of album events. Deliveries are signed
+// with Secret so the receiver can check they came from us.
+type webhookEndpoint struct {
+	URL    string
+	Secret string
+}
+
+// parseWebhookEndpoints reads endpoints from a comma-separated list of
+// URLs, all signed with secret, as given in WEBHOOK_URLS.
+func parseWebhookEndpoints(urls, secret string) []webhookEndpoint {
+	var endpoints []webhookEndpoint
+	for _, u := range strings.Split(urls, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			endpoints = append(endpoints, webhookEndpoint{URL: u, Secret: secret})
+		}
+	}
+	return endpoints
+}
+
+// deadLetter is an event that could not be delivered to an endpoint.
+// Tenants read their dead letters, so they name the endpoint by its
+// position in WEBHOOK_URLS, counting from 0, and say why delivery failed
+// without the receiver's URL: both are the operator's to know, and the
+// operator finds them in the log.
+type deadLetter struct {
+	Endpoint int        `json:"endpoint" xml:"endpoint"`
+	Event    albumEvent `json:"event" xml:"event"`
+	Attempts int        `json:"attempts" xml:"attempts"`
+	Error    string     `json:"error" xml:"error"`
+	Time     time.Time  `json:"time" xml:"time"`
+}
+
+// deadLetterList wraps the dead letters for XML, as albumList does a
+// list of albums.
+type deadLetterList struct {
+	XMLName     xml.Name     `xml:"dead_letters"`
+	DeadLetters []deadLetter `xml:"dead_letter"`
+}
+
+// webhookDispatcher posts album events to webhook endpoints. Each
+// endpoint gets its own goroutine so a slow receiver only delays its
+// own deliveries, and events reach each receiver in order. Endpoints
+// are configured by the operator and receive the events of every
+// tenant, told apart by the tenant field.
+type webhookDispatcher struct {
+	endpoints []webhookEndpoint
+	client    *http.Client
+
+	// maxAttempts is the number of tries before an event is moved to
+	// the dead-letter list.
+	maxAttempts int
+	// backoff is the delay before the first retry. It doubles on every
+	// further retry up to maxBackoff, and a random jitter of up to half
+	// the delay is added.
+	backoff    time.Duration
+	maxBackoff time.Duration
+	// maxDeadLetters is how many dead letters are kept. Past it the
+	// oldest is dropped for each new one, and counted in metrics.
+	maxDeadLetters int
+	metrics        *metrics
+	// logf reports each dead letter in full, receiver URL included.
+	logf func(format string, args ...any)
+
+	mu          sync.Mutex
+	deadLetters []deadLetter
+	wg          sync.WaitGroup
+}
+
+func newWebhookDispatcher(endpoints []webhookEndpoint, m *metrics) *webhookDispatcher {
+	return &webhookDispatcher{
+		endpoints:      endpoints,
+		client:         &http.Client{Timeout: 10 * time.Second},
+		maxAttempts:    5,
+		backoff:        500 * time.Millisecond,
+		maxBackoff:     30 * time.Second,
+		maxDeadLetters: 1000,
+		metrics:        m,
+		logf:           log.Printf,
+	}
+}
+
+// start delivers every event published on bus from now on until ctx
+// is cancelled. wait blocks until the workers have stopped.
+func (d *webhookDispatcher) start(ctx context.Context, bus *eventBus) {
+	// Start every worker from the same point so none of them misses an
+	// event published while the others are starting.
+	from := bus.lastID()
+	for i := range d.endpoints {
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			d.run(ctx, bus, i, from)
+		}()
+	}
+}
+
+func (d *webhookDispatcher) wait() { d.wg.Wait() }
+
+// run delivers events after the event with ID after to endpoint i. If
+// the worker falls behind and the bus drops its subscription, it
+// resubscribes from the last event it handled.
+func (d *webhookDispatcher) run(ctx context.Context, bus *eventBus, i int, after uint64) {
+	for ctx.Err() == nil {
+		backlog, sub := bus.subscribe("", after)
+		for _, ev := range backlog {
+			d.deliver(ctx, i, ev)
+			after = ev.ID
+		}
+	stream:
+		for {
+			select {
+			case <-ctx.Done():
+				break stream
+			case ev, ok := <-sub.C:
+				if !ok {
+					break stream
+				}
+				d.deliver(ctx, i, ev)
+				after = ev.ID
+			}
+		}
+		bus.unsubscribe(sub)
+	}
+}
+
+// deliver posts ev to endpoint i, retrying with exponential backoff, and adds it
+// to the dead-letter list when every attempt failed or the receiver
+// rejected it outright.
+func (d *webhookDispatcher) deliver(ctx context.Context, i int, ev albumEvent) {
+	ep := d.endpoints[i]
+	body, err := json.Marshal(ev)
+	if err != nil {
+		d.bury(i, ev, 0, err)
+		return
+	}
+
+	delay := d.backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := d.post(ctx, ep, ev, body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= d.maxAttempts || ctx.Err() != nil {
+			d.bury(i, ev, attempt, err)
+			return
+		}
+
+		wait := delay + time.Duration(rand.Int64N(int64(delay)/2+1))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			d.bury(i, ev, attempt, ctx.Err())
+			return
+		}
+		delay = min(delay*2, d.maxBackoff)
+	}
+}
+
+// post makes one delivery attempt and reports whether a failure is
+// worth retrying: network errors, 429 and 5xx are; other statuses mean
+// the receiver will never accept this event.
+func (d *webhookDispatcher) post(ctx context.Context, ep webhookEndpoint, ev albumEvent, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "album-service-webhooks")
+	req.Header.Set(headerWebhookEvent, ev.Type)
+	req.Header.Set(headerWebhookDelivery, strconv.FormatUint(ev.ID, 10))
+	req.Header.Set(headerWebhookTimestamp, timestamp)
+	req.Header.Set(headerWebhookSignature, "sha256="+signWebhook(ep.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, &webhookStatusError{URL: ep.URL, Status: resp.Status}
+	default:
+		return false, &webhookStatusError{URL: ep.URL, Status: resp.Status}
+	}
+}
+
+// webhookStatusError is a delivery the receiver answered with a status
+// other than 2xx.
+type webhookStatusError struct {
+	URL    string
+	Status string
+}
+
+func (e *webhookStatusError) Error() string {
+	return fmt.Sprintf("webhook %s: %s", e.URL, e.Status)
+}
+
+// deliveryFailure says why a delivery failed, from its error, in terms
+// fit for tenants: the receiver's status, or what went wrong reaching
+// it, but not where it is.
+func deliveryFailure(err error) string {
+	var se *webhookStatusError
+	var ue *url.Error
+	switch {
+	case errors.As(err, &se):
+		return "receiver responded " + se.Status
+	case errors.Is(err, context.Canceled):
+		return "delivery stopped by shutdown"
+	case errors.As(err, &ue) && ue.Timeout():
+		return "receiver timed out"
+	case errors.As(err, &ue):
+		return "receiver unreachable"
+	}
+	return "delivery failed"
+}
+
+// signWebhook returns the hex HMAC-SHA256 of "timestamp.body" keyed
+// with secret. Including the timestamp lets receivers reject replays.
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// bury adds ev to the dead letters of endpoint i, after attempts tries,
+// the last failing with err.
+func (d *webhookDispatcher) bury(i int, ev albumEvent, attempts int, err error) {
+	d.logf("webhook %d (%s): event %d dead after %d attempts: %v", i, d.endpoints[i].URL, ev.ID, attempts, err)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.deadLetters) >= d.maxDeadLetters {
+		n := len(d.deadLetters) - d.maxDeadLetters + 1
+		for _, l := range d.deadLetters[:n] {
+			d.metrics.deadLettersDropped.inc(l.Event.Tenant)
+		}
+		d.deadLetters = d.deadLetters[n:]
+	}
+	d.deadLetters = append(d.deadLetters, deadLetter{
+		Endpoint: i,
+		Event:    ev,
+		Attempts: attempts,
+		Error:    deliveryFailure(err),
+		Time:     time.Now().UTC(),
+	})
+}
+
+// dead returns the dead letters of events of tenant, or of every tenant
+// if it is empty, oldest first.
+func (d *webhookDispatcher) dead(tenant string) []deadLetter {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dead := []deadLetter{}
+	for _, l := range d.deadLetters {
+		if tenant == "" || l.Event.Tenant == tenant {
+			dead = append(dead, l)
+		}
+	}
+	return dead
+}
+
+// getDeadLetters responds with the webhook deliveries of the tenant's
+// events that failed.
+func (s *server) getDeadLetters(c *gin.Context) {
+	s.respond(c, http.StatusOK, s.webhooks.dead(tenantFrom(c.Request.Context())))
+}
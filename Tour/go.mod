@@ -1,3 +1,7 @@
 module github.com/Niku19/golearn/Tour
 
 go 1.24.5
+
+require golang.org/x/net v0.48.0
+
+require golang.org/x/text v0.32.0 // indirect
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"iter"
+	"time"
+)
+
+// defaultWorkers is how many pages a Crawler fetches at once unless
+// told otherwise.
+const defaultWorkers = 4
+
+// Crawler crawls with a fixed pool of workers instead of a goroutine
+// per link. One coordinator goroutine owns the frontier, the queue of
+// URLs found but not yet fetched, and the set of URLs seen, so no lock
+// is needed to fetch each URL exactly once.
+//
+// Work is handed to a worker only when it is idle, and a worker hands
+// back the links it found only when the coordinator is ready for them,
+// so however many links a page has, no more than Workers fetches run at
+// once and discovery never runs ahead of fetching. The frontier still
+// grows with the site, but never holds more URLs than have been seen.
+type Crawler struct {
+	Fetcher Fetcher
+	// Workers is how many pages are fetched at once; 0 means
+	// defaultWorkers.
+	Workers int
+	// Scope, if set, is the links the crawl follows; the first page is
+	// fetched whatever it says.
+	Scope *Scope
+	// Seeds, if set, are pages to crawl along with the first, such as
+	// those a sitemap lists. Those out of Scope are left out.
+	Seeds []Seed
+	// State, if set, is where the crawl keeps its frontier and the pages
+	// it has seen, and resumes from.
+	State *State
+	// OnPage, if set, is called with every page as its fetch finishes.
+	// Calls come from one goroutine, one at a time, and a slow OnPage
+	// slows the crawl down rather than letting results pile up.
+	OnPage func(PageResult)
+}
+
+// PageResult is what a crawl found at one URL.
+type PageResult struct {
+	// URL is the page's URL as NormalizeURL writes it, as are Parent
+	// and Links.
+	URL string
+	// Depth is how many links away from the first page the page is,
+	// and Parent the page that links to it on that path. The first
+	// page has depth 0 and no parent, and a seed depth 0 and its
+	// Source as parent.
+	Depth  int
+	Parent string
+	Body   string
+	// Links are the links on the page, each once, whether the crawl
+	// follows them or not.
+	Links []string
+	// Err is why the fetch failed. If the crawl was stopped during the
+	// fetch it is usually the context's error, and the page is counted
+	// as abandoned rather than failed, with Abandoned set.
+	Err       error
+	Abandoned bool
+	// Started is when the fetch began and Duration how long it took.
+	Started  time.Time
+	Duration time.Duration
+}
+
+// Seed is a page to start crawling from, and where it was found.
+type Seed struct {
+	URL, Source string
+}
+
+// task is a URL to fetch.
+type task struct {
+	url    string
+	key    string
+	parent string
+	depth  int
+}
+
+// Summary is what a crawl got through.
+type Summary struct {
+	// Fetched and Failed count the pages whose fetch finished, with or
+	// without an error.
+	Fetched, Failed int
+	// Abandoned lists the URLs left unfetched when the crawl was
+	// stopped: those still queued and those whose fetch was cut short.
+	Abandoned []string
+	// Err is why the crawl was stopped, or nil if it ran to the end: ctx
+	// ending, or failing to save its State.
+	Err error
+}
+
+// Crawl fetches the pages reachable from url through at most depth-1
+// links, each once, and returns once all of them have been fetched or
+// ctx is done. Once ctx is done no more fetches are started; Crawl
+// waits for those running to give up, which a Fetcher honouring ctx
+// does promptly, and reports what was left.
+//
+// With a State, Crawl starts with the pages it had queued, and does not
+// fetch again those it had fetched. Pages abandoned, and pages that
+// failed, which may only have failed for now, are left queued in the
+// State, for the next Crawl.
+func (c *Crawler) Crawl(ctx context.Context, url string, depth int) Summary {
+	workers := c.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+
+	// Both channels are unbuffered: that is what makes workers wait for
+	// the coordinator and the coordinator for workers.
+	tasks := make(chan *task)
+	results := make(chan PageResult)
+	for range workers {
+		go func() {
+			for t := range tasks {
+				r := PageResult{URL: t.url, Depth: t.depth, Parent: t.parent, Started: time.Now()}
+				r.Body, r.Links, r.Err = c.Fetcher.Fetch(ctx, t.url)
+				r.Links = normalizeLinks(r.Links)
+				r.Duration = time.Since(r.Started)
+				results <- r
+			}
+		}()
+	}
+	defer close(tasks)
+
+	var sum Summary
+	f := frontier{maxDepth: depth}
+	push := func(url, parent string, depth int) {
+		if t := f.push(url, parent, depth); t != nil && c.State != nil {
+			c.State.queue(t)
+		}
+	}
+	// save records that a page is done with; failing to is as bad as
+	// ctx ending, since the State would no longer match the crawl.
+	save := func(url string) {
+		if c.State == nil {
+			return
+		}
+		if err := c.State.finish(url); err != nil && sum.Err == nil {
+			sum.Err = err
+		}
+	}
+	if c.State != nil {
+		c.State.restore(&f)
+	}
+	push(canonical(url), "", 0)
+	for _, s := range c.Seeds {
+		if u := canonical(s.URL); c.Scope.Contains(u) {
+			push(u, s.Source, 0)
+		}
+	}
+	if c.State != nil {
+		if err := c.State.flush(); err != nil {
+			sum.Err = err
+		}
+	}
+	inFlight := 0
+	for inFlight > 0 || f.len() > 0 && sum.Err == nil {
+		// A nil channel is never ready, so with nothing queued, or once
+		// ctx is done, the select only waits for results.
+		var send chan<- *task
+		var done <-chan struct{}
+		next := f.peek()
+		if sum.Err == nil {
+			done = ctx.Done()
+			if next != nil && ctx.Err() == nil {
+				send = tasks
+			}
+		}
+		select {
+		case send <- next:
+			f.pop()
+			inFlight++
+		case <-done:
+			sum.Err = ctx.Err()
+		case r := <-results:
+			inFlight--
+			switch {
+			case r.Err != nil && ctx.Err() != nil:
+				// Most likely cut short rather than failed on its own.
+				r.Abandoned = true
+				sum.Abandoned = append(sum.Abandoned, r.URL)
+			case r.Err != nil:
+				sum.Failed++
+			default:
+				sum.Fetched++
+				for _, u := range r.Links {
+					if c.Scope.Contains(u) {
+						push(u, r.URL, r.Depth+1)
+					}
+				}
+				save(r.URL)
+			}
+			if c.OnPage != nil {
+				c.OnPage(r)
+			}
+		}
+	}
+	// Whatever is still queued, including the links of pages fetched
+	// while ctx ended, was never fetched.
+	for f.len() > 0 {
+		sum.Abandoned = append(sum.Abandoned, f.pop().url)
+	}
+	return sum
+}
+
+// Pages crawls as Crawl does, yielding each page as its fetch finishes.
+// Breaking out of the loop stops the crawl.
+func (c *Crawler) Pages(ctx context.Context, url string, depth int) iter.Seq[PageResult] {
+	return func(yield func(PageResult) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		crawler := *c
+		stopped := false
+		crawler.OnPage = func(r PageResult) {
+			if !stopped && !yield(r) {
+				stopped = true
+				cancel()
+			}
+		}
+		crawler.Crawl(ctx, url, depth)
+	}
+}
+
+// canonical returns rawURL normalized. A Fetcher's addresses need not
+// be URLs, and those that cannot be normalized are left as they are.
+func canonical(rawURL string) string {
+	if n, err := NormalizeURL(rawURL); err == nil {
+		return n
+	}
+	return rawURL
+}
+
+// normalizeLinks normalizes links, dropping repeats.
+func normalizeLinks(links []string) []string {
+	if len(links) == 0 {
+		return links
+	}
+	out := make([]string, 0, len(links))
+	seen := make(map[string]bool, len(links))
+	for _, l := range links {
+		if n := canonical(l); !seen[n] {
+			seen[n] = true
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// frontier is a FIFO queue of tasks that admits each page once, going
+// by the pageKey of its URL.
+type frontier struct {
+	// maxDepth is the depth from which pages are no longer fetched.
+	maxDepth int
+	queue    []*task
+	// seen holds the pageKey of every URL ever pushed; queued those still
+	// waiting.
+	seen   map[string]bool
+	queued map[string]*task
+}
+
+// push queues url, normalized and found on the page parent, to be
+// fetched at depth, unless that is too deep or url was already pushed,
+// and returns the task queued or moved up, or nil if neither. A URL still
+// queued that turns up again at a shallower depth moves up to it, so
+// how deep the crawl goes below it does not depend on which link was
+// found first.
+func (f *frontier) push(url, parent string, depth int) *task {
+	if depth >= f.maxDepth {
+		return nil
+	}
+	f.init()
+	key := pageKey(url)
+	if t, ok := f.queued[key]; ok {
+		if depth >= t.depth {
+			return nil
+		}
+		t.depth, t.parent = depth, parent
+		return t
+	}
+	if f.seen[key] {
+		return nil
+	}
+	f.seen[key] = true
+	t := &task{url: url, key: key, parent: parent, depth: depth}
+	f.queued[key] = t
+	f.queue = append(f.queue, t)
+	return t
+}
+
+func (f *frontier) init() {
+	if f.seen == nil {
+		f.seen = make(map[string]bool)
+		f.queued = make(map[string]*task)
+	}
+}
+
+func (f *frontier) peek() *task {
+	if len(f.queue) == 0 {
+		return nil
+	}
+	return f.queue[0]
+}
+
+func (f *frontier) pop() *task {
+	t := f.queue[0]
+	f.queue[0] = nil // let the task be collected once fetched
+	f.queue = f.queue[1:]
+	delete(f.queued, t.key)
+	return t
+}
+
+func (f *frontier) len() int {
+	return len(f.queue)
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingFetcher records how often each URL is fetched and the most
+// fetches ever running at once.
+type countingFetcher struct {
+	Fetcher
+	delay time.Duration
+
+	mu      sync.Mutex
+	counts  map[string]int
+	running int
+	peak    int
+}
+
+func (f *countingFetcher) Fetch(ctx context.Context, url string) (string, []string, error) {
+	f.mu.Lock()
+	if f.counts == nil {
+		f.counts = make(map[string]int)
+	}
+	f.counts[url]++
+	f.running++
+	f.peak = max(f.peak, f.running)
+	f.mu.Unlock()
+
+	time.Sleep(f.delay)
+	defer func() {
+		f.mu.Lock()
+		f.running--
+		f.mu.Unlock()
+	}()
+	return f.Fetcher.Fetch(ctx, url)
+}
+
+func (f *countingFetcher) fetched() []string {
+	urls := make([]string, 0, len(f.counts))
+	for u := range f.counts {
+		urls = append(urls, u)
+	}
+	slices.Sort(urls)
+	return urls
+}
+
+func TestCrawlerFetchesEachURLOnce(t *testing.T) {
+	for _, workers := range []int{1, 4, 16} {
+		f := &countingFetcher{Fetcher: fetcher}
+		(&Crawler{Fetcher: f, Workers: workers}).Crawl(t.Context(), "https://golang.org/", 4)
+
+		want := []string{
+			"https://golang.org/",
+			"https://golang.org/cmd/",
+			"https://golang.org/pkg/",
+			"https://golang.org/pkg/fmt/",
+			"https://golang.org/pkg/os/",
+		}
+		if got := f.fetched(); !slices.Equal(got, want) {
+			t.Errorf("%d workers fetched %q, want %q", workers, got, want)
+		}
+		for u, n := range f.counts {
+			if n != 1 {
+				t.Errorf("%d workers fetched %s %d times", workers, u, n)
+			}
+		}
+	}
+}
+
+func TestCrawlerStopsAtDepth(t *testing.T) {
+	// a -> b -> c -> d, and a -> c: c is two levels deep by one path
+	// and three by the other.
+	site := fakeFetcher{
+		"a": {"A", []string{"b", "c"}},
+		"b": {"B", []string{"c"}},
+		"c": {"C", []string{"d"}},
+		"d": {"D", nil},
+	}
+	for depth, want := range map[int][]string{
+		0: {},
+		1: {"a"},
+		2: {"a", "b", "c"},
+		3: {"a", "b", "c", "d"},
+	} {
+		f := &countingFetcher{Fetcher: site}
+		(&Crawler{Fetcher: f}).Crawl(t.Context(), "a", depth)
+		if got := f.fetched(); !slices.Equal(got, want) {
+			t.Errorf("depth %d fetched %q, want %q", depth, got, want)
+		}
+	}
+}
+
+func TestCrawlerBoundsConcurrentFetches(t *testing.T) {
+	// One page linking to a hundred others, which all link back.
+	site := fakeFetcher{"hub": {"Hub", nil}}
+	for i := range 100 {
+		u := fmt.Sprintf("leaf-%d", i)
+		site["hub"].urls = append(site["hub"].urls, u)
+		site[u] = &fakeResult{"Leaf", []string{"hub"}}
+	}
+	f := &countingFetcher{Fetcher: site, delay: time.Millisecond}
+	(&Crawler{Fetcher: f, Workers: 3}).Crawl(t.Context(), "hub", 2)
+
+	if len(f.counts) != 101 {
+		t.Errorf("fetched %d pages, want 101", len(f.counts))
+	}
+	if f.peak > 3 {
+		t.Errorf("%d fetches ran at once with 3 workers", f.peak)
+	}
+}
+
+func TestCrawlerSummary(t *testing.T) {
+	f := &countingFetcher{Fetcher: fetcher}
+	sum := (&Crawler{Fetcher: f}).Crawl(t.Context(), "https://golang.org/", 4)
+	// Every link of the canned pages leads to another canned page.
+	if sum.Fetched != 5 || sum.Failed != 0 || len(sum.Abandoned) != 0 || sum.Err != nil {
+		t.Errorf("summary = %+v, want 5 fetched and nothing else", sum)
+	}
+}
+
+// cancellingFetcher cancels the crawl once it has fetched after pages.
+type cancellingFetcher struct {
+	Fetcher
+	after  int
+	cancel context.CancelFunc
+
+	mu         sync.Mutex
+	fetches    int
+	lateStarts int
+}
+
+func (f *cancellingFetcher) Fetch(ctx context.Context, url string) (string, []string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if ctx.Err() != nil {
+		f.lateStarts++
+	}
+	f.fetches++
+	if f.fetches == f.after {
+		f.cancel()
+	}
+	return f.Fetcher.Fetch(context.Background(), url)
+}
+
+func TestCrawlerStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	f := &cancellingFetcher{Fetcher: fetcher, after: 2, cancel: cancel}
+	sum := (&Crawler{Fetcher: f, Workers: 1}).Crawl(ctx, "https://golang.org/", 4)
+
+	if !errors.Is(sum.Err, context.Canceled) {
+		t.Errorf("Err = %v, want context.Canceled", sum.Err)
+	}
+	// With one worker the two fetches are golang.org/ and the first of
+	// its links; the links of both are abandoned.
+	if f.fetches != 2 || f.lateStarts != 0 {
+		t.Errorf("%d fetches, %d after cancellation; want 2 and none", f.fetches, f.lateStarts)
+	}
+	if sum.Fetched != 2 || sum.Failed != 0 || len(sum.Abandoned) != 3 {
+		t.Errorf("summary = %+v, want 2 fetched and 3 abandoned", sum)
+	}
+}
+
+// blockingFetcher serves fakeFetcher pages, but hangs on any page not
+// in it until ctx is done.
+type blockingFetcher struct{ fakeFetcher }
+
+func (f blockingFetcher) Fetch(ctx context.Context, url string) (string, []string, error) {
+	if _, ok := f.fakeFetcher[url]; ok {
+		return f.fakeFetcher.Fetch(ctx, url)
+	}
+	<-ctx.Done()
+	return "", nil, ctx.Err()
+}
+
+func TestCrawlerDeadline(t *testing.T) {
+	site := fakeFetcher{"a": {"A", []string{"hang-1", "hang-2", "hang-3", "b"}}, "b": {"B", nil}}
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	sum := (&Crawler{Fetcher: blockingFetcher{site}, Workers: 2}).Crawl(ctx, "a", 3)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Crawl took %v with a 50ms deadline", elapsed)
+	}
+	if !errors.Is(sum.Err, context.DeadlineExceeded) {
+		t.Errorf("Err = %v, want context.DeadlineExceeded", sum.Err)
+	}
+	// Two workers hang on hang-1 and hang-2, leaving hang-3 and b queued.
+	abandoned := slices.Sorted(slices.Values(sum.Abandoned))
+	if sum.Fetched != 1 || sum.Failed != 0 || !slices.Equal(abandoned, []string{"b", "hang-1", "hang-2", "hang-3"}) {
+		t.Errorf("summary = %+v, want a fetched and the rest abandoned", sum)
+	}
+}
+
+func TestCrawlerReportsPages(t *testing.T) {
+	site := fakeFetcher{
+		"a": {"A", []string{"b", "c", "missing"}},
+		"b": {"B", []string{"c", "d"}},
+		"c": {"C", nil},
+		"d": {"D", nil},
+	}
+	pages := make(map[string]PageResult)
+	c := &Crawler{Fetcher: site, Workers: 1, OnPage: func(r PageResult) { pages[r.URL] = r }}
+	sum := c.Crawl(t.Context(), "a", 3)
+	if sum.Fetched != 4 || sum.Failed != 1 {
+		t.Errorf("summary = %+v, want 4 fetched and 1 failed", sum)
+	}
+
+	for _, want := range []PageResult{
+		{URL: "a", Depth: 0, Parent: "", Body: "A", Links: []string{"b", "c", "missing"}},
+		{URL: "b", Depth: 1, Parent: "a", Body: "B", Links: []string{"c", "d"}},
+		// c is linked from a and b; a is the closer parent.
+		{URL: "c", Depth: 1, Parent: "a", Body: "C"},
+		{URL: "d", Depth: 2, Parent: "b", Body: "D"},
+	} {
+		got := pages[want.URL]
+		if got.Err != nil || got.Started.IsZero() || got.Duration < 0 {
+			t.Errorf("%s: err %v, started %v, took %v", want.URL, got.Err, got.Started, got.Duration)
+		}
+		if got.Depth != want.Depth || got.Parent != want.Parent || got.Body != want.Body || !slices.Equal(got.Links, want.Links) {
+			t.Errorf("page %s = %+v, want %+v", want.URL, got, want)
+		}
+	}
+	if r := pages["missing"]; r.Err == nil || r.Parent != "a" {
+		t.Errorf("page missing = %+v, want a fetch error", r)
+	}
+}
+
+func TestCrawlerPagesStopsOnBreak(t *testing.T) {
+	f := &countingFetcher{Fetcher: fetcher}
+	var urls []string
+	for r := range (&Crawler{Fetcher: f, Workers: 1}).Pages(t.Context(), "https://golang.org/", 4) {
+		urls = append(urls, r.URL)
+		if len(urls) == 2 {
+			break
+		}
+	}
+	if len(urls) != 2 {
+		t.Errorf("yielded %q, want 2 pages", urls)
+	}
+	// The fetch running when the loop broke may finish, but no other
+	// starts.
+	if n := len(f.counts); n > 3 {
+		t.Errorf("%d pages fetched after breaking at 2", n)
+	}
+}
+
+func TestFrontierKeepsShallowestDepth(t *testing.T) {
+	f := frontier{maxDepth: 4}
+	f.push("a", "x", 3)
+	f.push("a", "y", 1)
+	f.push("a", "z", 2)
+	if t1 := f.pop(); t1.depth != 1 || t1.parent != "y" || f.len() != 0 {
+		t.Errorf("popped %+v with %d left, want a at depth 1 alone", t1, f.len())
+	}
+	// Once handed out, a URL is never queued again.
+	f.push("a", "x", 0)
+	if f.len() != 0 {
+		t.Errorf("a was queued again after being popped")
+	}
+	f.push("b", "x", 4)
+	if f.len() != 0 {
+		t.Errorf("b was queued at the maximum depth")
+	}
+}
+
+func TestCrawlerNormalizesAndScopes(t *testing.T) {
+	site := fakeFetcher{
+		"https://x.test/": {"Home", []string{
+			"https://X.test/a#top",
+			"https://x.test:443/a",
+			"https://x.test/a/",
+			"https://x.test/b?utm_source=feed",
+			"https://x.test/private/c",
+			"https://other.test/",
+		}},
+		"https://x.test/a": {"A", []string{"https://x.test/./b"}},
+		"https://x.test/b": {"B", nil},
+	}
+	f := &countingFetcher{Fetcher: site}
+	var home PageResult
+	c := &Crawler{
+		Fetcher: f,
+		Scope: &Scope{
+			Include: []ScopeRule{{Host: "x.test"}},
+			Exclude: []ScopeRule{{PathPrefix: "/private/"}},
+		},
+		OnPage: func(r PageResult) {
+			if r.Depth == 0 {
+				home = r
+			}
+		},
+	}
+	sum := c.Crawl(t.Context(), "HTTPS://x.test", 3)
+
+	want := []string{"https://x.test/", "https://x.test/a", "https://x.test/b"}
+	if got := f.fetched(); !slices.Equal(got, want) || sum.Failed != 0 {
+		t.Errorf("fetched %q with %d failures, want %q", got, sum.Failed, want)
+	}
+	// Every link is reported, in scope or not, but each once.
+	wantLinks := []string{
+		"https://x.test/a",
+		"https://x.test/a/",
+		"https://x.test/b",
+		"https://x.test/private/c",
+		"https://other.test/",
+	}
+	if !slices.Equal(home.Links, wantLinks) {
+		t.Errorf("links = %q, want %q", home.Links, wantLinks)
+	}
+}
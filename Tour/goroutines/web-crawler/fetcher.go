@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+// Defaults of an HTTPFetcher returned by NewHTTPFetcher.
+const (
+	defaultFetchTimeout = 10 * time.Second
+	defaultMaxRedirects = 10
+	defaultMaxBodySize  = 10 << 20 // 10 MiB
+	defaultUserAgent    = "golearn-crawler/1.0"
+)
+
+// ErrUnsupportedType is returned, wrapped, for a page that is neither
+// HTML nor text, which the crawler has no use for.
+var ErrUnsupportedType = errors.New("unsupported content type")
+
+// StatusError is returned for a page answered with a status other than
+// 2xx.
+type StatusError struct {
+	URL  string
+	Code int
+	// RetryAfter is how long the server asked to be left alone, with a
+	// Retry-After header, or 0 if it did not.
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: %d %s", e.URL, e.Code, http.StatusText(e.Code))
+}
+
+// HTTPFetcher is a Fetcher that GETs pages over HTTP. HTML pages are
+// returned with the links of their anchors, resolved against the page's
+// URL after redirects; plain text is returned with no links, and other
+// content types are not downloaded at all.
+type HTTPFetcher struct {
+	// Timeout bounds a whole fetch, redirects and body included. The
+	// context passed to Fetch can end it sooner.
+	Timeout time.Duration
+	// MaxRedirects is how many redirects a fetch follows before giving
+	// up.
+	MaxRedirects int
+	// MaxBodySize is how many bytes of a body are read; the rest is
+	// ignored.
+	MaxBodySize int64
+	UserAgent   string
+	// OnResponse, if set, is called with the URL fetched and the
+	// response to it, whatever its status, before its body is read.
+	// It may be called from several goroutines at once.
+	OnResponse func(url string, resp *http.Response)
+
+	client *http.Client
+}
+
+// NewHTTPFetcher returns an HTTPFetcher with the default limits, using
+// transport to make requests, or http.DefaultTransport if it is nil.
+func NewHTTPFetcher(transport http.RoundTripper) *HTTPFetcher {
+	f := &HTTPFetcher{
+		Timeout:      defaultFetchTimeout,
+		MaxRedirects: defaultMaxRedirects,
+		MaxBodySize:  defaultMaxBodySize,
+		UserAgent:    defaultUserAgent,
+	}
+	f.client = &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > f.MaxRedirects {
+				return fmt.Errorf("stopped after %d redirects", f.MaxRedirects)
+			}
+			return nil
+		},
+	}
+	return f
+}
+
+// Fetch implements Fetcher.
+func (f *HTTPFetcher) Fetch(ctx context.Context, rawURL string) (string, []string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	req.Header.Set("User-Agent", f.UserAgent)
+	req.Header.Set("Accept", "text/html, application/xhtml+xml;q=0.9, text/*;q=0.5")
+
+	// The client's timeout is read per request, so changes to f.Timeout
+	// after NewHTTPFetcher apply.
+	client := *f.client
+	client.Timeout = f.Timeout
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+	if f.OnResponse != nil {
+		f.OnResponse(rawURL, resp)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", nil, &StatusError{URL: rawURL, Code: resp.StatusCode, RetryAfter: retryAfter(resp.Header.Get("Retry-After"), time.Now())}
+	}
+	contentType := resp.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = ""
+	}
+	if mediaType != "" && !isText(mediaType) {
+		return "", nil, fmt.Errorf("%s: %w %s", rawURL, ErrUnsupportedType, mediaType)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, f.MaxBodySize))
+	if err != nil {
+		return "", nil, err
+	}
+	if mediaType == "" {
+		// Servers that do not say get the type sniffed from the body.
+		contentType = http.DetectContentType(body)
+		if mediaType, _, _ = mime.ParseMediaType(contentType); !isText(mediaType) {
+			return "", nil, fmt.Errorf("%s: %w %s", rawURL, ErrUnsupportedType, mediaType)
+		}
+	}
+	// Pages come in whatever charset the server says, or the page
+	// declares; the body is returned as UTF-8.
+	r, err := charset.NewReader(bytes.NewReader(body), contentType)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", rawURL, err)
+	}
+	if body, err = io.ReadAll(r); err != nil {
+		return "", nil, fmt.Errorf("%s: %w", rawURL, err)
+	}
+	if !isHTML(mediaType) {
+		return string(body), nil, nil
+	}
+	// Relative links are relative to where the redirects ended.
+	return string(body), extractLinks(resp.Request.URL, string(body)), nil
+}
+
+// retryAfter returns how long a Retry-After header value asks to wait
+// from now: a number of seconds, or an HTTP date. It returns 0 for a
+// value that is neither, or a date already past.
+func retryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(secs, 0)) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+func isHTML(mediaType string) bool {
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+// isText reports whether pages of mediaType are worth fetching.
+func isText(mediaType string) bool {
+	return isHTML(mediaType) || strings.HasPrefix(mediaType, "text/")
+}
+
+// extractLinks returns the absolute http and https URLs the anchors of
+// page link to, in order of first appearance and without fragments.
+// Relative links are resolved against the page's <base href> if it has
+// one, else against base.
+func extractLinks(base *url.URL, page string) []string {
+	var links []string
+	seen := make(map[string]bool)
+	z := html.NewTokenizer(strings.NewReader(page))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			// io.EOF, or a page too broken to read on; either way the
+			// links found so far are all there are.
+			return links
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			tag := string(name)
+			if (tag != "a" && tag != "base") || !hasAttr {
+				continue
+			}
+			href, ok := attr(z, "href")
+			if !ok {
+				continue
+			}
+			u, err := base.Parse(strings.TrimSpace(href))
+			if err != nil {
+				continue
+			}
+			if tag == "base" {
+				base = u
+				continue
+			}
+			if u.Scheme != "http" && u.Scheme != "https" {
+				continue // mailto:, javascript: and the like
+			}
+			u.Fragment, u.RawFragment = "", ""
+			if link := u.String(); !seen[link] {
+				seen[link] = true
+				links = append(links, link)
+			}
+		}
+	}
+}
+
+// attr returns the value of the attribute key of the tag z is at.
+func attr(z *html.Tokenizer, key string) (string, bool) {
+	for {
+		k, v, more := z.TagAttr()
+		if string(k) == key {
+			return string(v), true
+		}
+		if !more {
+			return "", false
+		}
+	}
+}
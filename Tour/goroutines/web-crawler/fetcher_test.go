@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newSite serves a small synthetic site covering what HTTPFetcher has
+// to cope with.
+func newSite(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	page := func(contentType, body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if contentType != "" {
+				w.Header().Set("Content-Type", contentType)
+			}
+			fmt.Fprint(w, body)
+		}
+	}
+	mux.HandleFunc("/{$}", page("text/html; charset=utf-8", `<html><head><title>Home</title></head><body>
+		<a href="docs/">Docs</a>
+		<a href="/blog/post?id=1#comments">Post</a>
+		<a href="./docs/">Docs again</a>
+		<a href="https://example.com/elsewhere">Elsewhere</a>
+		<a href="mailto:someone@example.com">Mail</a>
+		<a href="javascript:void(0)">Nothing</a>
+		<a name="anchor-without-href">Top</a>
+		<A HREF="/upper">Upper case</A>
+	</body></html>`))
+	mux.HandleFunc("/docs/", page("text/html", `<a href="../">Up</a> <a href="guide.html">Guide</a>`))
+	mux.HandleFunc("/based", page("text/html", `<head><base href="/root/sub/"></head><a href="page">Page</a>`))
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/docs/", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	mux.HandleFunc("/notes.txt", page("text/plain", "see /docs/"))
+	mux.HandleFunc("/logo.png", page("image/png", "\x89PNG\r\n\x1a\n"))
+	mux.HandleFunc("/unlabelled", page("", `<!DOCTYPE html><a href="/docs/">Docs</a>`))
+	mux.HandleFunc("/latin1", page("text/html; charset=iso-8859-1", "<p>Caf\xe9</p>"))
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestHTTPFetcherExtractsLinks(t *testing.T) {
+	srv := newSite(t)
+	f := NewHTTPFetcher(srv.Client().Transport)
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"/", []string{
+			srv.URL + "/docs/",
+			srv.URL + "/blog/post?id=1",
+			"https://example.com/elsewhere",
+			srv.URL + "/upper",
+		}},
+		{"/docs/", []string{srv.URL + "/", srv.URL + "/docs/guide.html"}},
+		// Links are relative to the <base href>, if any.
+		{"/based", []string{srv.URL + "/root/sub/page"}},
+		// ... or to where the redirects ended, not to the URL fetched.
+		{"/moved", []string{srv.URL + "/", srv.URL + "/docs/guide.html"}},
+		// Pages that do not say what they are get sniffed.
+		{"/unlabelled", []string{srv.URL + "/docs/"}},
+		// Plain text has no links.
+		{"/notes.txt", nil},
+	}
+	for _, tt := range tests {
+		body, links, err := f.Fetch(t.Context(), srv.URL+tt.path)
+		if err != nil {
+			t.Errorf("Fetch(%s): %v", tt.path, err)
+			continue
+		}
+		if body == "" {
+			t.Errorf("Fetch(%s): empty body", tt.path)
+		}
+		if !slices.Equal(links, tt.want) {
+			t.Errorf("Fetch(%s) links =\n%q\nwant\n%q", tt.path, links, tt.want)
+		}
+	}
+}
+
+func TestHTTPFetcherDecodesCharset(t *testing.T) {
+	srv := newSite(t)
+	body, _, err := NewHTTPFetcher(srv.Client().Transport).Fetch(t.Context(), srv.URL+"/latin1")
+	if err != nil || !strings.Contains(body, "Café") {
+		t.Errorf("Fetch(/latin1) = %q, %v; want it decoded to UTF-8", body, err)
+	}
+}
+
+func TestHTTPFetcherErrors(t *testing.T) {
+	srv := newSite(t)
+	f := NewHTTPFetcher(srv.Client().Transport)
+	f.MaxRedirects = 3
+	f.Timeout = 50 * time.Millisecond
+
+	_, _, err := f.Fetch(t.Context(), srv.URL+"/missing")
+	var status *StatusError
+	if !errors.As(err, &status) || status.Code != http.StatusNotFound {
+		t.Errorf("Fetch(/missing) = %v, want a 404 StatusError", err)
+	}
+	if _, _, err := f.Fetch(t.Context(), srv.URL+"/logo.png"); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("Fetch(/logo.png) = %v, want ErrUnsupportedType", err)
+	}
+	if _, _, err := f.Fetch(t.Context(), srv.URL+"/loop"); err == nil || !strings.Contains(err.Error(), "stopped after 3 redirects") {
+		t.Errorf("Fetch(/loop) = %v, want the redirect cap", err)
+	}
+	start := time.Now()
+	if _, _, err := f.Fetch(t.Context(), srv.URL+"/slow"); err == nil {
+		t.Error("Fetch(/slow) succeeded, want a timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Fetch(/slow) took %v despite the 50ms timeout", elapsed)
+	}
+	if _, _, err := f.Fetch(t.Context(), "ftp://example.com/"); err == nil {
+		t.Error("Fetch(ftp://...) succeeded")
+	}
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+)
+
+// PageStatus is how a page of a Graph fared in the crawl.
+type PageStatus string
+
+const (
+	PageFetched PageStatus = "fetched"
+	PageFailed  PageStatus = "failed"
+	// PageUnfetched is a page linked to but not fetched: out of scope,
+	// too deep, or left when the crawl was stopped.
+	PageUnfetched PageStatus = "unfetched"
+)
+
+// Graph is the directed graph of the links a crawl found, with a node
+// for every page fetched or linked to. Add each page to it from a
+// Crawler's OnPage; it is not safe for concurrent use, which OnPage
+// does not need.
+//
+// Pages are told apart as the crawler tells them apart, so /pkg and
+// /pkg/ are one node, named by the URL fetched if it was fetched.
+type Graph struct {
+	nodes []*GraphNode
+	byKey map[string]*GraphNode
+}
+
+// GraphNode is a page of a Graph.
+type GraphNode struct {
+	URL string
+	// Depth is the page's depth in the crawl, or, if it was not fetched,
+	// the least depth it was linked at.
+	Depth int
+	// Parent is the page's parent in the crawl, as in PageResult.
+	Parent string
+	Status PageStatus
+	// Code is the HTTP status of a page that failed with one.
+	Code int
+	// Err is why a page failed.
+	Err string
+
+	out []*GraphNode
+}
+
+// GraphEdge is a link from one page of a Graph to another.
+type GraphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// GraphStats is what can be told of a site from its Graph.
+type GraphStats struct {
+	Pages, Fetched, Failed, Unfetched, Links int
+	// Broken lists the links to pages that failed.
+	Broken []BrokenLink
+	// Orphans lists the pages fetched that no other page fetched links
+	// to, other than the first. A crawl only finds pages through links,
+	// so these are seeds, such as those a sitemap lists, or were found
+	// in an earlier crawl resumed from a State.
+	Orphans []string
+	// PageRank is the PageRank of each page, by URL, with a damping
+	// factor of 0.85. The ranks add up to 1.
+	PageRank map[string]float64
+}
+
+// BrokenLink is a link to a page that failed.
+type BrokenLink struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Code int    `json:"code,omitempty"`
+	Err  string `json:"error"`
+}
+
+// Add adds a page, and its links, to g. A page abandoned when the crawl
+// was stopped is as unfetched as those left queued, not failed.
+func (g *Graph) Add(r PageResult) {
+	n := g.node(r.URL, r.Depth)
+	if r.Abandoned {
+		if n.Status == PageUnfetched {
+			n.Depth = min(n.Depth, r.Depth)
+		}
+		return
+	}
+	n.URL, n.Depth, n.Parent = r.URL, r.Depth, r.Parent
+	n.Status = PageFetched
+	if r.Err != nil {
+		n.Status, n.Err = PageFailed, r.Err.Error()
+		var status *StatusError
+		if errors.As(r.Err, &status) {
+			n.Code = status.Code
+		}
+	}
+	n.out = n.out[:0]
+	for _, link := range r.Links {
+		t := g.node(link, r.Depth+1)
+		if t.Status == PageUnfetched {
+			t.Depth = min(t.Depth, r.Depth+1)
+		}
+		// /pkg and /pkg/ are one page, and one link to it.
+		if !slices.Contains(n.out, t) {
+			n.out = append(n.out, t)
+		}
+	}
+}
+
+// node returns the node of url, adding it, unfetched at depth, if it
+// is new.
+func (g *Graph) node(url string, depth int) *GraphNode {
+	key := pageKey(url)
+	if n, ok := g.byKey[key]; ok {
+		return n
+	}
+	if g.byKey == nil {
+		g.byKey = make(map[string]*GraphNode)
+	}
+	n := &GraphNode{URL: url, Depth: depth, Status: PageUnfetched}
+	g.byKey[key] = n
+	g.nodes = append(g.nodes, n)
+	return n
+}
+
+// Nodes returns the pages of g, by depth, then URL.
+func (g *Graph) Nodes() []*GraphNode {
+	nodes := slices.Clone(g.nodes)
+	slices.SortFunc(nodes, func(a, b *GraphNode) int {
+		return cmp.Or(cmp.Compare(a.Depth, b.Depth), strings.Compare(a.URL, b.URL))
+	})
+	return nodes
+}
+
+// Edges returns the links of g, in the order of Nodes and then of the
+// links on each page.
+func (g *Graph) Edges() []GraphEdge {
+	var edges []GraphEdge
+	for _, n := range g.Nodes() {
+		for _, t := range n.out {
+			edges = append(edges, GraphEdge{From: n.URL, To: t.URL})
+		}
+	}
+	return edges
+}
+
+// Stats computes what can be told of the site from g.
+func (g *Graph) Stats() GraphStats {
+	var s GraphStats
+	linked := make(map[*GraphNode]bool)
+	for _, n := range g.Nodes() {
+		s.Pages++
+		switch n.Status {
+		case PageFetched:
+			s.Fetched++
+		case PageFailed:
+			s.Failed++
+		case PageUnfetched:
+			s.Unfetched++
+		}
+		for _, t := range n.out {
+			s.Links++
+			if t != n {
+				linked[t] = true
+			}
+			if t.Status == PageFailed {
+				s.Broken = append(s.Broken, BrokenLink{From: n.URL, To: t.URL, Code: t.Code, Err: t.Err})
+			}
+		}
+	}
+	for _, n := range g.Nodes() {
+		first := n.Depth == 0 && n.Parent == ""
+		if n.Status == PageFetched && !first && !linked[n] {
+			s.Orphans = append(s.Orphans, n.URL)
+		}
+	}
+	s.PageRank = g.pageRank()
+	return s
+}
+
+// pageRank computes the PageRank of g's pages by power iteration. A
+// page with no links, which includes every page not fetched, is taken
+// to link to every page, as is usual.
+func (g *Graph) pageRank() map[string]float64 {
+	const (
+		damping   = 0.85
+		tolerance = 1e-9
+		maxRounds = 100
+	)
+	n := len(g.nodes)
+	if n == 0 {
+		return nil
+	}
+	index := make(map[*GraphNode]int, n)
+	for i, node := range g.nodes {
+		index[node] = i
+	}
+	rank := make([]float64, n)
+	for i := range rank {
+		rank[i] = 1 / float64(n)
+	}
+	next := make([]float64, n)
+	for range maxRounds {
+		clear(next)
+		dangling := 0.0
+		for i, node := range g.nodes {
+			if len(node.out) == 0 {
+				dangling += rank[i]
+				continue
+			}
+			share := rank[i] / float64(len(node.out))
+			for _, t := range node.out {
+				next[index[t]] += share
+			}
+		}
+		base := (1-damping)/float64(n) + damping*dangling/float64(n)
+		change := 0.0
+		for i := range next {
+			next[i] = base + damping*next[i]
+			change += math.Abs(next[i] - rank[i])
+		}
+		rank, next = next, rank
+		if change < tolerance {
+			break
+		}
+	}
+	ranks := make(map[string]float64, n)
+	for i, node := range g.nodes {
+		ranks[node.URL] = rank[i]
+	}
+	return ranks
+}
+
+// WriteDOT writes g in Graphviz's DOT language. Failed pages are red
+// and pages not fetched grey.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph crawl {")
+	fmt.Fprintln(bw, "\tnode [shape=box];")
+	for _, n := range g.Nodes() {
+		attrs := fmt.Sprintf("label=%s", dotQuote(fmt.Sprintf("%s\ndepth %d", n.URL, n.Depth)))
+		switch n.Status {
+		case PageFailed:
+			attrs += fmt.Sprintf(", color=red, tooltip=%s", dotQuote(n.Err))
+		case PageUnfetched:
+			attrs += ", color=grey, fontcolor=grey, style=dashed"
+		}
+		fmt.Fprintf(bw, "\t%s [%s];\n", dotQuote(n.URL), attrs)
+	}
+	for _, e := range g.Edges() {
+		fmt.Fprintf(bw, "\t%s -> %s;\n", dotQuote(e.From), dotQuote(e.To))
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// dotQuote quotes s as a DOT string, in which a newline is \n.
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// WriteGraphML writes g as GraphML, with the URL, depth, status, HTTP
+// status, error and PageRank of each page as data.
+func (g *Graph) WriteGraphML(w io.Writer) error {
+	type data struct {
+		Key   string `xml:"key,attr"`
+		Value string `xml:",chardata"`
+	}
+	type node struct {
+		ID   string `xml:"id,attr"`
+		Data []data `xml:"data"`
+	}
+	type edge struct {
+		Source string `xml:"source,attr"`
+		Target string `xml:"target,attr"`
+	}
+	type key struct {
+		ID   string `xml:"id,attr"`
+		For  string `xml:"for,attr"`
+		Name string `xml:"attr.name,attr"`
+		Type string `xml:"attr.type,attr"`
+	}
+	doc := struct {
+		XMLName xml.Name `xml:"http://graphml.graphdrawing.org/xmlns graphml"`
+		Keys    []key    `xml:"key"`
+		Graph   struct {
+			ID          string `xml:"id,attr"`
+			EdgeDefault string `xml:"edgedefault,attr"`
+			Nodes       []node `xml:"node"`
+			Edges       []edge `xml:"edge"`
+		} `xml:"graph"`
+	}{
+		Keys: []key{
+			{"url", "node", "url", "string"},
+			{"depth", "node", "depth", "int"},
+			{"status", "node", "status", "string"},
+			{"code", "node", "code", "int"},
+			{"error", "node", "error", "string"},
+			{"pagerank", "node", "pagerank", "double"},
+		},
+	}
+	doc.Graph.ID, doc.Graph.EdgeDefault = "crawl", "directed"
+
+	ranks := g.pageRank()
+	ids := make(map[string]string)
+	for i, n := range g.Nodes() {
+		ids[n.URL] = fmt.Sprintf("n%d", i)
+		d := []data{
+			{"url", n.URL},
+			{"depth", fmt.Sprint(n.Depth)},
+			{"status", string(n.Status)},
+		}
+		if n.Code != 0 {
+			d = append(d, data{"code", fmt.Sprint(n.Code)})
+		}
+		if n.Err != "" {
+			d = append(d, data{"error", n.Err})
+		}
+		d = append(d, data{"pagerank", fmt.Sprint(ranks[n.URL])})
+		doc.Graph.Nodes = append(doc.Graph.Nodes, node{ID: ids[n.URL], Data: d})
+	}
+	for _, e := range g.Edges() {
+		doc.Graph.Edges = append(doc.Graph.Edges, edge{Source: ids[e.From], Target: ids[e.To]})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// WriteJSON writes g as JSON: its nodes, with their PageRank, its
+// edges, and its stats.
+func (g *Graph) WriteJSON(w io.Writer) error {
+	type node struct {
+		URL      string     `json:"url"`
+		Depth    int        `json:"depth"`
+		Status   PageStatus `json:"status"`
+		Code     int        `json:"code,omitempty"`
+		Err      string     `json:"error,omitempty"`
+		PageRank float64    `json:"pagerank"`
+	}
+	stats := g.Stats()
+	doc := struct {
+		Nodes []node      `json:"nodes"`
+		Edges []GraphEdge `json:"edges"`
+		Stats struct {
+			Pages     int          `json:"pages"`
+			Fetched   int          `json:"fetched"`
+			Failed    int          `json:"failed"`
+			Unfetched int          `json:"unfetched"`
+			Links     int          `json:"links"`
+			Broken    []BrokenLink `json:"broken"`
+			Orphans   []string     `json:"orphans"`
+		} `json:"stats"`
+	}{
+		Nodes: []node{},
+		Edges: g.Edges(),
+	}
+	for _, n := range g.Nodes() {
+		doc.Nodes = append(doc.Nodes, node{n.URL, n.Depth, n.Status, n.Code, n.Err, stats.PageRank[n.URL]})
+	}
+	if doc.Edges == nil {
+		doc.Edges = []GraphEdge{}
+	}
+	s := &doc.Stats
+	s.Pages, s.Fetched, s.Failed, s.Unfetched, s.Links = stats.Pages, stats.Fetched, stats.Failed, stats.Unfetched, stats.Links
+	// Empty lists, rather than null, for those reading the JSON.
+	s.Broken, s.Orphans = []BrokenLink{}, []string{}
+	s.Broken = append(s.Broken, stats.Broken...)
+	s.Orphans = append(s.Orphans, stats.Orphans...)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// crawlGraph crawls site from a to depth 3 into a Graph.
+func crawlGraph(t *testing.T, site Fetcher) *Graph {
+	var g Graph
+	(&Crawler{Fetcher: site, OnPage: g.Add}).Crawl(t.Context(), "a", 3)
+	return &g
+}
+
+var graphSite = fakeFetcher{
+	"a": {"A", []string{"b", "c", "gone"}},
+	"b": {"B", []string{"a", "c", "b"}},
+	"c": {"C", []string{"a", "d"}},
+	"d": {"D", []string{"e"}}, // e is too deep to fetch
+}
+
+func TestGraph(t *testing.T) {
+	g := crawlGraph(t, graphSite)
+
+	var nodes []string
+	for _, n := range g.Nodes() {
+		nodes = append(nodes, string(n.Status)+" "+n.URL)
+	}
+	want := []string{"fetched a", "fetched b", "fetched c", "failed gone", "fetched d", "unfetched e"}
+	if !slices.Equal(nodes, want) {
+		t.Errorf("nodes = %q, want %q", nodes, want)
+	}
+	if len(g.Edges()) != 9 {
+		t.Errorf("%d edges, want 9: %v", len(g.Edges()), g.Edges())
+	}
+
+	s := g.Stats()
+	if s.Pages != 6 || s.Fetched != 4 || s.Failed != 1 || s.Unfetched != 1 || s.Links != 9 {
+		t.Errorf("stats = %+v", s)
+	}
+	if len(s.Broken) != 1 || s.Broken[0].From != "a" || s.Broken[0].To != "gone" || s.Broken[0].Err == "" {
+		t.Errorf("broken = %+v, want a -> gone", s.Broken)
+	}
+	if len(s.Orphans) != 0 {
+		t.Errorf("orphans = %q, want none", s.Orphans)
+	}
+
+	// The ranks add up to 1, and a, which everything leads back to,
+	// ranks highest.
+	sum := 0.0
+	for u, r := range s.PageRank {
+		sum += r
+		if u != "a" && r >= s.PageRank["a"] {
+			t.Errorf("%s ranks %.3f, a only %.3f", u, r, s.PageRank["a"])
+		}
+	}
+	if math.Abs(sum-1) > 1e-6 {
+		t.Errorf("ranks add up to %v", sum)
+	}
+}
+
+func TestGraphStoppedCrawl(t *testing.T) {
+	site := fakeFetcher{"a": {"A", []string{"hang-1", "b"}}, "b": {"B", nil}}
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	var g Graph
+	(&Crawler{Fetcher: blockingFetcher{site}, Workers: 1, OnPage: g.Add}).Crawl(ctx, "a", 3)
+
+	// hang-1 was cut short by the deadline, not broken.
+	s := g.Stats()
+	if s.Fetched != 1 || s.Failed != 0 || s.Unfetched != 2 || len(s.Broken) != 0 {
+		t.Errorf("stats = %+v, want a fetched and the rest unfetched", s)
+	}
+}
+
+func TestGraphPageRank(t *testing.T) {
+	// In a ring every page ranks the same.
+	var g Graph
+	g.Add(PageResult{URL: "x", Links: []string{"y"}})
+	g.Add(PageResult{URL: "y", Depth: 1, Links: []string{"z"}})
+	g.Add(PageResult{URL: "z", Depth: 2, Links: []string{"x"}})
+	for u, r := range g.Stats().PageRank {
+		if math.Abs(r-1.0/3) > 1e-6 {
+			t.Errorf("%s ranks %v, want 1/3", u, r)
+		}
+	}
+}
+
+func TestGraphOrphans(t *testing.T) {
+	var g Graph
+	g.Add(PageResult{URL: "https://x.test/", Links: []string{"https://x.test/a/"}})
+	// Fetched as /a, linked to as /a/: the same page.
+	g.Add(PageResult{URL: "https://x.test/a", Depth: 1, Links: []string{"https://x.test/a"}})
+	// Fetched, but linked to by nothing but itself.
+	g.Add(PageResult{URL: "https://x.test/lost", Depth: 1, Links: []string{"https://x.test/lost"}})
+
+	s := g.Stats()
+	if s.Pages != 3 || !slices.Equal(s.Orphans, []string{"https://x.test/lost"}) {
+		t.Errorf("%d pages, orphans %q; want 3 and /lost", s.Pages, s.Orphans)
+	}
+}
+
+func TestGraphExport(t *testing.T) {
+	g := crawlGraph(t, graphSite)
+
+	var dot bytes.Buffer
+	if err := g.WriteDOT(&dot); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"digraph crawl {\n", "\t\"a\" -> \"gone\";\n", "\t\"e\" [label=\"e\\ndepth 3\", color=grey"} {
+		if !strings.Contains(dot.String(), want) {
+			t.Errorf("DOT lacks %q:\n%s", want, dot.String())
+		}
+	}
+
+	var graphml bytes.Buffer
+	if err := g.WriteGraphML(&graphml); err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Graph struct {
+			Nodes []struct {
+				ID   string `xml:"id,attr"`
+				Data []struct {
+					Key   string `xml:"key,attr"`
+					Value string `xml:",chardata"`
+				} `xml:"data"`
+			} `xml:"node"`
+			Edges []struct {
+				Source string `xml:"source,attr"`
+				Target string `xml:"target,attr"`
+			} `xml:"edge"`
+		} `xml:"graph"`
+	}
+	if err := xml.Unmarshal(graphml.Bytes(), &doc); err != nil {
+		t.Fatalf("GraphML does not parse: %v\n%s", err, graphml.String())
+	}
+	if len(doc.Graph.Nodes) != 6 || len(doc.Graph.Edges) != 9 {
+		t.Errorf("GraphML has %d nodes and %d edges, want 6 and 9", len(doc.Graph.Nodes), len(doc.Graph.Edges))
+	}
+	if n := doc.Graph.Nodes[0]; n.ID != "n0" || n.Data[0].Key != "url" || n.Data[0].Value != "a" {
+		t.Errorf("first GraphML node = %+v, want a", n)
+	}
+
+	var js bytes.Buffer
+	if err := g.WriteJSON(&js); err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Nodes []struct {
+			URL      string
+			Status   string
+			PageRank float64
+		}
+		Edges []GraphEdge
+		Stats struct {
+			Broken  []BrokenLink
+			Orphans []string
+		}
+	}
+	if err := json.Unmarshal(js.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Nodes) != 6 || len(got.Edges) != 9 || len(got.Stats.Broken) != 1 || got.Stats.Orphans == nil {
+		t.Errorf("JSON = %s", js.String())
+	}
+	if got.Nodes[0].URL != "a" || got.Nodes[0].PageRank == 0 {
+		t.Errorf("first JSON node = %+v", got.Nodes[0])
+	}
+}
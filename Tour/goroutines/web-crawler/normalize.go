@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// defaultPorts are the ports NormalizeURL drops, by scheme.
+var defaultPorts = map[string]string{"http": "80", "https": "443"}
+
+// trackingParams are the query parameters NormalizeURL drops: they tell
+// the site where a visitor came from and never change the page. So do
+// those starting utm_.
+var trackingParams = map[string]bool{
+	"gclid":   true,
+	"dclid":   true,
+	"fbclid":  true,
+	"msclkid": true,
+	"yclid":   true,
+	"igshid":  true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"_ga":     true,
+	"_gl":     true,
+}
+
+// NormalizeURL returns the canonical form of an absolute URL, so that
+// URLs naming the same page are written the same way. It
+//
+//   - lower-cases the scheme and host, and drops the port if it is the
+//     scheme's default;
+//   - drops the fragment, which only says where to scroll to;
+//   - removes . and .. segments and empty segments from the path, and
+//     gives an empty path a /;
+//   - drops tracking parameters from the query, and sorts the rest by
+//     name, keeping the order of those with the same name.
+//
+// A trailing slash is kept: /pkg and /pkg/ may well be different pages
+// of a site, so the crawler only treats them as one when deciding what
+// to fetch, and fetches whichever it found first.
+func NormalizeURL(rawURL string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("%s: not an absolute URL", rawURL)
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]" // IPv6
+	}
+	if port := u.Port(); port != "" && port != defaultPorts[u.Scheme] {
+		host += ":" + port
+	}
+	u.Host = host
+	u.Fragment, u.RawFragment = "", ""
+
+	// JoinPath cleans the path, keeping a trailing slash.
+	u = u.JoinPath()
+	if u.Path == "" {
+		u.Path, u.RawPath = "/", ""
+	}
+	u.RawQuery, u.ForceQuery = normalizeQuery(u.RawQuery), false
+	return u.String(), nil
+}
+
+// normalizeQuery drops the empty and tracking parameters of a raw query
+// and sorts the rest by name. The parameters are not decoded and encoded
+// again, which could change what the server sees.
+func normalizeQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	type param struct{ name, raw string }
+	var params []param
+	for _, raw := range strings.Split(rawQuery, "&") {
+		if raw == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(raw, "=")
+		if n, err := url.QueryUnescape(name); err == nil {
+			name = n
+		}
+		if trackingParams[strings.ToLower(name)] || strings.HasPrefix(strings.ToLower(name), "utm_") {
+			continue
+		}
+		params = append(params, param{name, raw})
+	}
+	slices.SortStableFunc(params, func(a, b param) int { return strings.Compare(a.name, b.name) })
+	raws := make([]string, len(params))
+	for i, p := range params {
+		raws[i] = p.raw
+	}
+	return strings.Join(raws, "&")
+}
+
+// pageKey returns the key under which the crawler remembers a URL
+// normalized by NormalizeURL: the URL without any trailing slash on its
+// path, so that /pkg and /pkg/ are fetched once between them.
+func pageKey(normalized string) string {
+	base, query, hasQuery := strings.Cut(normalized, "?")
+	if _, rest, ok := strings.Cut(base, "://"); ok {
+		// rest is host/path; trim the path but never the / that follows
+		// the host.
+		if i := strings.Index(rest, "/"); i >= 0 && rest[i:] != "/" {
+			base = strings.TrimSuffix(base, "/")
+		}
+	}
+	if hasQuery {
+		return base + "?" + query
+	}
+	return base
+}
@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"https://golang.org/pkg/", "https://golang.org/pkg/"},
+		{"HTTPS://GoLang.ORG/pkg/", "https://golang.org/pkg/"},
+		{"https://golang.org", "https://golang.org/"},
+		{"https://golang.org:443/pkg", "https://golang.org/pkg"},
+		{"http://golang.org:80/", "http://golang.org/"},
+		{"http://golang.org:8080/", "http://golang.org:8080/"},
+		{"https://golang.org./", "https://golang.org/"},
+		{"https://golang.org/pkg/#section", "https://golang.org/pkg/"},
+		{"https://golang.org/a/./b/../c/", "https://golang.org/a/c/"},
+		{"https://golang.org//pkg//fmt", "https://golang.org/pkg/fmt"},
+		{"https://golang.org/search?q=go&b=2&a=1&a=0", "https://golang.org/search?a=1&a=0&b=2&q=go"},
+		{"https://golang.org/?utm_source=x&UTM_Medium=y&gclid=z&id=7", "https://golang.org/?id=7"},
+		{"https://golang.org/?utm_source=x", "https://golang.org/"},
+		{"https://golang.org/?", "https://golang.org/"},
+		{"https://golang.org/?q=a%20b&&p=c+d", "https://golang.org/?p=c+d&q=a%20b"},
+		{"https://golang.org/caf%C3%A9", "https://golang.org/caf%C3%A9"},
+		{"http://[::1]:80/x", "http://[::1]/x"},
+		{" https://golang.org/ ", "https://golang.org/"},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeURL(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("NormalizeURL(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+
+	for _, in := range []string{"/pkg/", "golang.org/pkg/", "https://%zz/"} {
+		if got, err := NormalizeURL(in); err == nil {
+			t.Errorf("NormalizeURL(%q) = %q, want an error", in, got)
+		}
+	}
+}
+
+func TestPageKey(t *testing.T) {
+	for in, want := range map[string]string{
+		"https://golang.org/":         "https://golang.org/",
+		"https://golang.org/pkg/":     "https://golang.org/pkg",
+		"https://golang.org/pkg":      "https://golang.org/pkg",
+		"https://golang.org/pkg/?q=1": "https://golang.org/pkg?q=1",
+		"https://golang.org/?q=a/":    "https://golang.org/?q=a/",
+		"not-a-url/":                  "not-a-url/",
+	} {
+		if got := pageKey(in); got != want {
+			t.Errorf("pageKey(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
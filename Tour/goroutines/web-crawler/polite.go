@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of a PoliteFetcher returned by NewPoliteFetcher.
+const (
+	defaultMaxPerHost = 2
+	// maxRobotsSize is how much of a robots.txt is read; RFC 9309 asks
+	// crawlers to read at least 500 KiB.
+	maxRobotsSize = 500 << 10
+)
+
+// ErrDisallowed is returned, wrapped, for a page robots.txt asks the
+// crawler not to fetch.
+var ErrDisallowed = errors.New("disallowed by robots.txt")
+
+// PoliteFetcher is a Fetcher that fetches through another the way a
+// site's owner would want: it keeps off the paths the site's robots.txt
+// disallows, waits between requests to a host, and runs only a few at
+// once on each host.
+//
+// Each host's robots.txt is fetched the first time a page of the host
+// is, and kept for as long as the PoliteFetcher. A robots.txt that is
+// missing allows everything; one that cannot be fetched, because the
+// host is down or answers with a 5xx, disallows everything.
+//
+// Fetches waiting their turn on a busy host hold up the crawler worker
+// that made them, so a crawl of few hosts gains little from more
+// workers than MaxPerHost for each.
+type PoliteFetcher struct {
+	Fetcher Fetcher
+	// UserAgent is the agent the robots.txt rules are read for. Only
+	// its product token, the part before any "/", is compared.
+	UserAgent string
+	// MaxPerHost is how many fetches from one host run at once. It is
+	// read when the host is first fetched from.
+	MaxPerHost int
+	// Delay is the least time between the starts of two fetches from a
+	// host. A longer Crawl-delay in the host's robots.txt wins.
+	Delay time.Duration
+	// Jitter is the most added at random to each wait, so requests do
+	// not arrive like clockwork.
+	Jitter time.Duration
+
+	client *http.Client
+	mu     sync.Mutex
+	hosts  map[string]*host
+}
+
+// host is what a PoliteFetcher keeps about one scheme, host and port.
+type host struct {
+	// robots is set before loaded is closed.
+	robots *robots
+	loaded chan struct{}
+	// slots holds a token for each fetch running.
+	slots chan struct{}
+
+	mu sync.Mutex
+	// next is the earliest the next fetch may start.
+	next time.Time
+}
+
+// NewPoliteFetcher returns a PoliteFetcher fetching pages through f,
+// and robots.txt files through transport, or http.DefaultTransport if
+// it is nil.
+func NewPoliteFetcher(f Fetcher, transport http.RoundTripper) *PoliteFetcher {
+	return &PoliteFetcher{
+		Fetcher:    f,
+		UserAgent:  defaultUserAgent,
+		MaxPerHost: defaultMaxPerHost,
+		client:     &http.Client{Transport: transport, Timeout: defaultFetchTimeout},
+	}
+}
+
+// Fetch implements Fetcher.
+func (p *PoliteFetcher) Fetch(ctx context.Context, rawURL string) (string, []string, error) {
+	release, err := p.admit(ctx, rawURL)
+	if err != nil {
+		return "", nil, err
+	}
+	defer release()
+	return p.Fetcher.Fetch(ctx, rawURL)
+}
+
+// admit waits until rawURL may be fetched: its host's robots.txt allows
+// it, one of the host's MaxPerHost slots is free and its turn has come.
+// The caller fetches it, then calls release to free the slot.
+func (p *PoliteFetcher) admit(ctx context.Context, rawURL string) (release func(), err error) {
+	h, u, err := p.loadedHost(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if !h.robots.allowed(robotsPath(u)) {
+		return nil, fmt.Errorf("%s: %w", rawURL, ErrDisallowed)
+	}
+
+	select {
+	case h.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	release = func() { <-h.slots }
+	if err := h.wait(ctx, max(p.Delay, h.robots.delay), p.Jitter); err != nil {
+		release()
+		return nil, err
+	}
+	return release, nil
+}
+
+// sitemaps returns the sitemaps named by the robots.txt of rawURL's
+// host.
+func (p *PoliteFetcher) sitemaps(ctx context.Context, rawURL string) ([]string, error) {
+	h, _, err := p.loadedHost(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return h.robots.sitemaps, nil
+}
+
+// loadedHost returns what is kept about rawURL's host once its
+// robots.txt has been fetched, or an error if rawURL is not a URL the
+// fetcher can fetch or ctx is done first.
+func (p *PoliteFetcher) loadedHost(ctx context.Context, rawURL string) (*host, *url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, nil, fmt.Errorf("%s: not an http or https URL", rawURL)
+	}
+	h := p.host(ctx, u)
+	select {
+	case <-h.loaded:
+		return h, u, nil
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+}
+
+// host returns what is kept about u's host, starting to fetch its
+// robots.txt if it is new.
+func (p *PoliteFetcher) host(ctx context.Context, u *url.URL) *host {
+	origin := u.Scheme + "://" + u.Host
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if h, ok := p.hosts[origin]; ok {
+		return h
+	}
+	if p.hosts == nil {
+		p.hosts = make(map[string]*host)
+	}
+	h := &host{loaded: make(chan struct{}), slots: make(chan struct{}, max(p.MaxPerHost, 1))}
+	p.hosts[origin] = h
+	// Every fetch from the host waits for its robots.txt, so it is
+	// fetched to the end even if the fetch that found the host gives up.
+	go func() {
+		h.robots = p.robots(context.WithoutCancel(ctx), origin)
+		close(h.loaded)
+	}()
+	return h
+}
+
+// robots fetches and parses the robots.txt of origin.
+func (p *PoliteFetcher) robots(ctx context.Context, origin string) *robots {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, origin+"/robots.txt", nil)
+	if err != nil {
+		return disallowAll
+	}
+	req.Header.Set("User-Agent", p.UserAgent)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return disallowAll
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 500:
+		return disallowAll
+	case resp.StatusCode >= 400:
+		return allowAll
+	case resp.StatusCode >= 300:
+		// Still a redirect after the client has given up following it.
+		return allowAll
+	}
+	agent, _, _ := strings.Cut(p.UserAgent, "/")
+	return parseRobots(io.LimitReader(resp.Body, maxRobotsSize), agent)
+}
+
+// wait takes the next turn to fetch from h and waits for it, or until
+// ctx is done. Turns are delay plus up to jitter apart.
+func (h *host) wait(ctx context.Context, delay, jitter time.Duration) error {
+	if jitter > 0 {
+		delay += rand.N(jitter)
+	}
+	h.mu.Lock()
+	start := time.Now()
+	if h.next.After(start) {
+		start = h.next
+	}
+	h.next = start.Add(delay)
+	h.mu.Unlock()
+
+	d := time.Until(start)
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// robotsPath returns the part of u robots.txt rules are matched
+// against: its path, as sent, and its query.
+func robotsPath(u *url.URL) string {
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	return path
+}
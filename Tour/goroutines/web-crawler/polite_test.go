@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// politeSite is a site with the robots.txt given, recording when each
+// of its pages was requested and how many requests ran at once.
+type politeSite struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	robotsFetch int
+	starts      []time.Time
+	running     int
+	peak        int
+}
+
+func newPoliteSite(t *testing.T, robotsStatus int, robotsTxt string, pageTime time.Duration) *politeSite {
+	s := &politeSite{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.robotsFetch++
+		s.mu.Unlock()
+		w.WriteHeader(robotsStatus)
+		fmt.Fprint(w, robotsTxt)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.starts = append(s.starts, time.Now())
+		s.running++
+		s.peak = max(s.peak, s.running)
+		s.mu.Unlock()
+		time.Sleep(pageTime)
+		s.mu.Lock()
+		s.running--
+		s.mu.Unlock()
+		fmt.Fprint(w, "page")
+	})
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *politeSite) fetcher() *PoliteFetcher {
+	transport := s.Client().Transport
+	return NewPoliteFetcher(NewHTTPFetcher(transport), transport)
+}
+
+// fetchAll fetches paths from the site at once, returning the errors.
+func fetchAll(ctx context.Context, f Fetcher, s *politeSite, paths ...string) []error {
+	errs := make([]error, len(paths))
+	var wg sync.WaitGroup
+	for i, path := range paths {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, errs[i] = f.Fetch(ctx, s.URL+path)
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestPoliteFetcherObeysRobots(t *testing.T) {
+	s := newPoliteSite(t, http.StatusOK, "User-agent: *\nDisallow: /private/\n", 0)
+	f := s.fetcher()
+
+	errs := fetchAll(t.Context(), f, s, "/", "/docs/", "/private/", "/private/x", "/docs/more")
+	for i, want := range []error{nil, nil, ErrDisallowed, ErrDisallowed, nil} {
+		if !errors.Is(errs[i], want) {
+			t.Errorf("fetch %d = %v, want %v", i, errs[i], want)
+		}
+	}
+	if s.robotsFetch != 1 {
+		t.Errorf("robots.txt fetched %d times, want once", s.robotsFetch)
+	}
+	if len(s.starts) != 3 {
+		t.Errorf("site served %d pages, want 3", len(s.starts))
+	}
+}
+
+func TestPoliteFetcherWithoutRobots(t *testing.T) {
+	// A missing robots.txt allows everything ...
+	s := newPoliteSite(t, http.StatusNotFound, "", 0)
+	if _, _, err := s.fetcher().Fetch(t.Context(), s.URL+"/private/"); err != nil {
+		t.Errorf("with robots.txt missing: %v", err)
+	}
+	// ... and a failing one nothing.
+	s = newPoliteSite(t, http.StatusServiceUnavailable, "", 0)
+	if _, _, err := s.fetcher().Fetch(t.Context(), s.URL+"/"); !errors.Is(err, ErrDisallowed) {
+		t.Errorf("with robots.txt failing: %v, want ErrDisallowed", err)
+	}
+}
+
+func TestPoliteFetcherLimitsPerHost(t *testing.T) {
+	s := newPoliteSite(t, http.StatusNotFound, "", 20*time.Millisecond)
+	f := s.fetcher()
+	f.MaxPerHost = 2
+
+	paths := make([]string, 8)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("/page-%d", i)
+	}
+	for _, err := range fetchAll(t.Context(), f, s, paths...) {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if s.peak != 2 {
+		t.Errorf("%d requests ran at once, want 2", s.peak)
+	}
+}
+
+func TestPoliteFetcherWaitsBetweenRequests(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		robots string
+		delay  time.Duration
+	}{
+		{"Delay", "", 30 * time.Millisecond},
+		{"Crawl-delay", "User-agent: golearn-crawler\nCrawl-delay: 0.03\n", 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newPoliteSite(t, http.StatusOK, tt.robots, 0)
+			f := s.fetcher()
+			f.MaxPerHost, f.Delay, f.Jitter = 4, tt.delay, 10*time.Millisecond
+
+			fetchAll(t.Context(), f, s, "/a", "/b", "/c", "/d")
+			if len(s.starts) != 4 {
+				t.Fatalf("site served %d pages, want 4", len(s.starts))
+			}
+			for i := 1; i < len(s.starts); i++ {
+				// The handler notes the time a little after the request
+				// started, so allow some slack.
+				if gap := s.starts[i].Sub(s.starts[i-1]); gap < 25*time.Millisecond {
+					t.Errorf("requests %d and %d were %v apart, want at least 30ms", i-1, i, gap)
+				}
+			}
+		})
+	}
+}
+
+func TestPoliteFetcherGivesUpWaiting(t *testing.T) {
+	s := newPoliteSite(t, http.StatusOK, "User-agent: *\nCrawl-delay: 60\n", 0)
+	f := s.fetcher()
+	if _, _, err := f.Fetch(t.Context(), s.URL+"/"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(t.Context(), 20*time.Millisecond)
+	defer cancel()
+	if _, _, err := f.Fetch(ctx, s.URL+"/next"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("second fetch = %v, want it to give up waiting its turn", err)
+	}
+}
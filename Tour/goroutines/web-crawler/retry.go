@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Defaults of a RetryFetcher returned by NewRetryFetcher.
+const (
+	defaultMaxAttempts      = 3
+	defaultBaseDelay        = 500 * time.Millisecond
+	defaultMaxDelay         = 30 * time.Second
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+)
+
+// ErrCircuitOpen is returned, wrapped, for a page of a host that has
+// failed too often lately to be worth trying.
+var ErrCircuitOpen = errors.New("circuit open")
+
+// RetryFetcher is a Fetcher that fetches through another, trying again
+// when a fetch fails in a way that may not last: a timeout, a server
+// error or a server asking to be left alone for a while. Tries are
+// spaced by exponential backoff with full jitter, or as long as the
+// server asks, if it does.
+//
+// Each host has a circuit breaker. After BreakerThreshold failed tries
+// in a row, all worth retrying, fetches from the host fail at once with
+// ErrCircuitOpen for BreakerCooldown. Then one fetch is let through: if
+// it succeeds the host is back, and if not it is left alone for another
+// BreakerCooldown.
+type RetryFetcher struct {
+	Fetcher Fetcher
+	// MaxAttempts is how many times a page is tried.
+	MaxAttempts int
+	// BaseDelay is the most waited before the second try, and each try
+	// after that may wait twice as long as the one before, up to
+	// MaxDelay. A server asking for a longer wait than MaxDelay is not
+	// tried again.
+	BaseDelay, MaxDelay time.Duration
+	BreakerThreshold    int
+	BreakerCooldown     time.Duration
+	// Retryable reports whether a fetch that failed with err may be
+	// worth trying again; nil means IsRetryable.
+	Retryable func(err error) bool
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+// breaker is the circuit breaker of one host.
+type breaker struct {
+	// failures counts the failed tries in a row.
+	failures int
+	// openUntil is when the breaker, open, next lets a try through.
+	openUntil time.Time
+	// probing is set while that try runs.
+	probing bool
+}
+
+// NewRetryFetcher returns a RetryFetcher with the default limits,
+// fetching through f.
+func NewRetryFetcher(f Fetcher) *RetryFetcher {
+	return &RetryFetcher{
+		Fetcher:          f,
+		MaxAttempts:      defaultMaxAttempts,
+		BaseDelay:        defaultBaseDelay,
+		MaxDelay:         defaultMaxDelay,
+		BreakerThreshold: defaultBreakerThreshold,
+		BreakerCooldown:  defaultBreakerCooldown,
+	}
+}
+
+// Fetch implements Fetcher. The error returned is that of the last try.
+func (f *RetryFetcher) Fetch(ctx context.Context, rawURL string) (string, []string, error) {
+	retryable := f.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+	host := hostOf(rawURL)
+	for attempt := 0; ; attempt++ {
+		if !f.allow(host) {
+			return "", nil, fmt.Errorf("%s: %w", rawURL, ErrCircuitOpen)
+		}
+		body, urls, err := f.Fetcher.Fetch(ctx, rawURL)
+		if err != nil && ctx.Err() != nil {
+			// Cut short, which says nothing about the host.
+			f.abandon(host)
+			return body, urls, err
+		}
+		// Whether the host is failing is told by the same errors as
+		// whether the page is worth another try; a 404 is the host
+		// working fine.
+		failed := err != nil && retryable(err)
+		f.record(host, !failed)
+		if !failed || attempt+1 >= f.MaxAttempts {
+			return body, urls, err
+		}
+
+		// Full jitter: anywhere up to the backoff, so that pages that
+		// failed together are not all tried again together.
+		backoff := f.backoff(attempt)
+		if backoff > 0 {
+			backoff = rand.N(backoff)
+		}
+		if after := RetryAfter(err); after > 0 {
+			if after > f.MaxDelay {
+				return body, urls, err
+			}
+			backoff = max(backoff, after)
+		}
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return "", nil, ctx.Err()
+		}
+	}
+}
+
+// backoff returns the most waited after try attempt, counting from 0:
+// BaseDelay doubled attempt times, up to MaxDelay. It doubles step by
+// step, as shifting by attempt would overflow with enough attempts.
+func (f *RetryFetcher) backoff(attempt int) time.Duration {
+	d := f.BaseDelay
+	for range attempt {
+		if d > f.MaxDelay/2 {
+			return f.MaxDelay
+		}
+		d *= 2
+	}
+	return min(d, f.MaxDelay)
+}
+
+// allow reports whether a fetch from host may go ahead.
+func (f *RetryFetcher) allow(host string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b := f.breakers[host]
+	if b == nil || b.failures < f.BreakerThreshold {
+		return true
+	}
+	if b.probing || time.Now().Before(b.openUntil) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// record notes how a try of a page of host went.
+func (f *RetryFetcher) record(host string, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.breakers == nil {
+		f.breakers = make(map[string]*breaker)
+	}
+	b := f.breakers[host]
+	if b == nil {
+		b = &breaker{}
+		f.breakers[host] = b
+	}
+	b.probing = false
+	if ok {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= f.BreakerThreshold {
+		b.openUntil = time.Now().Add(f.BreakerCooldown)
+	}
+}
+
+// abandon notes that a try of a page of host was given up on.
+func (f *RetryFetcher) abandon(host string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if b := f.breakers[host]; b != nil {
+		// If it was the try let through an open breaker, the next one
+		// may go instead.
+		b.probing = false
+	}
+}
+
+// IsRetryable reports whether a fetch that failed with err may succeed
+// if tried again: it timed out, the connection failed, the name lookup
+// failed for now, or the server answered 408, 429 or a 5xx other than
+// 501 Not Implemented. Anything else, from a 404 to a page robots.txt
+// disallows, will fail the same way again.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var status *StatusError
+	if errors.As(err, &status) {
+		switch {
+		case status.Code == http.StatusRequestTimeout, status.Code == http.StatusTooManyRequests:
+			return true
+		case status.Code == http.StatusNotImplemented:
+			return false
+		}
+		return status.Code >= 500
+	}
+	var dns *net.DNSError
+	if errors.As(err, &dns) {
+		// A name that does not exist will not exist in a second either.
+		return !dns.IsNotFound && (dns.IsTemporary || dns.IsTimeout)
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF)
+}
+
+// RetryAfter returns how long the server that failed with err asked to
+// be left alone, or 0 if it did not.
+func RetryAfter(err error) time.Duration {
+	var status *StatusError
+	if errors.As(err, &status) {
+		return status.RetryAfter
+	}
+	return 0
+}
+
+// hostOf returns the host of rawURL, or "" if it has none: all the
+// addresses of a Fetcher that are not URLs share a breaker.
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Host)
+}
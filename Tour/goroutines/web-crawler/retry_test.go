@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// flakySite answers each path with the statuses listed for it in turn,
+// then 200s, counting the requests.
+func flakySite(t *testing.T, statuses map[string][]int, header http.Header) (*httptest.Server, *atomic.Int32) {
+	var requests atomic.Int32
+	var mu sync.Mutex
+	seen := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		mu.Lock()
+		n := seen[r.URL.Path]
+		seen[r.URL.Path]++
+		mu.Unlock()
+		if codes := statuses[r.URL.Path]; n < len(codes) {
+			for k, v := range header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(codes[n])
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func newTestRetryFetcher(srv *httptest.Server) *RetryFetcher {
+	f := NewRetryFetcher(NewHTTPFetcher(srv.Client().Transport))
+	f.BaseDelay, f.MaxDelay = time.Millisecond, 100*time.Millisecond
+	return f
+}
+
+func TestRetryFetcherRetries(t *testing.T) {
+	srv, requests := flakySite(t, map[string][]int{
+		"/flaky":   {503, 502},
+		"/broken":  {500, 500, 500, 500},
+		"/missing": {404, 404},
+	}, nil)
+	f := newTestRetryFetcher(srv)
+
+	tests := []struct {
+		path     string
+		wantCode int // 0 for success
+		tries    int32
+	}{
+		{"/flaky", 0, 3},
+		{"/broken", 500, 3},
+		// A 404 is not worth a second try.
+		{"/missing", 404, 1},
+	}
+	for _, tt := range tests {
+		requests.Store(0)
+		_, _, err := f.Fetch(t.Context(), srv.URL+tt.path)
+		var status *StatusError
+		switch {
+		case tt.wantCode == 0 && err != nil:
+			t.Errorf("Fetch(%s) = %v", tt.path, err)
+		case tt.wantCode != 0 && (!errors.As(err, &status) || status.Code != tt.wantCode):
+			t.Errorf("Fetch(%s) = %v, want %d", tt.path, err, tt.wantCode)
+		}
+		if n := requests.Load(); n != tt.tries {
+			t.Errorf("Fetch(%s) tried %d times, want %d", tt.path, n, tt.tries)
+		}
+	}
+}
+
+func TestRetryFetcherHonoursRetryAfter(t *testing.T) {
+	srv, requests := flakySite(t, map[string][]int{"/busy": {429}}, http.Header{"Retry-After": {"1"}})
+	f := newTestRetryFetcher(srv)
+
+	// A second is more than MaxDelay, so the fetch gives up ...
+	_, _, err := f.Fetch(t.Context(), srv.URL+"/busy")
+	if RetryAfter(err) != time.Second || requests.Load() != 1 {
+		t.Errorf("Fetch(/busy) = %v after %d tries, want the 429 at once", err, requests.Load())
+	}
+
+	// ... and with a longer MaxDelay waits the second out.
+	srv, requests = flakySite(t, map[string][]int{"/busy": {429}}, http.Header{"Retry-After": {"1"}})
+	f = newTestRetryFetcher(srv)
+	f.MaxDelay = 2 * time.Second
+	start := time.Now()
+	if _, _, err := f.Fetch(t.Context(), srv.URL+"/busy"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second || requests.Load() != 2 {
+		t.Errorf("Fetch(/busy) took %v and %d tries, want a second and 2", elapsed, requests.Load())
+	}
+}
+
+// failingFetcher fails every fetch with err, counting them.
+type failingFetcher struct {
+	err     error
+	fetches atomic.Int32
+}
+
+func (f *failingFetcher) Fetch(ctx context.Context, url string) (string, []string, error) {
+	f.fetches.Add(1)
+	return "", nil, f.err
+}
+
+func TestRetryFetcherBackoff(t *testing.T) {
+	f := &RetryFetcher{BaseDelay: time.Second, MaxDelay: time.Minute}
+	for attempt, want := range map[int]time.Duration{
+		0: time.Second, 1: 2 * time.Second, 5: 32 * time.Second, 6: time.Minute,
+		// Shifting a second by these many overflows.
+		40: time.Minute, 64: time.Minute, 1000: time.Minute,
+	} {
+		if got := f.backoff(attempt); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+}
+
+func TestRetryFetcherBreaksCircuit(t *testing.T) {
+	down := &failingFetcher{err: &StatusError{Code: http.StatusServiceUnavailable}}
+	f := NewRetryFetcher(down)
+	f.MaxAttempts, f.BreakerThreshold, f.BreakerCooldown = 1, 3, 30*time.Millisecond
+
+	for i := range 5 {
+		_, _, err := f.Fetch(t.Context(), fmt.Sprintf("https://down.test/%d", i))
+		if open := errors.Is(err, ErrCircuitOpen); open != (i >= 3) {
+			t.Errorf("fetch %d: %v", i, err)
+		}
+	}
+	if n := down.fetches.Load(); n != 3 {
+		t.Errorf("%d fetches reached the host, want 3", n)
+	}
+	// Other hosts are not held up.
+	if _, _, err := f.Fetch(t.Context(), "https://up.test/"); errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("up.test: %v", err)
+	}
+
+	// After the cooldown one fetch goes through; it failing opens the
+	// circuit again ...
+	time.Sleep(40 * time.Millisecond)
+	down.fetches.Store(0)
+	f.Fetch(t.Context(), "https://down.test/again")
+	if _, _, err := f.Fetch(t.Context(), "https://down.test/again"); !errors.Is(err, ErrCircuitOpen) || down.fetches.Load() != 1 {
+		t.Errorf("after the cooldown %d fetches reached the host, then %v", down.fetches.Load(), err)
+	}
+	// ... and it succeeding closes it.
+	time.Sleep(40 * time.Millisecond)
+	down.err = nil
+	for range 2 {
+		if _, _, err := f.Fetch(t.Context(), "https://down.test/again"); err != nil {
+			t.Errorf("host back up: %v", err)
+		}
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	timeout := &net.OpError{Op: "dial", Err: &net.DNSError{IsTimeout: true}}
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&StatusError{Code: 500}, true},
+		{&StatusError{Code: 503}, true},
+		{&StatusError{Code: 501}, false},
+		{&StatusError{Code: 429}, true},
+		{&StatusError{Code: 408}, true},
+		{&StatusError{Code: 404}, false},
+		{&StatusError{Code: 403}, false},
+		{fmt.Errorf("wrapped: %w", &StatusError{Code: 502}), true},
+		{&net.DNSError{Err: "no such host", IsNotFound: true}, false},
+		{&net.DNSError{Err: "server misbehaving", IsTemporary: true}, true},
+		{timeout, true},
+		{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, true},
+		{io.ErrUnexpectedEOF, true},
+		{context.DeadlineExceeded, true},
+		{context.Canceled, false},
+		{ErrDisallowed, false},
+		{ErrUnsupportedType, false},
+		{errors.New("not found: x"), false},
+	}
+	for _, tt := range tests {
+		if got := IsRetryable(tt.err); got != tt.want {
+			t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRetryAfterHeader(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for value, want := range map[string]time.Duration{
+		"":                              0,
+		"120":                           2 * time.Minute,
+		"-5":                            0,
+		"soon":                          0,
+		"Fri, 02 Jan 2026 03:05:05 GMT": time.Minute,
+		"Fri, 02 Jan 2026 03:00:00 GMT": 0,
+	} {
+		if got := retryAfter(value, now); got != want {
+			t.Errorf("retryAfter(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
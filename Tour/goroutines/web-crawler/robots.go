@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// robots is what a robots.txt file asks of one user agent: which paths
+// it may fetch and how long to wait between requests.
+type robots struct {
+	rules []robotsRule
+	// delay is the Crawl-delay, or 0 if there is none.
+	delay time.Duration
+	// sitemaps are the URLs of the Sitemap lines, which are for every
+	// agent.
+	sitemaps []string
+}
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+// allowAll is the robots of a site without a robots.txt.
+var allowAll = &robots{}
+
+// disallowAll is the robots of a site whose robots.txt could not be
+// read: a server that is failing may be failing because of crawlers.
+var disallowAll = &robots{rules: []robotsRule{{allow: false, pattern: "/"}}}
+
+// parseRobots reads the robots.txt in r and returns the group of rules
+// for agent, a product token such as "golearn-crawler". Groups naming
+// agent, compared without regard to case, are used if there are any,
+// and the * groups otherwise. Lines it does not understand are skipped,
+// as RFC 9309 asks.
+func parseRobots(r io.Reader, agent string) *robots {
+	var named, star robots
+	var foundNamed bool
+	var sitemaps []string
+	// The agents the current group applies to, and whether its rules
+	// have started, after which a User-agent line starts a new group.
+	var forNamed, forAny, inRules bool
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+		switch key {
+		case "user-agent":
+			if inRules {
+				forNamed, forAny, inRules = false, false, false
+			}
+			switch {
+			case value == "*":
+				forAny = true
+			case strings.EqualFold(value, agent):
+				forNamed, foundNamed = true, true
+			}
+		case "allow", "disallow":
+			inRules = true
+			// An empty Disallow disallows nothing; an empty Allow allows
+			// nothing more than was allowed.
+			if value == "" {
+				continue
+			}
+			rule := robotsRule{allow: key == "allow", pattern: value}
+			if forNamed {
+				named.rules = append(named.rules, rule)
+			}
+			if forAny {
+				star.rules = append(star.rules, rule)
+			}
+		case "sitemap":
+			// Not part of any group, so it neither ends nor starts one.
+			if value != "" {
+				sitemaps = append(sitemaps, value)
+			}
+		case "crawl-delay":
+			inRules = true
+			secs, err := strconv.ParseFloat(value, 64)
+			if err != nil || secs < 0 {
+				continue
+			}
+			delay := time.Duration(secs * float64(time.Second))
+			if forNamed {
+				named.delay = delay
+			}
+			if forAny {
+				star.delay = delay
+			}
+		}
+	}
+	// A file too long to scan is used as far as it was read.
+	named.sitemaps, star.sitemaps = sitemaps, sitemaps
+	if foundNamed {
+		return &named
+	}
+	return &star
+}
+
+// allowed reports whether path, with its query if it has one, may be
+// fetched. The rule with the longest pattern matching path decides, and
+// an Allow beats a Disallow as long as it.
+func (r *robots) allowed(path string) bool {
+	if path == "/robots.txt" {
+		return true
+	}
+	allow, longest := true, -1
+	for _, rule := range r.rules {
+		if !robotsMatch(rule.pattern, path) {
+			continue
+		}
+		if n := len(rule.pattern); n > longest || n == longest && rule.allow {
+			allow, longest = rule.allow, n
+		}
+	}
+	return allow
+}
+
+// robotsMatch reports whether path matches pattern, a path prefix in
+// which * matches any run of characters and a trailing $ the end of the
+// path.
+func robotsMatch(pattern, path string) bool {
+	pattern, anchored := strings.CutSuffix(pattern, "$")
+	parts := strings.Split(pattern, "*")
+	rest, ok := strings.CutPrefix(path, parts[0])
+	if !ok {
+		return false
+	}
+	if len(parts) == 1 {
+		return !anchored || rest == ""
+	}
+	// Matching each middle part as early as possible leaves the most
+	// room for the rest.
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(rest, part)
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+len(part):]
+	}
+	last := parts[len(parts)-1]
+	if anchored {
+		return strings.HasSuffix(rest, last)
+	}
+	return strings.Contains(rest, last)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testRobots = `# Everyone but us keeps out.
+User-agent: *
+Disallow: /
+Crawl-delay: 10
+
+User-agent: other-bot
+User-Agent: GOLEARN-CRAWLER
+Disallow: /private/
+Allow: /private/open
+Disallow: /*.pdf$
+Sitemap: https://example.com/sitemap.xml
+Disallow: /search?
+Crawl-delay: 0.5
+
+User-agent: other-bot
+Disallow: /other-only
+
+sitemap: https://example.com/news.xml.gz
+`
+
+func TestParseRobots(t *testing.T) {
+	r := parseRobots(strings.NewReader(testRobots), "golearn-crawler")
+	if r.delay != 500*time.Millisecond {
+		t.Errorf("delay = %v, want 500ms", r.delay)
+	}
+	if want := []string{"https://example.com/sitemap.xml", "https://example.com/news.xml.gz"}; !slices.Equal(r.sitemaps, want) {
+		t.Errorf("sitemaps = %q, want %q", r.sitemaps, want)
+	}
+	for path, want := range map[string]bool{
+		"/":                   true,
+		"/private":            true,
+		"/private/":           false,
+		"/private/x":          false,
+		"/private/open":       true,
+		"/private/opener":     true,
+		"/docs/guide.pdf":     false,
+		"/docs/guide.pdf?v=2": true,
+		"/search":             true,
+		"/search?q=go":        false,
+		"/other-only":         true,
+		"/robots.txt":         true,
+	} {
+		if got := r.allowed(path); got != want {
+			t.Errorf("allowed(%s) = %v, want %v", path, got, want)
+		}
+	}
+
+	// An agent with no group of its own gets the * group.
+	r = parseRobots(strings.NewReader(testRobots), "someone-else")
+	if r.allowed("/docs/") || r.delay != 10*time.Second {
+		t.Errorf("someone-else got %+v, want the * group", r)
+	}
+	// ... and with no * group either, everything.
+	r = parseRobots(strings.NewReader("User-agent: x\nDisallow: /\n"), "golearn-crawler")
+	if !r.allowed("/docs/") {
+		t.Errorf("a group for another agent applied to golearn-crawler")
+	}
+}
+
+func TestRobotsMatch(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"/fish", "/fish", true},
+		{"/fish", "/fish.html", true},
+		{"/fish", "/Fish", false},
+		{"/fish$", "/fish", true},
+		{"/fish$", "/fish/", false},
+		{"/*.php", "/index.php", true},
+		{"/*.php", "/folder/x.php?a=b", true},
+		{"/*.php$", "/x.php?a=b", false},
+		{"/fish*.php", "/fishheads/catfish.php", true},
+		{"/fish*.php", "/fish.asp", false},
+		{"/a*b*c", "/abc", true},
+		{"/a*b*c", "/acb", false},
+	}
+	for _, tt := range tests {
+		if got := robotsMatch(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("robotsMatch(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Scope decides which links a crawl follows. A URL is in scope if it
+// matches at least one Include rule, or there are none, and no Exclude
+// rule. URLs are matched in the form NormalizeURL gives them.
+type Scope struct {
+	Include []ScopeRule
+	Exclude []ScopeRule
+}
+
+// ScopeRule matches the URLs that match all of its fields that are set.
+// A rule with none set matches every URL.
+type ScopeRule struct {
+	// Host matches a URL with that host name, whatever its port, or,
+	// if it starts with "*.", with the rest as host name or as a domain
+	// of its host name: "*.golang.org" matches golang.org and
+	// go.golang.org.
+	Host string
+	// PathPrefix matches a URL whose path starts with it. "/pkg" matches
+	// /pkg/fmt/ but also /pkgsite; "/pkg/" only the first.
+	PathPrefix string
+	// Pattern matches a URL, whole, with query, in which it finds a
+	// match.
+	Pattern *regexp.Regexp
+}
+
+// Contains reports whether rawURL is in scope. Everything is in the
+// scope of a nil Scope.
+func (s *Scope) Contains(rawURL string) bool {
+	if s == nil {
+		return true
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	if len(s.Include) > 0 && !matchAny(s.Include, rawURL, u) {
+		return false
+	}
+	return !matchAny(s.Exclude, rawURL, u)
+}
+
+func matchAny(rules []ScopeRule, rawURL string, u *url.URL) bool {
+	for _, r := range rules {
+		if r.matches(rawURL, u) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r ScopeRule) matches(rawURL string, u *url.URL) bool {
+	if r.Host != "" && !matchHost(r.Host, u.Hostname()) {
+		return false
+	}
+	if r.PathPrefix != "" && !strings.HasPrefix(u.Path, r.PathPrefix) {
+		return false
+	}
+	if r.Pattern != nil && !r.Pattern.MatchString(rawURL) {
+		return false
+	}
+	return true
+}
+
+func matchHost(pattern, host string) bool {
+	pattern = strings.ToLower(pattern)
+	domain, ok := strings.CutPrefix(pattern, "*.")
+	if !ok {
+		return host == pattern
+	}
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestScope(t *testing.T) {
+	s := &Scope{
+		Include: []ScopeRule{
+			{Host: "golang.org", PathPrefix: "/pkg/"},
+			{Host: "*.go.dev"},
+		},
+		Exclude: []ScopeRule{
+			{PathPrefix: "/pkg/internal/"},
+			{Pattern: regexp.MustCompile(`\.(zip|tar\.gz)$`)},
+		},
+	}
+	for u, want := range map[string]bool{
+		"https://golang.org/pkg/fmt/":          true,
+		"https://golang.org:8080/pkg/fmt/":     true,
+		"https://golang.org/cmd/":              false,
+		"https://golang.org/pkg/internal/poll": false,
+		"https://go.dev/":                      true,
+		"https://pkg.go.dev/fmt":               true,
+		"https://notgo.dev/":                   false,
+		"https://go.dev/dl/go1.24.src.tar.gz":  false,
+		"https://example.com/pkg/":             false,
+	} {
+		if got := s.Contains(u); got != want {
+			t.Errorf("Contains(%s) = %v, want %v", u, got, want)
+		}
+	}
+
+	// With no Include rules everything not excluded is in scope.
+	s = &Scope{Exclude: []ScopeRule{{Host: "example.com"}}}
+	if !s.Contains("https://golang.org/") || s.Contains("https://example.com/") {
+		t.Errorf("exclude-only scope got it wrong")
+	}
+	if !(*Scope)(nil).Contains("anything") {
+		t.Errorf("nil Scope does not contain everything")
+	}
+}
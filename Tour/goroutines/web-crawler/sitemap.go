@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits of a sitemap, from sitemaps.org; a SitemapReader reads no
+// more of one.
+const (
+	maxSitemapURLs = 50_000
+	maxSitemapSize = 50 << 20 // 50 MiB, uncompressed
+	// maxSitemaps is how many sitemaps a SitemapReader reads for a
+	// site, indexes included.
+	maxSitemaps = 100
+)
+
+// SitemapReader reads the pages a site lists in its sitemaps, to seed a
+// crawl with pages no link leads to.
+type SitemapReader struct {
+	polite *PoliteFetcher
+}
+
+// NewSitemapReader returns a SitemapReader fetching sitemaps as p
+// fetches pages: with its client and user agent, only where robots.txt
+// allows, and taking turns with the pages of the same host.
+func NewSitemapReader(p *PoliteFetcher) *SitemapReader {
+	return &SitemapReader{polite: p}
+}
+
+// Discover returns the pages listed in the sitemaps of the site siteURL
+// is on: those its robots.txt names, or its /sitemap.xml if it names
+// none. Each seed's Source is the sitemap listing it. The error joins
+// those of the sitemaps that could not be read; the pages of those that
+// could are returned all the same.
+func (s *SitemapReader) Discover(ctx context.Context, siteURL string) ([]Seed, error) {
+	u, err := url.Parse(siteURL)
+	if err != nil {
+		return nil, err
+	}
+	sitemaps, err := s.polite.sitemaps(ctx, siteURL)
+	if err != nil {
+		return nil, err
+	}
+	if len(sitemaps) == 0 {
+		sitemaps = []string{u.Scheme + "://" + u.Host + "/sitemap.xml"}
+	}
+	return s.read(ctx, sitemaps)
+}
+
+// Read returns the pages listed in the sitemap at sitemapURL, and in
+// the sitemaps it lists if it is a sitemap index.
+func (s *SitemapReader) Read(ctx context.Context, sitemapURL string) ([]Seed, error) {
+	return s.read(ctx, []string{sitemapURL})
+}
+
+func (s *SitemapReader) read(ctx context.Context, sitemaps []string) ([]Seed, error) {
+	var seeds []Seed
+	var errs []error
+	seen := make(map[string]bool)
+	for i := 0; i < len(sitemaps) && i < maxSitemaps; i++ {
+		sitemapURL := sitemaps[i]
+		if seen[sitemapURL] {
+			continue
+		}
+		seen[sitemapURL] = true
+		pages, more, err := s.fetch(ctx, sitemapURL)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, page := range pages {
+			seeds = append(seeds, Seed{URL: page, Source: sitemapURL})
+		}
+		sitemaps = append(sitemaps, more...)
+	}
+	return seeds, errors.Join(errs...)
+}
+
+// fetch fetches and parses the sitemap at sitemapURL, gzipped or not,
+// returning the pages it lists, or, if it is an index, the sitemaps.
+func (s *SitemapReader) fetch(ctx context.Context, sitemapURL string) (pages, sitemaps []string, err error) {
+	release, err := s.polite.admit(ctx, sitemapURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer release()
+	resp, err := s.get(ctx, sitemapURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, nil, &StatusError{URL: sitemapURL, Code: resp.StatusCode}
+	}
+
+	// Gzipped sitemaps are files that happen to be compressed, not a
+	// Content-Encoding, so are told by their first bytes.
+	br := bufio.NewReader(resp.Body)
+	var r io.Reader = br
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", sitemapURL, err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+	pages, sitemaps, err = parseSitemap(io.LimitReader(r, maxSitemapSize))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", sitemapURL, err)
+	}
+	return pages, sitemaps, nil
+}
+
+func (s *SitemapReader) get(ctx context.Context, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", s.polite.UserAgent)
+	return s.polite.client.Do(req)
+}
+
+// parseSitemap reads a sitemap, a <urlset> of pages, or a sitemap index,
+// a <sitemapindex> of sitemaps, returning the <loc> of each entry, up to
+// maxSitemapURLs. Namespaces are not checked: plenty of sitemaps get
+// them wrong, and there is nothing else they could be.
+func parseSitemap(r io.Reader) (pages, sitemaps []string, err error) {
+	var doc struct {
+		XMLName  xml.Name
+		URLs     []sitemapEntry `xml:"url"`
+		Sitemaps []sitemapEntry `xml:"sitemap"`
+	}
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, nil, err
+	}
+	var entries []sitemapEntry
+	switch doc.XMLName.Local {
+	case "urlset":
+		entries = doc.URLs
+	case "sitemapindex":
+		entries = doc.Sitemaps
+	default:
+		return nil, nil, fmt.Errorf("not a sitemap: <%s>", doc.XMLName.Local)
+	}
+	var locs []string
+	for _, e := range entries[:min(len(entries), maxSitemapURLs)] {
+		if loc := strings.TrimSpace(e.Loc); loc != "" {
+			locs = append(locs, loc)
+		}
+	}
+	if doc.XMLName.Local == "sitemapindex" {
+		return nil, locs, nil
+	}
+	return locs, nil, nil
+}
+
+type sitemapEntry struct {
+	Loc string `xml:"loc"`
+}
+
+// LastModified keeps the Last-Modified header of the pages an
+// HTTPFetcher fetches, for a sitemap of the crawl: set the fetcher's
+// OnResponse to its Record method.
+type LastModified struct {
+	mu    sync.Mutex
+	times map[string]time.Time
+}
+
+// Record records the Last-Modified header of resp, the response to a
+// fetch of url, if it has one.
+func (l *LastModified) Record(url string, resp *http.Response) {
+	t, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.times == nil {
+		l.times = make(map[string]time.Time)
+	}
+	l.times[pageKey(canonical(url))] = t
+}
+
+// Get returns the Last-Modified time recorded for url, or the zero time
+// if there is none.
+func (l *LastModified) Get(url string) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.times[pageKey(canonical(url))]
+}
+
+// SitemapURL is a page of a sitemap.
+type SitemapURL struct {
+	Loc string
+	// LastMod is when the page last changed, or the zero time if that is
+	// not known.
+	LastMod time.Time
+}
+
+// Sitemap returns the pages g fetched, by URL, for a sitemap. lastMod,
+// if not nil, tells when each last changed.
+func (g *Graph) Sitemap(lastMod func(url string) time.Time) []SitemapURL {
+	var urls []SitemapURL
+	for _, n := range g.nodes {
+		if n.Status != PageFetched {
+			continue
+		}
+		u := SitemapURL{Loc: n.URL}
+		if lastMod != nil {
+			u.LastMod = lastMod(n.URL)
+		}
+		urls = append(urls, u)
+	}
+	slices.SortFunc(urls, func(a, b SitemapURL) int { return strings.Compare(a.Loc, b.Loc) })
+	return urls
+}
+
+// WriteSitemap writes a sitemap.xml of urls, which must be absolute and
+// no more than a sitemap may hold.
+func WriteSitemap(w io.Writer, urls []SitemapURL) error {
+	if len(urls) > maxSitemapURLs {
+		return fmt.Errorf("%d pages are more than the %d a sitemap may list", len(urls), maxSitemapURLs)
+	}
+	type entry struct {
+		Loc     string `xml:"loc"`
+		LastMod string `xml:"lastmod,omitempty"`
+	}
+	doc := struct {
+		XMLName xml.Name `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+		URLs    []entry  `xml:"url"`
+	}{}
+	for _, u := range urls {
+		if parsed, err := url.Parse(u.Loc); err != nil || !parsed.IsAbs() {
+			return fmt.Errorf("%s: sitemaps list absolute URLs", u.Loc)
+		}
+		e := entry{Loc: u.Loc}
+		if !u.LastMod.IsZero() {
+			e.LastMod = u.LastMod.UTC().Format(time.RFC3339)
+		}
+		doc.URLs = append(doc.URLs, e)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newSitemapSite serves a site whose robots.txt, if withRobots, names a
+// sitemap index of two sitemaps, one gzipped, and a missing one.
+// Without it, the site has only /sitemap.xml.
+func newSitemapSite(t *testing.T, withRobots bool) *httptest.Server {
+	mux := http.NewServeMux()
+	xmlPage := func(body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/xml")
+			fmt.Fprintf(w, body, "http://"+r.Host)
+		}
+	}
+	if withRobots {
+		mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "User-agent: *\nDisallow:\n\nSitemap: http://%s/index.xml\n", r.Host)
+		})
+	}
+	mux.HandleFunc("/index.xml", xmlPage(`<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>%[1]s/pages.xml</loc></sitemap>
+  <sitemap><loc> %[1]s/more.xml.gz </loc></sitemap>
+  <sitemap><loc>%[1]s/missing.xml</loc></sitemap>
+  <sitemap><loc>%[1]s/index.xml</loc></sitemap>
+</sitemapindex>`))
+	mux.HandleFunc("/pages.xml", xmlPage(`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>%[1]s/</loc><lastmod>2026-01-01</lastmod></url>
+  <url><loc>%[1]s/hidden</loc></url>
+</urlset>`))
+	mux.HandleFunc("/more.xml.gz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/gzip")
+		zw := gzip.NewWriter(w)
+		fmt.Fprintf(zw, `<urlset><url><loc>http://%s/archive</loc></url></urlset>`, r.Host)
+		zw.Close()
+	})
+	mux.HandleFunc("/sitemap.xml", xmlPage(`<urlset><url><loc>%[1]s/only</loc></url></urlset>`))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// sitemapReader returns a SitemapReader for the site srv.
+func sitemapReader(srv *httptest.Server) *SitemapReader {
+	transport := srv.Client().Transport
+	return NewSitemapReader(NewPoliteFetcher(NewHTTPFetcher(transport), transport))
+}
+
+func TestSitemapReaderDiscover(t *testing.T) {
+	srv := newSitemapSite(t, true)
+	seeds, err := sitemapReader(srv).Discover(t.Context(), srv.URL+"/docs/")
+
+	want := []Seed{
+		{srv.URL + "/", srv.URL + "/pages.xml"},
+		{srv.URL + "/hidden", srv.URL + "/pages.xml"},
+		{srv.URL + "/archive", srv.URL + "/more.xml.gz"},
+	}
+	if !slices.Equal(seeds, want) {
+		t.Errorf("seeds =\n%q\nwant\n%q", seeds, want)
+	}
+	// The missing sitemap is reported, and does not stop the others
+	// being read.
+	if err == nil || !strings.Contains(err.Error(), "/missing.xml: 404") {
+		t.Errorf("err = %v, want the missing sitemap", err)
+	}
+
+	// A site with no robots.txt has its sitemap where it usually is.
+	srv = newSitemapSite(t, false)
+	seeds, err = sitemapReader(srv).Discover(t.Context(), srv.URL)
+	if err != nil || !slices.Equal(seeds, []Seed{{srv.URL + "/only", srv.URL + "/sitemap.xml"}}) {
+		t.Errorf("without robots.txt: %q, %v", seeds, err)
+	}
+}
+
+func TestSitemapReaderIsPolite(t *testing.T) {
+	var mu sync.Mutex
+	var robotsFetch int
+	var fetched []string
+	var starts []time.Time
+	mux := http.NewServeMux()
+	mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		robotsFetch++
+		mu.Unlock()
+		fmt.Fprintf(w, "User-agent: *\nDisallow: /private/\nCrawl-delay: 0.03\n\n"+
+			"Sitemap: http://%[1]s/private/map.xml\nSitemap: http://%[1]s/a.xml\nSitemap: http://%[1]s/b.xml\n", r.Host)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetched = append(fetched, r.URL.Path)
+		starts = append(starts, time.Now())
+		mu.Unlock()
+		fmt.Fprintf(w, `<urlset><url><loc>http://%s/page</loc></url></urlset>`, r.Host)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	transport := srv.Client().Transport
+	pf := NewPoliteFetcher(NewHTTPFetcher(transport), transport)
+	seeds, err := NewSitemapReader(pf).Discover(t.Context(), srv.URL)
+	if !errors.Is(err, ErrDisallowed) {
+		t.Errorf("err = %v, want the disallowed sitemap", err)
+	}
+	if len(seeds) != 2 {
+		t.Errorf("seeds = %q, want the pages of the two allowed sitemaps", seeds)
+	}
+	// The crawl goes on to fetch pages through the same fetcher.
+	if _, _, err := pf.Fetch(t.Context(), srv.URL+"/page"); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(fetched, []string{"/a.xml", "/b.xml", "/page"}) {
+		t.Errorf("site served %q, want the allowed sitemaps, then the page", fetched)
+	}
+	if robotsFetch != 1 {
+		t.Errorf("robots.txt fetched %d times, want once", robotsFetch)
+	}
+	for i := 1; i < len(starts); i++ {
+		// As in TestPoliteFetcherWaitsBetweenRequests, allow some slack.
+		if gap := starts[i].Sub(starts[i-1]); gap < 25*time.Millisecond {
+			t.Errorf("requests for %s and %s were %v apart, want at least 30ms", fetched[i-1], fetched[i], gap)
+		}
+	}
+}
+
+func TestParseSitemap(t *testing.T) {
+	for _, bad := range []string{"<html><body>Not found</body></html>", "<urlset><url><loc>x", ""} {
+		if _, _, err := parseSitemap(strings.NewReader(bad)); err == nil {
+			t.Errorf("parseSitemap(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestCrawlerSeeds(t *testing.T) {
+	site := fakeFetcher{
+		"a":      {"A", []string{"b"}},
+		"b":      {"B", nil},
+		"hidden": {"Hidden", []string{"b"}},
+	}
+	var g Graph
+	c := &Crawler{
+		Fetcher: site,
+		Seeds:   []Seed{{"hidden", "sitemap"}, {"b", "sitemap"}, {"elsewhere", "sitemap"}},
+		Scope:   &Scope{Exclude: []ScopeRule{{Pattern: regexp.MustCompile("^elsewhere$")}}},
+		OnPage:  g.Add,
+	}
+	if sum := c.Crawl(t.Context(), "a", 2); sum.Fetched != 3 || sum.Failed != 0 {
+		t.Errorf("summary = %+v, want a, b and hidden fetched", sum)
+	}
+	// hidden is only in the sitemap; b is linked to as well.
+	if s := g.Stats(); !slices.Equal(s.Orphans, []string{"hidden"}) {
+		t.Errorf("orphans = %q, want hidden", s.Orphans)
+	}
+}
+
+func TestWriteSitemap(t *testing.T) {
+	modified := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+		fmt.Fprint(w, `<a href="/b?x=1&amp;y=2">B</a>`)
+	})
+	mux.HandleFunc("/b", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "B")
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	var lastMod LastModified
+	f := NewHTTPFetcher(srv.Client().Transport)
+	f.OnResponse = lastMod.Record
+	var g Graph
+	(&Crawler{Fetcher: f, OnPage: g.Add}).Crawl(t.Context(), srv.URL, 2)
+
+	var buf bytes.Buffer
+	if err := WriteSitemap(&buf, g.Sitemap(lastMod.Get)); err != nil {
+		t.Fatal(err)
+	}
+	want := xml.Header + `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url>
+    <loc>` + srv.URL + `/</loc>
+    <lastmod>2026-03-04T05:06:07Z</lastmod>
+  </url>
+  <url>
+    <loc>` + srv.URL + `/b?x=1&amp;y=2</loc>
+  </url>
+</urlset>
+`
+	if buf.String() != want {
+		t.Errorf("sitemap =\n%s\nwant\n%s", buf.String(), want)
+	}
+	// What it writes, it reads.
+	pages, _, err := parseSitemap(&buf)
+	if err != nil || !slices.Equal(pages, []string{srv.URL + "/", srv.URL + "/b?x=1&y=2"}) {
+		t.Errorf("sitemap reads back as %q, %v", pages, err)
+	}
+
+	if err := WriteSitemap(&buf, []SitemapURL{{Loc: "/relative"}}); err == nil {
+		t.Errorf("WriteSitemap took a relative URL")
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// State keeps a crawl's frontier and the pages it has seen in a file,
+// so that a crawl stopped part way, by a crash as much as by a timeout,
+// can be picked up where it left off by crawling again with the same
+// State.
+//
+// The file is a journal, one JSON entry to a line: each URL queued, and
+// each URL fetched. A page whose fetch failed is not done with: it stays
+// queued, to be tried again when the crawl resumes. It is appended to once for each page, after the
+// page's links are queued, so a crawl that dies loses at most the pages
+// being fetched, which are still queued in the journal and fetched again
+// when the crawl resumes. The journal grows with the number of pages
+// seen, like the crawler's memory, and no faster.
+type State struct {
+	file *os.File
+	w    *bufio.Writer
+
+	// What the journal holds, by the pageKey of the URLs: the pages done
+	// with, and the latest entry for each page queued, in the order they
+	// were first queued, with the index of each in pending.
+	done    map[string]bool
+	pending []stateEntry
+	queued  map[string]int
+}
+
+// stateEntry is a line of a State's journal.
+type stateEntry struct {
+	// Op is "queue" or "done".
+	Op     string `json:"op"`
+	URL    string `json:"url"`
+	Parent string `json:"parent,omitempty"`
+	Depth  int    `json:"depth,omitempty"`
+}
+
+// OpenState opens the crawl state kept in the file at path, creating
+// it if it does not exist.
+func OpenState(path string) (*State, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s := &State{file: file, done: make(map[string]bool), queued: make(map[string]int)}
+	if err := s.replay(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	s.w = bufio.NewWriter(file)
+	return s, nil
+}
+
+// replay reads the journal, leaving the file positioned after its last
+// whole entry. A last entry cut short by a crash mid-write is dropped.
+func (s *State) replay() error {
+	r := bufio.NewReader(s.file)
+	var end int64
+	for line := 1; ; line++ {
+		b, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// A line without its newline is a write that did not finish.
+			break
+		}
+		if err != nil {
+			return err
+		}
+		var e stateEntry
+		if err := json.Unmarshal(bytes.TrimSpace(b), &e); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		end += int64(len(b))
+
+		if e.Op != "queue" && e.Op != "done" {
+			return fmt.Errorf("line %d: unknown op %q", line, e.Op)
+		}
+		s.apply(e)
+	}
+	if err := s.file.Truncate(end); err != nil {
+		return err
+	}
+	_, err := s.file.Seek(end, io.SeekStart)
+	return err
+}
+
+// apply updates what s holds with an entry of the journal.
+func (s *State) apply(e stateEntry) {
+	key := pageKey(e.URL)
+	if e.Op == "done" {
+		s.done[key] = true
+		return
+	}
+	if i, ok := s.queued[key]; ok {
+		// Queued again, at a shallower depth.
+		s.pending[i] = e
+		return
+	}
+	s.queued[key] = len(s.pending)
+	s.pending = append(s.pending, e)
+}
+
+// Close writes out what has not been and closes the file.
+func (s *State) Close() error {
+	err := s.w.Flush()
+	if serr := s.file.Sync(); err == nil {
+		err = serr
+	}
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// restore loads f with the pages done with, which it will not queue
+// again, and those queued and not done, which it queues in the order
+// they were found.
+func (s *State) restore(f *frontier) {
+	f.init()
+	for key := range s.done {
+		f.seen[key] = true
+	}
+	for _, e := range s.pending {
+		if !s.done[pageKey(e.URL)] {
+			f.push(e.URL, e.Parent, e.Depth)
+		}
+	}
+}
+
+// queue records that t was queued.
+func (s *State) queue(t *task) {
+	s.write(stateEntry{Op: "queue", URL: t.url, Parent: t.parent, Depth: t.depth})
+}
+
+// finish records that url is done with and flushes the entries
+// recorded since the last call.
+func (s *State) finish(url string) error {
+	s.write(stateEntry{Op: "done", URL: url})
+	return s.flush()
+}
+
+func (s *State) write(e stateEntry) {
+	s.apply(e)
+	b, err := json.Marshal(e)
+	if err != nil {
+		panic(err) // strings and an int always marshal
+	}
+	// The writer keeps its first error, which flush returns.
+	s.w.Write(append(b, '\n'))
+}
+
+// flush writes out the entries recorded so far and syncs them to
+// disk, so that they outlive a crash of the machine, not only of the
+// crawl.
+func (s *State) flush() error {
+	if err := s.w.Flush(); err != nil {
+		return fmt.Errorf("saving crawl state: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("saving crawl state: %w", err)
+	}
+	return nil
+}
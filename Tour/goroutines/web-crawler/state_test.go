@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openState(t *testing.T, path string) *State {
+	s, err := OpenState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestStateResumesCrawl(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "crawl.state")
+
+	// The first crawl is stopped after two pages ...
+	ctx, cancel := context.WithCancel(t.Context())
+	first := &countingFetcher{Fetcher: fetcher}
+	state := openState(t, path)
+	sum := (&Crawler{Fetcher: &cancellingFetcher{Fetcher: first, after: 2, cancel: cancel}, Workers: 1, State: state}).Crawl(ctx, "https://golang.org/", 4)
+	if err := state.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if sum.Fetched != 2 || len(sum.Abandoned) != 3 {
+		t.Fatalf("first crawl: %+v, want 2 fetched and 3 abandoned", sum)
+	}
+
+	// ... and the second fetches the other three, and only those.
+	state = openState(t, path)
+	defer state.Close()
+	if len(state.done) != 2 || len(state.pending) != 5 {
+		t.Errorf("state has %d done of %d queued, want 2 of 5", len(state.done), len(state.pending))
+	}
+	second := &countingFetcher{Fetcher: fetcher}
+	sum = (&Crawler{Fetcher: second, Workers: 1, State: state}).Crawl(t.Context(), "https://golang.org/", 4)
+	if sum.Fetched != 3 || sum.Err != nil || len(sum.Abandoned) != 0 {
+		t.Errorf("second crawl: %+v, want 3 fetched", sum)
+	}
+	for _, u := range second.fetched() {
+		if first.counts[u] > 0 {
+			t.Errorf("%s fetched by both crawls", u)
+		}
+	}
+
+	// A third finds nothing left to do.
+	third := &countingFetcher{Fetcher: fetcher}
+	if sum := (&Crawler{Fetcher: third, State: state}).Crawl(t.Context(), "https://golang.org/", 4); sum.Fetched != 0 {
+		t.Errorf("third crawl: %+v, want nothing fetched", sum)
+	}
+}
+
+func TestStateRefetchesPagesInFlight(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "crawl.state")
+	site := fakeFetcher{"a": {"A", []string{"hang", "b"}}, "b": {"B", nil}}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	state := openState(t, path)
+	(&Crawler{Fetcher: blockingFetcher{site}, Workers: 1, State: state}).Crawl(ctx, "a", 3)
+	state.Close()
+
+	// hang was being fetched when the crawl stopped, so it is fetched
+	// again, as is b, which was still queued.
+	site["hang"] = &fakeResult{"Hang", nil}
+	state = openState(t, path)
+	defer state.Close()
+	f := &countingFetcher{Fetcher: site}
+	(&Crawler{Fetcher: f, Workers: 1, State: state}).Crawl(t.Context(), "a", 3)
+	if got := f.fetched(); !slices.Equal(got, []string{"b", "hang"}) {
+		t.Errorf("resumed crawl fetched %q, want b and hang", got)
+	}
+}
+
+func TestStateJournal(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, journal string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(journal), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	// The last entry was cut short by a crash: it is dropped, and the
+	// journal carries on from the entry before.
+	path := write("torn", `{"op":"queue","url":"a"}
+{"op":"queue","url":"b","parent":"a","depth":2}
+{"op":"queue","url":"b","parent":"c","depth":1}
+{"op":"done","url":"a"}
+{"op":"queue","url":"d","par`)
+	s := openState(t, path)
+	want := []stateEntry{{Op: "queue", URL: "a"}, {Op: "queue", URL: "b", Parent: "c", Depth: 1}}
+	if !slices.Equal(s.pending, want) || !s.done["a"] || s.done["b"] {
+		t.Errorf("pending %+v, done %v; want %+v and a", s.pending, s.done, want)
+	}
+	s.queue(&task{url: "e", parent: "b", depth: 2})
+	if err := s.finish("b"); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	b, _ := os.ReadFile(path)
+	if !strings.HasSuffix(string(b), "{\"op\":\"done\",\"url\":\"a\"}\n{\"op\":\"queue\",\"url\":\"e\",\"parent\":\"b\",\"depth\":2}\n{\"op\":\"done\",\"url\":\"b\"}\n") {
+		t.Errorf("journal after appending:\n%s", b)
+	}
+
+	// Anything else wrong with it is not for OpenState to guess at.
+	path = write("corrupt", "{\"op\":\"queue\",\"url\":\"a\"}\nnot json\n{\"op\":\"done\",\"url\":\"a\"}\n")
+	if _, err := OpenState(path); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("OpenState(corrupt) = %v, want an error at line 2", err)
+	}
+}
+
+// outageFetcher fails the first fetch of each page in down, then
+// fetches it from the Fetcher it wraps.
+type outageFetcher struct {
+	Fetcher
+	down map[string]bool
+}
+
+func (f *outageFetcher) Fetch(ctx context.Context, url string) (string, []string, error) {
+	if f.down[url] {
+		delete(f.down, url)
+		return "", nil, &StatusError{URL: url, Code: 503}
+	}
+	return f.Fetcher.Fetch(ctx, url)
+}
+
+func TestStateRetriesFailedPages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "crawl.state")
+	f := &outageFetcher{Fetcher: fetcher, down: map[string]bool{"https://golang.org/pkg/": true}}
+
+	state := openState(t, path)
+	sum := (&Crawler{Fetcher: f, Workers: 1, State: state}).Crawl(t.Context(), "https://golang.org/", 4)
+	state.Close()
+	if sum.Failed != 1 || sum.Fetched != 2 {
+		t.Fatalf("first crawl: %+v, want /pkg/ failed and 2 fetched", sum)
+	}
+
+	// The outage is over: the resumed crawl fetches /pkg/, and the pages
+	// only it links to.
+	state = openState(t, path)
+	defer state.Close()
+	second := &countingFetcher{Fetcher: f}
+	sum = (&Crawler{Fetcher: second, Workers: 1, State: state}).Crawl(t.Context(), "https://golang.org/", 4)
+	want := []string{"https://golang.org/pkg/", "https://golang.org/pkg/fmt/", "https://golang.org/pkg/os/"}
+	if got := second.fetched(); sum.Failed != 0 || !slices.Equal(got, want) {
+		t.Errorf("resumed crawl fetched %q with %d failed, want %q", got, sum.Failed, want)
+	}
+}
@@ -1,83 +1,213 @@
 package main
 
 import (
+	"cmp"
+	"context"
+	"flag"
 	"fmt"
-	"sync" // Import the sync package for Mutex and WaitGroup
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
 )
 
 // Fetcher interface (provided in the exercise)
 type Fetcher interface {
 	// Fetch returns the body of URL and
 	// a slice of URLs found on that page.
-	Fetch(url string) (body string, urls []string, err error)
+	// It gives up once ctx is done.
+	Fetch(ctx context.Context, url string) (body string, urls []string, err error)
 }
 
-// --- Concurrency Safety for Visited URLs ---
+// --- Main Function ---
 
-// fetchedData holds the shared state for URLs that have been fetched.
-// It uses a Mutex to protect concurrent access to the map.
-var fetchedData = struct {
-	mu   sync.Mutex      // Mutex to synchronize access to the 'urls' map
-	urls map[string]bool // Map to store visited URLs (true if visited)
-}{
-	urls: make(map[string]bool), // Initialize the map
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
 
-// --- Modified Crawl Function ---
-
-// Crawl uses fetcher to recursively crawl
-// pages starting with url, to a maximum of depth.
-// It now takes a WaitGroup pointer to coordinate goroutines.
-func Crawl(url string, depth int, fetcher Fetcher, wg *sync.WaitGroup) {
-	// Decrement the WaitGroup counter when this goroutine finishes.
-	// This ensures that the main goroutine knows when all child goroutines are done.
-	defer wg.Done()
+// run crawls as the flags say. It returns, rather than exits, on error,
+// so that the crawl state is closed, and synced, whatever happens.
+func run() (err error) {
+	workers := flag.Int("workers", defaultWorkers, "pages to fetch at once")
+	depth := flag.Int("depth", 4, "levels of links to crawl, counting the first page")
+	timeout := flag.Duration("timeout", 0, "stop crawling after this long (default no limit)")
+	agent := flag.String("agent", defaultUserAgent, "user agent to send, and to read robots.txt for")
+	perHost := flag.Int("per-host", defaultMaxPerHost, "pages to fetch at once from one host")
+	delay := flag.Duration("delay", 0, "least time between fetches from one host, unless robots.txt asks for more")
+	jitter := flag.Duration("jitter", 0, "most to add at random to each wait between fetches")
+	attempts := flag.Int("attempts", defaultMaxAttempts, "times to try a page that fails in a way that may not last")
+	graphPath := flag.String("graph", "", "write the link graph to this file, as DOT, GraphML or JSON by its extension")
+	sitemaps := flag.Bool("sitemaps", false, "also crawl the pages the site's sitemaps list")
+	sitemapPath := flag.String("write-sitemap", "", "write a sitemap.xml of the pages fetched to this file")
+	statePath := flag.String("state", "", "keep the crawl's progress in this file, and resume from it")
+	var scope Scope
+	scopeFlags(&scope.Include, "", "follow only links")
+	scopeFlags(&scope.Exclude, "exclude-", "do not follow links")
+	flag.Parse()
+	if *graphPath != "" && graphWriter(*graphPath) == nil {
+		return fmt.Errorf("-graph %s: want a .dot, .graphml or .json file", *graphPath)
+	}
 
-	if depth <= 0 {
-		return // Base case: stop crawling if depth limit reached
+	// Interrupting the crawl stops it as the timeout does: pages being
+	// fetched are given up and the rest are left unfetched.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
 	}
 
-	// Check if this URL has already been fetched or is being fetched.
-	// Lock the mutex before accessing the shared map.
-	fetchedData.mu.Lock()
-	if fetchedData.urls[url] {
-		fetchedData.mu.Unlock() // Release the lock before returning
-		return                  // URL already processed, so skip
+	// Given a URL, crawl the real web from there, politely, trying
+	// again what fails for now; otherwise crawl the canned pages of
+	// fakeFetcher. Each try waits its turn with the host.
+	start, f := "https://golang.org/", Fetcher(fetcher)
+	var lastMod LastModified
+	var seeds []Seed
+	if flag.NArg() > 0 {
+		hf := NewHTTPFetcher(nil)
+		hf.UserAgent, hf.OnResponse = *agent, lastMod.Record
+		pf := NewPoliteFetcher(hf, nil)
+		pf.UserAgent, pf.MaxPerHost, pf.Delay, pf.Jitter = *agent, *perHost, *delay, *jitter
+		rf := NewRetryFetcher(pf)
+		rf.MaxAttempts = *attempts
+		start, f = flag.Arg(0), rf
+
+		if *sitemaps {
+			var err error
+			seeds, err = NewSitemapReader(pf).Discover(ctx, start)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "reading sitemaps:", err)
+			}
+			fmt.Printf("%d pages listed in sitemaps.\n\n", len(seeds))
+		}
 	}
-	fetchedData.urls[url] = true // Mark this URL as being fetched
-	fetchedData.mu.Unlock()      // Release the lock immediately after marking
 
-	// Fetch the URL's content.
-	body, urls, err := fetcher.Fetch(url)
-	if err != nil {
-		fmt.Println(err) // Print error but don't stop other crawls
-		return
+	// The Crawler's workers replace the goroutine per link, and its
+	// frontier the fetchedData map, of the exercise's solution.
+	var graph Graph
+	c := &Crawler{Fetcher: f, Workers: *workers, Scope: &scope, Seeds: seeds, OnPage: func(r PageResult) {
+		graph.Add(r)
+		if r.Err != nil {
+			fmt.Println(r.Err)
+			return
+		}
+		fmt.Printf("found: %s %q\n", r.URL, excerpt(r.Body))
+	}}
+	if *statePath != "" {
+		// err is run's own, which the deferred Close reports into.
+		var state *State
+		state, err = OpenState(*statePath)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := state.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		if done := len(state.done); done > 0 {
+			// Every page done with was queued first.
+			fmt.Printf("Resuming: %d pages done, %d queued.\n\n", done, len(state.pending)-done)
+		}
+		c.State = state
 	}
-	fmt.Printf("found: %s %q\n", url, body)
+	sum := c.Crawl(ctx, start, *depth)
+	if sum.Err != nil {
+		fmt.Printf("\nWeb crawl stopped: %v.\n", sum.Err)
+	} else {
+		fmt.Println("\nWeb crawl finished.")
+	}
+	fmt.Printf("%d pages fetched, %d failed, %d abandoned.\n", sum.Fetched, sum.Failed, len(sum.Abandoned))
 
-	// For each found URL, kick off a new goroutine to crawl it.
-	for _, u := range urls {
-		// Increment the WaitGroup counter for each new goroutine.
-		wg.Add(1)
-		// Start a new goroutine for the recursive crawl.
-		go Crawl(u, depth-1, fetcher, wg)
+	stats := graph.Stats()
+	for _, b := range stats.Broken {
+		fmt.Printf("broken link: %s -> %s\n", b.From, b.To)
+	}
+	for _, u := range stats.Orphans {
+		fmt.Printf("orphan page: %s\n", u)
+	}
+	top := graph.Nodes()
+	slices.SortStableFunc(top, func(a, b *GraphNode) int { return cmp.Compare(stats.PageRank[b.URL], stats.PageRank[a.URL]) })
+	for _, n := range top[:min(3, len(top))] {
+		fmt.Printf("top page: %s (PageRank %.3f)\n", n.URL, stats.PageRank[n.URL])
+	}
+	if *graphPath != "" {
+		if err := writeFile(*graphPath, func(w io.Writer) error { return graphWriter(*graphPath)(&graph, w) }); err != nil {
+			return err
+		}
+	}
+	if *sitemapPath != "" {
+		urls := graph.Sitemap(lastMod.Get)
+		if err := writeFile(*sitemapPath, func(w io.Writer) error { return WriteSitemap(w, urls) }); err != nil {
+			return err
+		}
 	}
-	// The function implicitly returns here after the loop.
+	return nil
 }
 
-// --- Main Function (Modified to use concurrency) ---
+// graphWriter returns the method of Graph writing the format path's
+// extension names, or nil if it names none.
+func graphWriter(path string) func(*Graph, io.Writer) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".dot", ".gv":
+		return (*Graph).WriteDOT
+	case ".graphml":
+		return (*Graph).WriteGraphML
+	case ".json":
+		return (*Graph).WriteJSON
+	}
+	return nil
+}
 
-func main() {
-	var wg sync.WaitGroup // Create a WaitGroup instance.
+// writeFile creates the file at path and has write write it.
+func writeFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
 
-	// Initial call to Crawl.
-	// Increment the WaitGroup counter for the very first crawl operation.
-	wg.Add(1)
-	go Crawl("https://golang.org/", 4, fetcher, &wg) // Start the initial crawl in a goroutine.
+// scopeFlags defines the flags host, path and match, with prefix, each
+// adding a rule to rules every time it is given.
+func scopeFlags(rules *[]ScopeRule, prefix, usage string) {
+	flag.Func(prefix+"host", usage+" to this host, or to this domain if it starts with *. (repeatable)", func(s string) error {
+		*rules = append(*rules, ScopeRule{Host: s})
+		return nil
+	})
+	flag.Func(prefix+"path", usage+" whose path starts with this (repeatable)", func(s string) error {
+		*rules = append(*rules, ScopeRule{PathPrefix: s})
+		return nil
+	})
+	flag.Func(prefix+"match", usage+" matching this regular expression (repeatable)", func(s string) error {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return err
+		}
+		*rules = append(*rules, ScopeRule{Pattern: re})
+		return nil
+	})
+}
 
-	// Wait for all goroutines (the initial one and all its children) to complete.
-	wg.Wait()
-	fmt.Println("\nWeb crawl finished.")
+// excerpt shortens a page body for printing: real pages are far longer
+// than the canned ones.
+func excerpt(body string) string {
+	const max = 60
+	if r := []rune(body); len(r) > max {
+		return string(r[:max]) + "..."
+	}
+	return body
 }
 
 // --- Provided fakeFetcher (for testing purposes) ---
@@ -90,7 +220,10 @@ type fakeResult struct {
 	urls []string
 }
 
-func (f fakeFetcher) Fetch(url string) (string, []string, error) {
+func (f fakeFetcher) Fetch(ctx context.Context, url string) (string, []string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", nil, err
+	}
 	if res, ok := f[url]; ok {
 		return res.body, res.urls, nil
 	}